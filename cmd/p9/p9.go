@@ -0,0 +1,102 @@
+// Binary p9 is a 9P2000.L client for the command line, to work with the files
+// of a server without mounting it.
+//
+// Usage:
+//
+//	p9 [flags] addr command [args...]
+//
+// For example, with a server started with "p9ufs -root /srv 127.0.0.1:3333":
+//
+//	p9 127.0.0.1:3333 ls -l /
+//	p9 127.0.0.1:3333 put notes.txt /notes.txt
+//	p9 -unix -aname home /run/p9.sock cat /alice/notes.txt
+//
+// Paths are relative to the attached root. Failures are printed with the
+// name of the error number the server returned, e.g. ENOENT, and exit with
+// status 1.
+//
+// The commands are:
+//
+//	ls [-l] [path...]                 list directories
+//	stat path...                      print attributes
+//	cat path...                       print contents
+//	get remote [local]                copy a file from the server
+//	put local [remote]                copy a file to the server
+//	mkdir [-m mode] path...           make directories
+//	rm [-r] path...                   remove files, and directories with -r
+//	mv old new                        rename a file
+//	ln [-s] target path               make a hard link, or symbolic link with -s
+//	readlink path...                  print symbolic link targets
+//	chmod mode path...                change permissions
+//	getfattr [-n name] path...        print extended attributes
+//	setfattr -n name -v value path... set an extended attribute
+//	setfattr -x name path...          remove an extended attribute
+//	df [path]                         print file system usage
+//	lock [-r] [-w] path               lock a file until interrupted
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/hugelgupf/p9/internal/cli"
+)
+
+var flags = cli.AddFlags(flag.CommandLine)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] addr command [args...]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(flag.CommandLine.Output(), "\nCommands:\n")
+	names := make([]string, 0, len(cli.Commands))
+	for name := range cli.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s %s\n", name, cli.Commands[name].Usage)
+	}
+}
+
+func run() error {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 2 {
+		usage()
+		os.Exit(2)
+	}
+	addr, name, args := flag.Arg(0), flag.Arg(1), flag.Args()[2:]
+	cmd, ok := cli.Commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "p9: unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	c, root, err := flags.Attach(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	defer root.Close()
+
+	e := &cli.Env{Root: root, Cwd: "/", Stdout: os.Stdout}
+	if err := cmd.Run(e, args); err != nil {
+		if errors.Is(err, cli.ErrUsage) {
+			fmt.Fprintf(os.Stderr, "Usage: p9 [flags] addr %s %s\n", name, cmd.Usage)
+			os.Exit(2)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "p9: %s\n", cli.ErrString(err))
+		os.Exit(1)
+	}
+}
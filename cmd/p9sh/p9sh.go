@@ -0,0 +1,365 @@
+// Binary p9sh is an interactive 9P2000.L shell, which keeps a session with a
+// server open to explore it and debug it.
+//
+// Usage:
+//
+//	p9sh [flags] addr
+//
+// Besides the commands of p9 other than lock, the shell has:
+//
+//	cd [path]       change the current directory, which starts at the root
+//	pwd             print the current directory
+//	raw [message]   send a raw T-message, or enter raw mode without one
+//	help            list commands
+//	exit            leave the shell
+//
+// Arguments are separated by spaces, without quoting. Tab completes command
+// names and the names of remote files.
+//
+// Raw messages are sent as given, e.g. "raw walk 100 101 etc passwd", and the
+// R-message received for each is printed. In raw mode every line is a raw
+// message, and "help" lists them; "exit" leaves raw mode. Fids are not
+// allocated for raw messages: the shell's own files use fids counting up from
+// 1, so use large fids such as 100.
+//
+// If standard input is not a terminal, commands are read from it without
+// prompting, e.g. to replay a session that shows a bug:
+//
+//	p9sh 127.0.0.1:3333 < session.txt
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hugelgupf/p9/internal/cli"
+	"github.com/hugelgupf/p9/internal/rawmsg"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/term"
+)
+
+var flags = cli.AddFlags(flag.CommandLine)
+
+// builtins are the commands of the shell that are not in cli.Commands.
+var builtins = map[string]string{
+	"cd":   "[path]",
+	"pwd":  "",
+	"raw":  "[message]",
+	"help": "",
+	"exit": "",
+}
+
+// errExit is returned by the exit command.
+var errExit = errors.New("exit")
+
+type shell struct {
+	client *p9.Client
+	env    *cli.Env
+
+	// raw is set in raw mode.
+	raw bool
+}
+
+// usages returns the usage of the commands of the shell, by name.
+func usages() map[string]string {
+	u := make(map[string]string)
+	for name, cmd := range cli.Commands {
+		// lock waits for an interrupt, which the shell does not get.
+		if name != "lock" {
+			u[name] = cmd.Usage
+		}
+	}
+	for name, usage := range builtins {
+		u[name] = usage
+	}
+	return u
+}
+
+func (s *shell) prompt() string {
+	if s.raw {
+		return "raw> "
+	}
+	return "p9:" + s.env.Cwd + "> "
+}
+
+func (s *shell) help() {
+	if s.raw {
+		for _, m := range p9.RawMessages(rawmsg.Token{}) {
+			fmt.Fprintf(s.env.Stdout, "  %s\n", m)
+		}
+		fmt.Fprintf(s.env.Stdout, "  exit\n")
+		return
+	}
+	u := usages()
+	names := make([]string, 0, len(u))
+	for name := range u {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.env.Stdout, "  %s %s\n", name, u[name])
+	}
+}
+
+func (s *shell) cd(args []string) error {
+	p := "/"
+	switch len(args) {
+	case 0:
+	case 1:
+		p = args[0]
+	default:
+		return fmt.Errorf("usage: cd %s", builtins["cd"])
+	}
+	f, err := s.env.Walk(p)
+	if err != nil {
+		return fmt.Errorf("cd: %w", err)
+	}
+	_, _, attr, err := f.GetAttr(p9.AttrMask{Mode: true})
+	if err == nil && !attr.Mode.IsDir() {
+		err = linux.ENOTDIR
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cd: %s: %w", p, err)
+	}
+	if s.env.Dir != nil {
+		s.env.Dir.Close()
+	}
+	s.env.Dir, s.env.Cwd = f, s.env.Abs(p)
+	return nil
+}
+
+func (s *shell) rawLine(line string) error {
+	r, err := s.client.SendRaw(rawmsg.Token{}, line)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.env.Stdout, r)
+	return nil
+}
+
+// run runs the command on line.
+func (s *shell) run(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+	name, args := args[0], args[1:]
+
+	if s.raw {
+		switch name {
+		case "exit":
+			s.raw = false
+		case "help":
+			s.help()
+		default:
+			return s.rawLine(line)
+		}
+		return nil
+	}
+
+	switch name {
+	case "exit":
+		return errExit
+	case "help":
+		s.help()
+		return nil
+	case "pwd":
+		fmt.Fprintln(s.env.Stdout, s.env.Cwd)
+		return nil
+	case "cd":
+		return s.cd(args)
+	case "raw":
+		if len(args) == 0 {
+			s.raw = true
+			return nil
+		}
+		return s.rawLine(strings.Join(args, " "))
+	}
+
+	cmd, ok := cli.Commands[name]
+	if !ok || name == "lock" {
+		return fmt.Errorf("unknown command %q, try help", name)
+	}
+	if err := cmd.Run(s.env, args); errors.Is(err, cli.ErrUsage) {
+		return fmt.Errorf("usage: %s %s", name, cmd.Usage)
+	} else if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// complete completes the word before pos in line: the first word from the
+// names of commands, and the others from the names of remote files.
+func (s *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	start := strings.LastIndexAny(line[:pos], " \t") + 1
+	word := line[start:pos]
+
+	var dir string
+	var candidates []string
+	switch {
+	case strings.TrimSpace(line[:start]) == "" && s.raw:
+		for _, m := range p9.RawMessages(rawmsg.Token{}) {
+			name, _, _ := strings.Cut(m, " ")
+			candidates = append(candidates, name)
+		}
+		candidates = append(candidates, "exit", "help")
+	case strings.TrimSpace(line[:start]) == "":
+		for name := range usages() {
+			candidates = append(candidates, name)
+		}
+	case !s.raw:
+		dir, _ = path.Split(word)
+		d := dir
+		if d == "" {
+			d = "."
+		}
+		entries, err := s.env.ReadDir(d)
+		if err != nil {
+			return "", 0, false
+		}
+		for _, e := range entries {
+			name := e.Name
+			if e.Type == p9.TypeDir {
+				name += "/"
+			}
+			candidates = append(candidates, name)
+		}
+	}
+
+	prefix := word[len(dir):]
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+	if len(matches) == 1 && !strings.HasSuffix(completion, "/") {
+		completion += " "
+	}
+	if completion == prefix {
+		return "", 0, false
+	}
+	return line[:start] + dir + completion + line[pos:], start + len(dir) + len(completion), true
+}
+
+// interactive runs commands read from the terminal on stdin.
+func (s *shell) interactive() error {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, s.prompt())
+	if w, h, err := term.GetSize(fd); err == nil && w > 0 {
+		t.SetSize(w, h)
+	}
+	t.AutoCompleteCallback = s.complete
+	s.env.Stdout = t
+
+	for {
+		t.SetPrompt(s.prompt())
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			fmt.Fprintln(t)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := s.run(line); err == errExit {
+			return nil
+		} else if err != nil {
+			fmt.Fprintln(t, cli.ErrString(err))
+		}
+	}
+}
+
+// script runs commands read from stdin, and returns an error if any of them
+// failed.
+func (s *shell) script() error {
+	s.env.Stdout = os.Stdout
+	var failed bool
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := s.run(scanner.Text()); err == errExit {
+			break
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "p9sh: %s\n", cli.ErrString(err))
+			failed = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some commands failed")
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] addr\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func run() error {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	c, root, err := flags.Attach(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	defer root.Close()
+
+	s := &shell{
+		client: c,
+		env:    &cli.Env{Root: root, Cwd: "/"},
+	}
+	defer func() {
+		if s.env.Dir != nil {
+			s.env.Dir.Close()
+		}
+	}()
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return s.interactive()
+	}
+	return s.script()
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "p9sh: %s\n", cli.ErrString(err))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hugelgupf/p9/fsimpl/export"
+	"github.com/hugelgupf/p9/fsimpl/idmap"
+	"github.com/hugelgupf/p9/fsimpl/localfs"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// config is the configuration file given with -config.
+type config struct {
+	// Exports are the exports by attach name. Clients that give no attach
+	// name attach to the export named "", if any.
+	Exports map[string]exportConfig `json:"exports"`
+}
+
+// exportConfig describes an export. Its fields match the flags of the same
+// names.
+type exportConfig struct {
+	Root      string `json:"root"`
+	Options   string `json:"options"`
+	ReadOnly  bool   `json:"ro"`
+	UIDMap    string `json:"uid_map"`
+	GIDMap    string `json:"gid_map"`
+	MultiUser bool   `json:"multi_user"`
+}
+
+// attacher returns the attacher serving the export.
+func (e exportConfig) attacher() (p9.Attacher, error) {
+	if e.Root == "" {
+		return nil, fmt.Errorf("no root")
+	}
+	uids, err := idmap.ParseMap(e.UIDMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UID map: %w", err)
+	}
+	gids, err := idmap.ParseMap(e.GIDMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GID map: %w", err)
+	}
+	opts, err := export.ParseOptions(e.Options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	if e.ReadOnly {
+		opts.ReadOnly = true
+	}
+
+	var fsOpts []localfs.Opt
+	if e.MultiUser {
+		fsOpts = append(fsOpts, localfs.WithMultiUser())
+	}
+	a := localfs.Attacher(e.Root, fsOpts...)
+	if uids != nil || gids != nil {
+		a = idmap.Attacher(a, uids, gids)
+	}
+	return export.Attacher(a, opts), nil
+}
+
+// loadConfig returns the table of exports in the configuration file at path.
+func loadConfig(path string) (export.Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var c config
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(c.Exports) == 0 {
+		return nil, fmt.Errorf("%s: no exports", path)
+	}
+	table := make(export.Table, len(c.Exports))
+	for name, e := range c.Exports {
+		if strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%s: export name %q contains a slash", path, name)
+		}
+		a, err := e.attacher()
+		if err != nil {
+			return nil, fmt.Errorf("%s: export %q: %w", path, name, err)
+		}
+		table[name] = a
+	}
+	return table, nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// listenFDs returns nil, as socket activation is not supported.
+func listenFDs() []*os.File {
+	return nil
+}
+
+// socketState returns that f is not a socket, as inherited sockets are not
+// supported.
+func socketState(f *os.File) (socket, listening bool, err error) {
+	return false, false, nil
+}
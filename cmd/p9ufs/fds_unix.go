@@ -0,0 +1,61 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// listenFDs returns the file descriptors passed by systemd socket
+// activation, if any, and removes its environment variables so that they are
+// not inherited.
+func listenFDs() []*os.File {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	files := make([]*os.File, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		files = append(files, os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd)))
+	}
+	return files
+}
+
+// socketState returns whether f is a socket, and if so, whether it is
+// listening for connections.
+func socketState(f *os.File) (socket, listening bool, err error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return false, false, err
+	}
+	var (
+		v    int
+		serr error
+	)
+	if err := rc.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+	}); err != nil {
+		return false, false, err
+	}
+	if serr == syscall.ENOTSOCK {
+		return false, false, nil
+	}
+	if serr != nil {
+		return false, false, os.NewSyscallError("getsockopt", serr)
+	}
+	return true, v != 0, nil
+}
@@ -21,29 +21,105 @@
 // Then, connect using the Linux 9P filesystem:
 //
 //	mount -t 9p -o trans=tcp,port=3333 127.0.0.1 /mnt
+//
+// With -config, several exports are served, each with its own root and
+// options, and clients select one by attach name, e.g. with
+// "mount -t 9p -o aname=home". The file names the exports and gives the
+// options of each, with the names of the corresponding flags:
+//
+//	{
+//		"exports": {
+//			"home": {"root": "/home", "options": "root_squash"},
+//			"scratch": {"root": "/scratch", "ro": true, "uid_map": "0:100000:65536"}
+//		}
+//	}
+//
+// Instead of listening on an address, the server can serve a single
+// connection on stdin and stdout with -stdio, e.g. under ssh or inetd, or on
+// inherited file descriptors with -fd or -rfd and -wfd, e.g. those passed by
+// a VMM or used with "mount -t 9p -o trans=fd". Sockets passed by systemd
+// socket activation are served if no address is given. Single connections
+// are served until the client closes them.
+//
+// With -tls-cert and -tls-key, the server only accepts TLS connections, e.g.
+// from clients using p9.DialTLS. With -tls-client-ca, clients must also
+// present a certificate signed by one of the given CAs, and attach as the
+// user named by the certificate's common name.
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
+	"strings"
+	"sync"
 
-	"github.com/hugelgupf/p9/fsimpl/localfs"
 	"github.com/hugelgupf/p9/p9"
 	"github.com/u-root/uio/ulog"
 )
 
 var (
-	verbose = flag.Bool("v", false, "verbose logging")
-	root    = flag.String("root", "/", "root dir of file system to expose")
-	unix    = flag.Bool("unix", false, "use unix domain socket instead of TCP")
+	verbose     = flag.Bool("v", false, "verbose logging")
+	root        = flag.String("root", "/", "root dir of file system to expose")
+	unix        = flag.Bool("unix", false, "use unix domain socket instead of TCP")
+	ro          = flag.Bool("ro", false, "export the file system read-only")
+	options     = flag.String("o", "", "comma-separated export options: ro, root_squash, all_squash, anonuid=N, anongid=N, nosuid, nodev")
+	uidMap      = flag.String("uid-map", "", "map client to host user IDs, as comma-separated client:host:length ranges (e.g. 0:100000:65536)")
+	gidMap      = flag.String("gid-map", "", "map client to host group IDs, as comma-separated client:host:length ranges (e.g. 0:100000:65536)")
+	configFile  = flag.String("config", "", "serve the exports in this JSON file, selected by the clients' attach names, instead of -root")
+	multiUser   = flag.Bool("multi-user", false, "perform file system operations with the credentials of the attaching user (requires root)")
+	donateFDs   = flag.Bool("donate-fds", false, "with -unix, send clients the host file descriptors of opened files")
+	sharedMem   = flag.Bool("shared-memory", false, "with -unix, let clients move their connection to shared memory (Linux only)")
+	stdio       = flag.Bool("stdio", false, "serve a single connection on stdin and stdout")
+	fd          = flag.Int("fd", -1, "serve on this inherited file descriptor, a socket or a file that is both read and written")
+	rfd         = flag.Int("rfd", -1, "with -wfd, serve a single connection reading from this inherited file descriptor")
+	wfd         = flag.Int("wfd", -1, "with -rfd, serve a single connection writing to this inherited file descriptor")
+	tlsCert     = flag.String("tls-cert", "", "serve TLS with the certificate chain in this PEM file")
+	tlsKey      = flag.String("tls-key", "", "PEM file with the private key of -tls-cert")
+	tlsClientCA = flag.String("tls-client-ca", "", "require client certificates signed by a CA in this PEM file; clients attach as the certificate's common name")
 )
 
+// tlsConfig returns the TLS configuration given by the flags, or nil if TLS
+// is not used.
+func tlsConfig() (*tls.Config, error) {
+	if *tlsCert == "" && *tlsKey == "" && *tlsClientCA == "" {
+		return nil, nil
+	}
+	if *tlsCert == "" || *tlsKey == "" {
+		return nil, errors.New("-tls-cert and -tls-key are required for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if *tlsClientCA != "" {
+		pem, err := os.ReadFile(*tlsClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", *tlsClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
 // Prints custom help to document addr:port argument
 func Usage() {
 	fmt.Print("p9ufs - local 9P2000.L server in userspace\n\n")
-	fmt.Printf("usage: %s [options] <bind-addr:port>\n\noptions:\n", os.Args[0])
+	fmt.Printf("usage: %s [options] <bind-addr:port>\n", os.Args[0])
+	fmt.Printf("       %s [options] -stdio | -fd N | -rfd N -wfd M\n\noptions:\n", os.Args[0])
 	// print options to stdout
 	flag.CommandLine.SetOutput(os.Stdout)
 	flag.PrintDefaults()
@@ -66,30 +142,171 @@ func main() {
 		os.Exit(1)
 	}
 	// - print usage if no params given
-	if len(flag.Args()) != 1 {
+	activated := listenFDs()
+	switch modes := countTrue(len(flag.Args()) > 0, *stdio, *fd >= 0, *rfd >= 0 || *wfd >= 0, len(activated) > 0); {
+	case modes == 0:
 		Usage()
 		os.Exit(0)
+	case modes > 1 || len(flag.Args()) > 1:
+		fmt.Fprintf(os.Stderr, "give only one of an address, -stdio, -fd or -rfd and -wfd\n")
+		os.Exit(1)
+	case (*rfd >= 0) != (*wfd >= 0):
+		fmt.Fprintf(os.Stderr, "-rfd and -wfd must be given together\n")
+		os.Exit(1)
 	}
 
-	var network string
-	if *unix {
-		network = "unix"
+	var attacher p9.Attacher
+	if *configFile != "" {
+		var conflicts []string
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "root", "o", "ro", "uid-map", "gid-map", "multi-user":
+				conflicts = append(conflicts, "-"+f.Name)
+			}
+		})
+		if len(conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "-config cannot be combined with %s\n", strings.Join(conflicts, ", "))
+			os.Exit(1)
+		}
+		attacher, err = loadConfig(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -config: %v\n", err)
+			os.Exit(1)
+		}
 	} else {
-		network = "tcp"
+		attacher, err = exportConfig{
+			Root:      *root,
+			Options:   *options,
+			ReadOnly:  *ro,
+			UIDMap:    *uidMap,
+			GIDMap:    *gidMap,
+			MultiUser: *multiUser,
+		}.attacher()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid export: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	// Bind and listen on the socket.
-	serverSocket, err := net.Listen(network, flag.Args()[0])
+	tlsConf, err := tlsConfig()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "err binding: %v\n", err)
-		os.Exit(2)
+		fmt.Fprintf(os.Stderr, "invalid TLS configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	if tlsConf != nil && (*stdio || *rfd >= 0) {
+		fmt.Fprintf(os.Stderr, "TLS requires a socket\n")
+		os.Exit(1)
 	}
 
 	var opts []p9.ServerOpt
 	if *verbose {
 		opts = append(opts, p9.WithServerLogger(ulog.Log))
 	}
+	if *donateFDs {
+		opts = append(opts, p9.WithFDDonation())
+	}
+	if *sharedMem {
+		opts = append(opts, p9.WithServerSharedMemory())
+	}
 	// Run the server.
-	s := p9.NewServer(localfs.Attacher(*root), opts...)
-	s.Serve(serverSocket)
+	s := p9.NewServer(attacher, opts...)
+	switch {
+	case *stdio:
+		err = s.Handle(os.Stdin, os.Stdout)
+	case *rfd >= 0:
+		err = s.Handle(os.NewFile(uintptr(*rfd), "rfd"), os.NewFile(uintptr(*wfd), "wfd"))
+	case *fd >= 0:
+		err = serveFile(s, tlsConf, os.NewFile(uintptr(*fd), "fd"))
+	case len(activated) > 0:
+		err = serveFiles(s, tlsConf, activated)
+	default:
+		var network string
+		if *unix {
+			network = "unix"
+		} else {
+			network = "tcp"
+		}
+
+		// Bind and listen on the socket.
+		serverSocket, err := net.Listen(network, flag.Args()[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "err binding: %v\n", err)
+			os.Exit(2)
+		}
+		if tlsConf != nil {
+			serverSocket = tls.NewListener(serverSocket, tlsConf)
+		}
+		s.Serve(serverSocket)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func countTrue(bs ...bool) int {
+	var n int
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// serveFiles serves the inherited files f concurrently, until all of them
+// are done.
+func serveFiles(s *p9.Server, tlsConf *tls.Config, files []*os.File) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(files))
+	for i, f := range files {
+		wg.Add(1)
+		go func(i int, f *os.File) {
+			defer wg.Done()
+			errs[i] = serveFile(s, tlsConf, f)
+		}(i, f)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// serveFile serves the inherited file f, which is a listening socket, a
+// connected socket, or any other file that is both read and written, such
+// as a character device.
+//
+// Connected sockets and other files are served until the client closes
+// them, and listening sockets until they fail.
+func serveFile(s *p9.Server, tlsConf *tls.Config, f *os.File) error {
+	socket, listening, err := socketState(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	if !socket {
+		if tlsConf != nil {
+			return fmt.Errorf("%s: TLS requires a socket", f.Name())
+		}
+		return s.Handle(f, f)
+	}
+
+	// The net package duplicates f.
+	defer f.Close()
+	if listening {
+		l, err := net.FileListener(f)
+		if err != nil {
+			return err
+		}
+		if tlsConf != nil {
+			l = tls.NewListener(l, tlsConf)
+		}
+		return s.Serve(l)
+	}
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return err
+	}
+	if tlsConf != nil {
+		conn = tls.Server(conn, tlsConf)
+	}
+	return s.Handle(conn, conn)
 }
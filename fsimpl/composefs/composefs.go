@@ -14,13 +14,18 @@
 
 // Package composefs provides a way to compose p9 files and file systems into
 // one p9 file server.
+//
+// Files and attachers can be mounted at any depth. Intermediate directories
+// are synthesized as needed, and mounts may be added and removed while the
+// file system is being served.
 package composefs
 
 import (
 	"errors"
 	"fmt"
-	"path/filepath"
+	"path"
 	"strings"
+	"sync"
 
 	"github.com/hugelgupf/p9/fsimpl/qids"
 	"github.com/hugelgupf/p9/fsimpl/readdir"
@@ -32,33 +37,161 @@ import (
 )
 
 var (
+	// ErrFlatHierarchy was returned for mount paths with more than one
+	// component.
+	//
+	// Deprecated: nested mount points are supported and this error is no
+	// longer returned.
 	ErrFlatHierarchy = errors.New("composefs only supports a flat hierarchy")
 	ErrFileExists    = errors.New("file already exists")
+	ErrInvalidPath   = errors.New("invalid mount path")
+	ErrNotMounted    = errors.New("nothing mounted at path")
 )
 
 type Opt func(fs *FS) error
 
 // FS is a p9.Attacher.
 type FS struct {
-	mounts map[string]p9.File
-	qids   *qids.PathGenerator
+	// mu protects the tree rooted at root. Walks hold it for reading,
+	// Mount and Unmount for writing.
+	mu   sync.RWMutex
+	root *node
+
+	qids *qids.PathGenerator
 }
 
-func WithMount(dir string, attacher p9.Attacher) Opt {
-	return func(fs *FS) error {
-		if strings.Contains(dir, string(filepath.Separator)) {
-			return fmt.Errorf("%w: directories are not supported: %s", ErrFlatHierarchy, dir)
+// node is either a synthesized directory or a mount point.
+type node struct {
+	// qid is the QID of a synthesized directory.
+	qid p9.QID
+
+	// children are the entries of a synthesized directory.
+	children map[string]*node
+
+	// file is the mounted file, or nil for synthesized directories.
+	file p9.File
+}
+
+func newDir(qid p9.QID) *node {
+	return &node{qid: qid, children: make(map[string]*node)}
+}
+
+func (n *node) isDir() bool {
+	return n.file == nil
+}
+
+// splitPath cleans a mount path and returns its components.
+func splitPath(p string) ([]string, error) {
+	p = path.Clean(strings.TrimPrefix(p, "/"))
+	if p == "." || p == "/" || p == ".." || strings.HasPrefix(p, "../") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPath, p)
+	}
+	return strings.Split(p, "/"), nil
+}
+
+// Mount mounts the root of attacher at dir.
+//
+// dir may contain several path components, e.g. "usr/lib/modules", in which
+// case intermediate directories are synthesized. Mount may be called while fs
+// is being served.
+func (fs *FS) Mount(dir string, attacher p9.Attacher) error {
+	names, err := splitPath(dir)
+	if err != nil {
+		return err
+	}
+	f, err := attacher.Attach()
+	if err != nil {
+		return err
+	}
+	if err := fs.mount(names, f); err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+// MountFile mounts f at name, which may contain several path components.
+//
+// MountFile may be called while fs is being served.
+func (fs *FS) MountFile(name string, f p9.File) error {
+	names, err := splitPath(name)
+	if err != nil {
+		return err
+	}
+	return fs.mount(names, f)
+}
+
+func (fs *FS) mount(names []string, f p9.File) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	n := fs.root
+	for i, name := range names[:len(names)-1] {
+		child, ok := n.children[name]
+		if !ok {
+			child = newDir(p9.QID{Type: p9.TypeDir, Path: fs.qids.NewPath()})
+			n.children[name] = child
+		} else if !child.isDir() {
+			return fmt.Errorf("%w: %s is a mount point", ErrFileExists, path.Join(names[:i+1]...))
+		}
+		n = child
+	}
+
+	last := names[len(names)-1]
+	if _, ok := n.children[last]; ok {
+		return fmt.Errorf("%w: %s", ErrFileExists, path.Join(names...))
+	}
+	n.children[last] = &node{file: qids.NewWrapperFile(f, qids.NewMapper(fs.qids))}
+	return nil
+}
+
+// Unmount removes the file or attacher mounted at dir and closes its root.
+//
+// Synthesized directories that become empty are removed as well. Files that
+// were already walked to from the mount remain usable until closed.
+func (fs *FS) Unmount(dir string) error {
+	names, err := splitPath(dir)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	parents := []*node{fs.root}
+	n := fs.root
+	for _, name := range names {
+		child, ok := n.children[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNotMounted, dir)
 		}
-		if _, ok := fs.mounts[dir]; ok {
-			return fmt.Errorf("%w: %s", ErrFileExists, dir)
+		n = child
+		if n.isDir() {
+			parents = append(parents, n)
+		} else if len(parents) != len(names) {
+			// A mount point in the middle of the path.
+			return fmt.Errorf("%w: %s", ErrNotMounted, dir)
 		}
+	}
+	if n.isDir() {
+		return fmt.Errorf("%w: %s is a directory", ErrNotMounted, dir)
+	}
 
-		f, err := attacher.Attach()
-		if err != nil {
-			return err
+	// Remove the mount point and prune any synthesized directories left
+	// empty, except for the root.
+	for i := len(names) - 1; i >= 0; i-- {
+		delete(parents[i].children, names[i])
+		if i == 0 || len(parents[i].children) > 0 {
+			break
 		}
-		fs.mounts[dir] = qids.NewWrapperFile(f, qids.NewMapper(fs.qids))
-		return nil
+	}
+	return n.file.Close()
+}
+
+// WithMount mounts the root of attacher at dir. See FS.Mount.
+func WithMount(dir string, attacher p9.Attacher) Opt {
+	return func(fs *FS) error {
+		return fs.Mount(dir, attacher)
 	}
 }
 
@@ -74,24 +207,17 @@ func WithDir(dir string, mounts ...Opt) Opt {
 	}
 }
 
+// WithFile mounts f at file. See FS.MountFile.
 func WithFile(file string, f p9.File) Opt {
 	return func(fs *FS) error {
-		if strings.Contains(file, "/") {
-			return fmt.Errorf("%w: directories are not supported: %s", ErrFlatHierarchy, file)
-		}
-		if _, ok := fs.mounts[file]; ok {
-			return fmt.Errorf("%w: %s", ErrFileExists, file)
-		}
-
-		fs.mounts[file] = qids.NewWrapperFile(f, qids.NewMapper(fs.qids))
-		return nil
+		return fs.MountFile(file, f)
 	}
 }
 
 func New(mounts ...Opt) (*FS, error) {
 	fs := &FS{
-		mounts: make(map[string]p9.File),
-		qids:   &qids.PathGenerator{},
+		root: newDir(rootQID),
+		qids: &qids.PathGenerator{},
 	}
 	for _, m := range mounts {
 		if err := m(fs); err != nil {
@@ -101,21 +227,23 @@ func New(mounts ...Opt) (*FS, error) {
 	return fs, nil
 }
 
-type root struct {
+// dir is a synthesized directory, including the root.
+type dir struct {
 	p9.DefaultWalkGetAttr
 	templatefs.ReadOnlyDir
 	templatefs.NilCloser
 
 	fs *FS
+	n  *node
 }
 
 // Attach implements p9.Attacher.Attach.
 func (fs *FS) Attach() (p9.File, error) {
-	return &root{fs: fs}, nil
+	return &dir{fs: fs, n: fs.root}, nil
 }
 
 var (
-	_ p9.File     = &root{}
+	_ p9.File     = &dir{}
 	_ p9.Attacher = &FS{}
 )
 
@@ -125,53 +253,79 @@ var (
 )
 
 // Walk implements p9.File.Walk.
-func (r *root) Walk(names []string) ([]p9.QID, p9.File, error) {
+func (d *dir) Walk(names []string) ([]p9.QID, p9.File, error) {
+	// Hold the lock across the walk into a mount, so that the mount's root
+	// is not closed by a concurrent Unmount.
+	d.fs.mu.RLock()
+	defer d.fs.mu.RUnlock()
+
 	if len(names) == 0 {
-		return nil, &root{fs: r.fs}, nil
+		return nil, &dir{fs: d.fs, n: d.n}, nil
 	}
 
-	file, ok := r.fs.mounts[names[0]]
-	if !ok {
-		return nil, nil, linux.ENOENT
-	}
+	var qids []p9.QID
+	n := d.n
+	for i, name := range names {
+		child, ok := n.children[name]
+		if !ok {
+			return nil, nil, linux.ENOENT
+		}
+		if child.isDir() {
+			qids = append(qids, child.qid)
+			n = child
+			continue
+		}
 
-	// Even if len(names) == 1, get a cloned p9.File. Never return the
-	// original root File from r.fs.mounts, because if the caller
-	// Clunks/Closes it, it may become unusable for walks (e.g. because
-	// Close closes the underlying os.File object, or whatever).
-	qids, file, err := file.Walk(names[1:])
-	if err != nil {
-		return nil, nil, err
-	}
+		// The mount point itself needs a QID, which walks from the
+		// mount's root do not include.
+		qid, _, _, err := child.file.GetAttr(p9.AttrMask{Mode: true})
+		if err != nil {
+			return nil, nil, err
+		}
+		qids = append(qids, qid)
 
-	// Clones may omit the QID. Since this is not actually a clone from the
-	// caller's perspective, get the QID.
-	if len(names) == 1 && len(qids) == 0 {
-		qid, _, _, err := file.GetAttr(p9.AttrMask{Mode: true})
+		// Even if this is the last name, get a cloned p9.File. Never
+		// return the original root File of a mount, because if the
+		// caller Clunks/Closes it, it may become unusable for walks
+		// (e.g. because Close closes the underlying os.File object, or
+		// whatever).
+		mqids, file, err := child.file.Walk(names[i+1:])
 		if err != nil {
 			return nil, nil, err
 		}
-		qids = []p9.QID{qid}
+		if i == len(names)-1 {
+			// Clones may or may not return the QID we already have.
+			return qids, file, nil
+		}
+		return append(qids, mqids...), file, nil
 	}
-	return qids, file, nil
+	return qids, &dir{fs: d.fs, n: n}, nil
 }
 
 // Open implements p9.File.Open.
-func (r *root) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
+func (d *dir) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
 	if mode.Mode() != p9.ReadOnly {
 		return p9.QID{}, 0, linux.EACCES
 	}
-	return p9.QID{}, 0, nil
+	return d.n.qid, 0, nil
 }
 
 // Readdir implements p9.File.Readdir.
-func (r *root) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
-	names := maps.Keys(r.fs.mounts)
+func (d *dir) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
+	d.fs.mu.RLock()
+	defer d.fs.mu.RUnlock()
+
+	names := maps.Keys(d.n.children)
 	slices.Sort(names)
 
 	qids := make(map[string]p9.QID)
 	for _, name := range names {
-		qid, _, _, err := r.fs.mounts[name].GetAttr(p9.AttrMask{Mode: true})
+		child := d.n.children[name]
+		if child.isDir() {
+			qids[name] = child.qid
+			continue
+		}
+		qid, _, _, err := child.file.GetAttr(p9.AttrMask{Mode: true})
 		if err != nil {
 			return p9.Dirents{}, err
 		}
@@ -181,16 +335,19 @@ func (r *root) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
 }
 
 // StatFS implements p9.File.StatFS.
-func (*root) StatFS() (p9.FSStat, error) {
+func (*dir) StatFS() (p9.FSStat, error) {
 	return p9.FSStat{}, linux.ENOSYS
 }
 
 // GetAttr implements p9.File.GetAttr.
-func (r *root) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+func (d *dir) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	d.fs.mu.RLock()
+	defer d.fs.mu.RUnlock()
+
 	attr := p9.Attr{
 		Mode:      p9.FileMode(0777) | p9.ModeDirectory,
-		NLink:     p9.NLink(1 + len(r.fs.mounts)),
+		NLink:     p9.NLink(1 + len(d.n.children)),
 		BlockSize: uint64(4096),
 	}
-	return rootQID, req, attr, nil
+	return d.n.qid, req, attr, nil
 }
@@ -15,13 +15,18 @@
 package composefs
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/hugelgupf/p9/fsimpl/localfs"
 	"github.com/hugelgupf/p9/fsimpl/staticfs"
 	"github.com/hugelgupf/p9/fsimpl/test"
+	"github.com/hugelgupf/p9/linux"
 	"github.com/hugelgupf/p9/p9"
 )
 
@@ -61,3 +66,178 @@ func TestFilesMatch(t *testing.T) {
 		}, p9.AttrMask{Mode: true, Size: true}),
 	)
 }
+
+func TestNestedMounts(t *testing.T) {
+	localfsTmp := t.TempDir()
+	setUmask()
+	if err := os.WriteFile(filepath.Join(localfsTmp, "somefile"), []byte("hahaha"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	attacher, err := New(
+		WithFile("foo.txt", staticfs.ReadOnlyFile("barbarbar")),
+		WithFile("usr/share/baz.txt", staticfs.ReadOnlyFile("barbarbarbar")),
+		WithMount("usr/lib/modules", localfs.Attacher(localfsTmp)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestReadOnlyFS(t, attacher,
+		test.WithDir("", "foo.txt", "usr"),
+		test.WithDir("usr", "share", "lib"),
+		test.WithDir("usr/lib", "modules"),
+		test.WithDir("usr/lib/modules", "somefile"),
+		test.WithFile("usr/share/baz.txt", "barbarbarbar", p9.Attr{
+			Mode:      p9.ModeRegular | 0666,
+			Size:      12,
+			BlockSize: 4096,
+		}, p9.AttrMaskAll),
+		test.WithFile("usr/lib/modules/somefile", "hahaha", p9.Attr{
+			Mode: p9.ModeRegular | 0666,
+			Size: 6,
+		}, p9.AttrMask{Mode: true, Size: true}),
+	)
+}
+
+func TestMountErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Opt
+		want error
+	}{
+		{
+			name: "duplicate",
+			opts: []Opt{
+				WithFile("usr/foo", staticfs.ReadOnlyFile("")),
+				WithFile("usr/foo", staticfs.ReadOnlyFile("")),
+			},
+			want: ErrFileExists,
+		},
+		{
+			name: "below-mount",
+			opts: []Opt{
+				WithFile("usr/foo", staticfs.ReadOnlyFile("")),
+				WithFile("usr/foo/bar", staticfs.ReadOnlyFile("")),
+			},
+			want: ErrFileExists,
+		},
+		{
+			name: "over-dir",
+			opts: []Opt{
+				WithFile("usr/foo/bar", staticfs.ReadOnlyFile("")),
+				WithFile("usr/foo", staticfs.ReadOnlyFile("")),
+			},
+			want: ErrFileExists,
+		},
+		{
+			name: "root",
+			opts: []Opt{WithFile("/", staticfs.ReadOnlyFile(""))},
+			want: ErrInvalidPath,
+		},
+		{
+			name: "dotdot",
+			opts: []Opt{WithFile("../foo", staticfs.ReadOnlyFile(""))},
+			want: ErrInvalidPath,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.opts...); !errors.Is(err, tt.want) {
+				t.Errorf("New = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountUnmount(t *testing.T) {
+	fs, err := New(WithFile("foo.txt", staticfs.ReadOnlyFile("foo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fs.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := root.Walk([]string{"a", "b", "bar.txt"}); !errors.Is(err, linux.ENOENT) {
+		t.Errorf("Walk(a/b/bar.txt) = %v, want ENOENT", err)
+	}
+
+	bar, err := staticfs.New(staticfs.WithFile("bar.txt", "bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mount("a/b", bar); err != nil {
+		t.Fatalf("Mount(a/b) = %v", err)
+	}
+	qids, f, err := root.Walk([]string{"a", "b", "bar.txt"})
+	if err != nil {
+		t.Fatalf("Walk(a/b/bar.txt) = %v", err)
+	}
+	if len(qids) != 3 {
+		t.Errorf("Walk(a/b/bar.txt) = %d QIDs, want 3", len(qids))
+	}
+	if qids[0].Type != p9.TypeDir || qids[1].Type != p9.TypeDir || qids[2].Type != p9.TypeRegular {
+		t.Errorf("Walk(a/b/bar.txt) = %v, want dir, dir, regular QIDs", qids)
+	}
+
+	if err := fs.Unmount("a"); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Unmount(a) = %v, want %v", err, ErrNotMounted)
+	}
+	if err := fs.Unmount("a/b"); err != nil {
+		t.Fatalf("Unmount(a/b) = %v", err)
+	}
+	if err := fs.Unmount("a/b"); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Unmount(a/b) = %v, want %v", err, ErrNotMounted)
+	}
+
+	// Already walked files keep working.
+	if _, _, err := f.Open(p9.ReadOnly); err != nil {
+		t.Errorf("Open = %v", err)
+	}
+	f.Close()
+
+	// Empty synthesized directories are pruned.
+	if _, _, err := root.Walk([]string{"a"}); !errors.Is(err, linux.ENOENT) {
+		t.Errorf("Walk(a) = %v, want ENOENT", err)
+	}
+	test.TestReadOnlyFS(t, fs, test.WithDir("", "foo.txt"))
+}
+
+func TestConcurrentMountWalk(t *testing.T) {
+	fs, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fs.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("dir/%d/file", i)
+			for j := 0; j < 100; j++ {
+				if err := fs.MountFile(name, staticfs.ReadOnlyFile("foo")); err != nil {
+					t.Errorf("MountFile(%s) = %v", name, err)
+					return
+				}
+				if _, f, err := root.Walk(strings.Split(name, "/")); err == nil {
+					f.GetAttr(p9.AttrMaskAll)
+					f.Close()
+				}
+				if _, err := root.Readdir(0, 100); err != nil {
+					t.Errorf("Readdir = %v", err)
+				}
+				if err := fs.Unmount(name); err != nil {
+					t.Errorf("Unmount(%s) = %v", name, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
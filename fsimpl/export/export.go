@@ -0,0 +1,349 @@
+// Package export provides a p9.Attacher wrapper that applies NFS-style export
+// options, such as root_squash and nosuid, to a file system, and a Table of
+// exports that clients select by attach name.
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/hugelgupf/p9/fsimpl/readonly"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// NobodyID is the default anonymous user and group ID.
+const NobodyID = 65534
+
+// Options are the options of an export.
+//
+// The owners given by clients when creating files or changing their owner
+// are squashed to the anonymous IDs. Owners reported by GetAttr are not
+// changed.
+type Options struct {
+	// ReadOnly exports the file system read-only.
+	ReadOnly bool
+
+	// RootSquash maps user and group ID 0 to AnonUID and AnonGID.
+	//
+	// Files created without an owner are owned by the attaching user, or
+	// by the file server's user if the client did not attach as a user,
+	// so they are squashed too if that user is root.
+	RootSquash bool
+
+	// AllSquash maps all user and group IDs to AnonUID and AnonGID.
+	AllSquash bool
+
+	// AnonUID and AnonGID are the anonymous IDs used by RootSquash and
+	// AllSquash.
+	AnonUID p9.UID
+	AnonGID p9.GID
+
+	// NoSUID strips the setuid bit from files and the setgid bit from
+	// files other than directories, on creation and SetAttr. Setgid
+	// directories only make new files inherit their group.
+	NoSUID bool
+
+	// NoDev refuses to create device nodes with EPERM.
+	NoDev bool
+}
+
+// DefaultOptions returns the options of an export without any options set.
+func DefaultOptions() Options {
+	return Options{AnonUID: NobodyID, AnonGID: NobodyID}
+}
+
+// ParseOptions parses a comma-separated list of export options, in the style
+// of exports(5):
+//
+//	ro, rw, root_squash, no_root_squash, all_squash, no_all_squash,
+//	anonuid=N, anongid=N, nosuid, suid, nodev, dev
+func ParseOptions(s string) (Options, error) {
+	o := DefaultOptions()
+	if s == "" {
+		return o, nil
+	}
+	for _, opt := range strings.Split(s, ",") {
+		name, value, hasValue := strings.Cut(opt, "=")
+		switch name {
+		case "anonuid", "anongid":
+			if !hasValue {
+				return Options{}, fmt.Errorf("export option %q requires a value", name)
+			}
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || id == uint64(p9.NoUID) {
+				return Options{}, fmt.Errorf("invalid export option %q", opt)
+			}
+			if name == "anonuid" {
+				o.AnonUID = p9.UID(id)
+			} else {
+				o.AnonGID = p9.GID(id)
+			}
+			continue
+		}
+		if hasValue {
+			return Options{}, fmt.Errorf("export option %q does not take a value", name)
+		}
+		switch name {
+		case "ro", "rw":
+			o.ReadOnly = name == "ro"
+		case "root_squash", "no_root_squash":
+			o.RootSquash = name == "root_squash"
+		case "all_squash", "no_all_squash":
+			o.AllSquash = name == "all_squash"
+		case "nosuid", "suid":
+			o.NoSUID = name == "nosuid"
+		case "nodev", "dev":
+			o.NoDev = name == "nodev"
+		default:
+			return Options{}, fmt.Errorf("unknown export option %q", opt)
+		}
+	}
+	return o, nil
+}
+
+// String returns the options in the format accepted by ParseOptions.
+func (o Options) String() string {
+	opts := []string{"rw"}
+	if o.ReadOnly {
+		opts[0] = "ro"
+	}
+	if o.RootSquash {
+		opts = append(opts, "root_squash")
+	}
+	if o.AllSquash {
+		opts = append(opts, "all_squash")
+	}
+	if o.RootSquash || o.AllSquash {
+		opts = append(opts, fmt.Sprintf("anonuid=%d", o.AnonUID), fmt.Sprintf("anongid=%d", o.AnonGID))
+	}
+	if o.NoSUID {
+		opts = append(opts, "nosuid")
+	}
+	if o.NoDev {
+		opts = append(opts, "nodev")
+	}
+	return strings.Join(opts, ",")
+}
+
+type attacher struct {
+	p9.Attacher
+	opts Options
+
+	// serverRoot is true if the file server runs as root.
+	serverRoot bool
+}
+
+// Attacher returns an attacher that exposes a's files with the given
+// options.
+func Attacher(a p9.Attacher, opts Options) p9.Attacher {
+	if opts.ReadOnly {
+		a = readonly.Attacher(a)
+	}
+	if !opts.RootSquash && !opts.AllSquash && !opts.NoSUID && !opts.NoDev {
+		return a
+	}
+	return &attacher{
+		Attacher:   a,
+		opts:       opts,
+		serverRoot: os.Geteuid() == 0,
+	}
+}
+
+// Attach implements p9.Attacher.Attach.
+func (a *attacher) Attach() (p9.File, error) {
+	f, err := a.Attacher.Attach()
+	if err != nil {
+		return nil, err
+	}
+	return &file{f, a, p9.NoUID}, nil
+}
+
+// AttachUser implements p9.UserAttacher.AttachUser.
+//
+// Squashed users attach as the anonymous user. Users given only by name are
+// looked up on the host to tell whether they are root.
+func (a *attacher) AttachUser(uname string, uid p9.UID) (p9.File, error) {
+	if !uid.Ok() && uname != "" {
+		if u, err := user.Lookup(uname); err == nil {
+			if id, err := strconv.ParseUint(u.Uid, 10, 32); err == nil {
+				uid = p9.UID(id)
+			}
+		}
+	}
+	if squashed, _ := a.squash(uid, p9.NoGID, uname == "" && a.serverRoot); squashed != uid {
+		uname, uid = "", squashed
+	}
+	f, err := p9.AttachUser(a.Attacher, uname, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &file{f, a, uid}, nil
+}
+
+// squash squashes the owner given by a client. Missing IDs stand for root's
+// if root is true.
+func (a *attacher) squash(uid p9.UID, gid p9.GID, root bool) (p9.UID, p9.GID) {
+	switch {
+	case a.opts.AllSquash:
+		return a.opts.AnonUID, a.opts.AnonGID
+
+	case a.opts.RootSquash:
+		if uid == 0 || (!uid.Ok() && root) {
+			uid = a.opts.AnonUID
+		}
+		if gid == 0 || (!gid.Ok() && root) {
+			gid = a.opts.AnonGID
+		}
+	}
+	return uid, gid
+}
+
+// permissions strips the bits not allowed by NoSUID from the permissions
+// of a file of the given type.
+func (a *attacher) permissions(mode p9.FileMode) p9.FileMode {
+	if !a.opts.NoSUID {
+		return mode
+	}
+	mode &^= p9.Setuid
+	if !mode.IsDir() {
+		mode &^= p9.Setgid
+	}
+	return mode
+}
+
+// file is a p9.File with export options applied.
+type file struct {
+	p9.File
+	a *attacher
+
+	// user is the attaching user, or NoUID if it is not known.
+	user p9.UID
+}
+
+var (
+	_ p9.File = &file{}
+)
+
+// unwrap returns the wrapped file of f, which is passed to other files'
+// methods.
+func unwrap(f p9.File) p9.File {
+	if w, ok := f.(*file); ok {
+		return w.File
+	}
+	return f
+}
+
+// owner returns the owner of a file created by the client with the given
+// owner. Files created without an owner are given to the attaching user, if
+// known, and to the file server's user otherwise.
+func (f *file) owner(uid p9.UID, gid p9.GID) (p9.UID, p9.GID) {
+	if !uid.Ok() {
+		uid = f.user
+	}
+	return f.a.squash(uid, gid, uid == 0 || (!uid.Ok() && f.a.serverRoot))
+}
+
+// Walk implements p9.File.Walk.
+func (f *file) Walk(names []string) ([]p9.QID, p9.File, error) {
+	qids, nf, err := f.File.Walk(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return qids, &file{nf, f.a, f.user}, nil
+}
+
+// WalkGetAttr implements p9.File.WalkGetAttr.
+func (f *file) WalkGetAttr(names []string) ([]p9.QID, p9.File, p9.AttrMask, p9.Attr, error) {
+	qids, nf, mask, attr, err := f.File.WalkGetAttr(names)
+	if err != nil {
+		return nil, nil, p9.AttrMask{}, p9.Attr{}, err
+	}
+	return qids, &file{nf, f.a, f.user}, mask, attr, nil
+}
+
+// ReadTo implements p9.ReaderTo.ReadTo.
+func (f *file) ReadTo(w io.Writer, count uint32, offset int64) (p9.Payload, error) {
+	return p9.ReadTo(f.File, w, count, offset)
+}
+
+// GetLock implements p9.LockGetter.GetLock.
+func (f *file) GetLock(pid int, locktype p9.LockType, start, length uint64, client string) (p9.LockInfo, error) {
+	return p9.GetLock(f.File, pid, locktype, start, length, client)
+}
+
+// SetAttr implements p9.File.SetAttr.
+func (f *file) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
+	if valid.UID || valid.GID {
+		uid, gid := f.a.squash(attr.UID, attr.GID, f.a.serverRoot)
+		if valid.UID {
+			attr.UID = uid
+		}
+		if valid.GID {
+			attr.GID = gid
+		}
+	}
+	if valid.Permissions && f.a.opts.NoSUID && attr.Permissions&(p9.Setuid|p9.Setgid) != 0 {
+		_, _, cur, err := f.File.GetAttr(p9.AttrMask{Mode: true})
+		if err != nil {
+			return err
+		}
+		attr.Permissions = f.a.permissions(cur.Mode.FileType() | attr.Permissions).Permissions()
+	}
+	return f.File.SetAttr(valid, attr)
+}
+
+// Create implements p9.File.Create.
+func (f *file) Create(name string, flags p9.OpenFlags, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.File, p9.QID, uint32, error) {
+	uid, gid = f.owner(uid, gid)
+	nf, qid, iounit, err := f.File.Create(name, flags, f.a.permissions(p9.ModeRegular|permissions).Permissions(), uid, gid)
+	if err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+	return &file{nf, f.a, f.user}, qid, iounit, nil
+}
+
+// Mkdir implements p9.File.Mkdir.
+func (f *file) Mkdir(name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	uid, gid = f.owner(uid, gid)
+	return f.File.Mkdir(name, f.a.permissions(p9.ModeDirectory|permissions).Permissions(), uid, gid)
+}
+
+// Symlink implements p9.File.Symlink.
+func (f *file) Symlink(oldName string, newName string, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	uid, gid = f.owner(uid, gid)
+	return f.File.Symlink(oldName, newName, uid, gid)
+}
+
+// Mknod implements p9.File.Mknod.
+func (f *file) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	if f.a.opts.NoDev && (mode.IsCharacterDevice() || mode.IsBlockDevice()) {
+		return p9.QID{}, linux.EPERM
+	}
+	uid, gid = f.owner(uid, gid)
+	return f.File.Mknod(name, f.a.permissions(mode), major, minor, uid, gid)
+}
+
+// Link implements p9.File.Link.
+func (f *file) Link(target p9.File, newName string) error {
+	return f.File.Link(unwrap(target), newName)
+}
+
+// Rename implements p9.File.Rename.
+func (f *file) Rename(newDir p9.File, newName string) error {
+	return f.File.Rename(unwrap(newDir), newName)
+}
+
+// RenameAt implements p9.File.RenameAt.
+func (f *file) RenameAt(oldName string, newDir p9.File, newName string) error {
+	return f.File.RenameAt(oldName, unwrap(newDir), newName)
+}
+
+// Renamed implements p9.File.Renamed.
+func (f *file) Renamed(newDir p9.File, newName string) {
+	f.File.Renamed(unwrap(newDir), newName)
+}
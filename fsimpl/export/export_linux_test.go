@@ -0,0 +1,213 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/localfs"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+const (
+	anonUID p9.UID = 1000
+	anonGID p9.GID = 100
+)
+
+// attach attaches to a fresh directory exported with opts. Mapped ownership
+// records any owner without privileges.
+func attach(t *testing.T, opts Options) (string, p9.File) {
+	t.Helper()
+	dir := t.TempDir()
+	opts.AnonUID, opts.AnonGID = anonUID, anonGID
+	root, err := Attacher(localfs.Attacher(dir, localfs.WithOwnership(localfs.OwnershipMapped)), opts).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	if _, err := root.Mkdir("probe", 0755, 0, 0); linux.ExtractErrno(err) == linux.EOPNOTSUPP {
+		t.Skip("user extended attributes are not supported")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return dir, root
+}
+
+func owner(t *testing.T, root p9.File, name string) (p9.UID, p9.GID) {
+	t.Helper()
+	_, f, err := root.Walk([]string{name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, _, attr, err := f.GetAttr(p9.AttrMaskAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attr.UID, attr.GID
+}
+
+func TestSquash(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		opts     Options
+		uid      p9.UID
+		gid      p9.GID
+		wantUID  p9.UID
+		wantGID  p9.GID
+		chownUID p9.UID
+		wantUID2 p9.UID
+	}{
+		{name: "root_squash-root", opts: Options{RootSquash: true}, uid: 0, gid: 0, wantUID: anonUID, wantGID: anonGID, chownUID: 0, wantUID2: anonUID},
+		{name: "root_squash-user", opts: Options{RootSquash: true}, uid: 42, gid: 43, wantUID: 42, wantGID: 43, chownUID: 44, wantUID2: 44},
+		{name: "all_squash", opts: Options{AllSquash: true}, uid: 42, gid: 0, wantUID: anonUID, wantGID: anonGID, chownUID: 44, wantUID2: anonUID},
+		{name: "none", opts: Options{NoDev: true}, uid: 0, gid: 0, wantUID: 0, wantGID: 0, chownUID: 44, wantUID2: 44},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, root := attach(t, tt.opts)
+			f, _, _, err := root.Create("file", p9.ReadWrite, 0644, tt.uid, tt.gid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := root.Mkdir("dir", 0755, tt.uid, tt.gid); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"file", "dir"} {
+				if uid, gid := owner(t, root, name); uid != tt.wantUID || gid != tt.wantGID {
+					t.Errorf("%s: owner = %d:%d, want %d:%d", name, uid, gid, tt.wantUID, tt.wantGID)
+				}
+			}
+
+			if err := f.SetAttr(p9.SetAttrMask{UID: true}, p9.SetAttr{UID: tt.chownUID}); err != nil {
+				t.Fatal(err)
+			}
+			if uid, _ := owner(t, root, "file"); uid != tt.wantUID2 {
+				t.Errorf("after chown to %d: owner = %d, want %d", tt.chownUID, uid, tt.wantUID2)
+			}
+		})
+	}
+}
+
+func TestNoSUID(t *testing.T) {
+	dir, root := attach(t, Options{NoSUID: true})
+
+	f, _, _, err := root.Create("file", p9.ReadWrite, 06755, p9.NoUID, p9.NoGID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := root.Mkdir("dir", 06755, p9.NoUID, p9.NoGID); err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, want os.FileMode) {
+		t.Helper()
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode() & (os.ModeSetuid | os.ModeSetgid); got != want {
+			t.Errorf("%s: setuid/setgid bits = %v, want %v", name, got, want)
+		}
+	}
+	check("file", 0)
+
+	// Setgid directories are allowed. mkdir(2) ignores the bit, so set it
+	// with SetAttr.
+	_, d, err := root.Walk([]string{"dir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.SetAttr(p9.SetAttrMask{Permissions: true}, p9.SetAttr{Permissions: 06755}); err != nil {
+		t.Fatal(err)
+	}
+	check("dir", os.ModeSetgid)
+
+	if err := f.SetAttr(p9.SetAttrMask{Permissions: true}, p9.SetAttr{Permissions: 06755}); err != nil {
+		t.Fatal(err)
+	}
+	check("file", 0)
+	fi, err := os.Stat(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Errorf("file: permissions = %v, want 0755", fi.Mode().Perm())
+	}
+}
+
+func TestNoDev(t *testing.T) {
+	_, root := attach(t, Options{NoDev: true})
+
+	if _, err := root.Mknod("chr", p9.ModeCharacterDevice|0600, 1, 3, p9.NoUID, p9.NoGID); err != linux.EPERM {
+		t.Errorf("Mknod(chr) = %v, want EPERM", err)
+	}
+	if _, err := root.Mknod("blk", p9.ModeBlockDevice|0600, 8, 0, p9.NoUID, p9.NoGID); err != linux.EPERM {
+		t.Errorf("Mknod(blk) = %v, want EPERM", err)
+	}
+	if _, err := root.Mknod("fifo", p9.ModeNamedPipe|0600, 0, 0, p9.NoUID, p9.NoGID); err != nil {
+		t.Errorf("Mknod(fifo) = %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	root, err := Attacher(localfs.Attacher(dir), Options{ReadOnly: true}).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if _, err := root.Mkdir("dir", 0755, p9.NoUID, p9.NoGID); err != linux.EROFS {
+		t.Errorf("Mkdir = %v, want EROFS", err)
+	}
+}
+
+func TestRootSquashMultiUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root")
+	}
+	// t.TempDir is not accessible to other users.
+	dir, err := os.MkdirTemp("", "export-multiuser-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	const userUID p9.UID = 1234
+	a := Attacher(localfs.Attacher(dir, localfs.WithMultiUser()), Options{RootSquash: true, AnonUID: anonUID, AnonGID: anonGID}).(p9.UserAttacher)
+	for _, tt := range []struct {
+		name    string
+		uid     p9.UID
+		wantUID uint32
+	}{
+		{"user", userUID, uint32(userUID)},
+		{"root", 0, uint32(anonUID)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := a.AttachUser("", tt.uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer root.Close()
+
+			// Files created without an owner belong to the
+			// attaching user, who is only squashed if root.
+			if _, err := root.Mkdir(tt.name, 0755, p9.NoUID, p9.NoGID); err != nil {
+				t.Fatalf("Mkdir = %v", err)
+			}
+			fi, err := os.Stat(filepath.Join(dir, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uid := fi.Sys().(*syscall.Stat_t).Uid; uid != tt.wantUID {
+				t.Errorf("owner = %d, want %d", uid, tt.wantUID)
+			}
+		})
+	}
+}
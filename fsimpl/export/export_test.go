@@ -0,0 +1,130 @@
+package export
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/localfs"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+func TestParseOptions(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want Options
+	}{
+		{s: "", want: DefaultOptions()},
+		{s: "ro", want: Options{ReadOnly: true, AnonUID: NobodyID, AnonGID: NobodyID}},
+		{s: "root_squash,anonuid=1000,anongid=100", want: Options{RootSquash: true, AnonUID: 1000, AnonGID: 100}},
+		{s: "all_squash,nosuid,nodev", want: Options{AllSquash: true, NoSUID: true, NoDev: true, AnonUID: NobodyID, AnonGID: NobodyID}},
+		{s: "ro,rw,nosuid,suid,root_squash,no_root_squash", want: DefaultOptions()},
+	} {
+		got, err := ParseOptions(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseOptions(%q) = %+v, %v, want %+v", tt.s, got, err, tt.want)
+		}
+
+		// Options survive a round trip through String.
+		if got, err := ParseOptions(tt.want.String()); err != nil || got != tt.want {
+			t.Errorf("ParseOptions(%q) = %+v, %v, want %+v", tt.want.String(), got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{
+		"foo",
+		"ro,",
+		"anonuid",
+		"anonuid=-1",
+		"anonuid=4294967295",
+		"nosuid=1",
+	} {
+		if o, err := ParseOptions(s); err == nil {
+			t.Errorf("ParseOptions(%q) = %+v, want error", s, o)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	home, scratch := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(home, "alice"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for dir, name := range map[string]string{home: "home-file", scratch: "scratch-file"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table := Table{
+		"home":    Attacher(localfs.Attacher(home), Options{ReadOnly: true}),
+		"scratch": localfs.Attacher(scratch),
+	}
+
+	srv, cli := net.Pipe()
+	s := p9.NewServer(table)
+	done := make(chan struct{})
+	go func() {
+		_ = s.Handle(srv, srv)
+		close(done)
+	}()
+	defer func() {
+		cli.Close()
+		<-done
+	}()
+	c, err := p9.NewClient(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		aname string
+		file  string
+		err   error
+	}{
+		{aname: "home", file: "home-file"},
+		{aname: "/home", file: "home-file"},
+		{aname: "scratch", file: "scratch-file"},
+		{aname: "home/alice"},
+		{aname: "", err: linux.ENOENT},
+		{aname: "alice", err: linux.ENOENT},
+		{aname: "home/nope", err: linux.ENOENT},
+	} {
+		root, err := c.Attach(tt.aname)
+		if linux.ExtractErrno(err) != linux.ExtractErrno(tt.err) {
+			t.Errorf("Attach(%q) = %v, want %v", tt.aname, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if tt.file != "" {
+			_, f, err := root.Walk([]string{tt.file})
+			if err != nil {
+				t.Errorf("Attach(%q): Walk(%s) = %v", tt.aname, tt.file, err)
+			} else {
+				f.Close()
+			}
+		}
+		root.Close()
+	}
+
+	// Each export keeps its own options.
+	root, err := c.Attach("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if _, err := root.Mkdir("dir", 0755, p9.NoUID, p9.NoGID); linux.ExtractErrno(err) != linux.EROFS {
+		t.Errorf("Mkdir in read-only export = %v, want EROFS", err)
+	}
+	root, err = c.Attach("scratch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if _, err := root.Mkdir("dir", 0755, p9.NoUID, p9.NoGID); err != nil {
+		t.Errorf("Mkdir in read-write export = %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package export
+
+import (
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// Table is a p9.ExportAttacher that serves attachers by export name.
+//
+// Clients select an export with the first element of their attach name, or
+// the export named "", if any, by giving none.
+type Table map[string]p9.Attacher
+
+var (
+	_ p9.ExportAttacher = Table{}
+	_ p9.UserAttacher   = Table{}
+)
+
+// Attach implements p9.Attacher.Attach. It attaches to the export named "".
+func (t Table) Attach() (p9.File, error) {
+	return t.AttachExport("", "", p9.NoUID)
+}
+
+// AttachUser implements p9.UserAttacher.AttachUser. It attaches to the
+// export named "".
+func (t Table) AttachUser(uname string, uid p9.UID) (p9.File, error) {
+	return t.AttachExport("", uname, uid)
+}
+
+// AttachExport implements p9.ExportAttacher.AttachExport.
+func (t Table) AttachExport(name string, uname string, uid p9.UID) (p9.File, error) {
+	a, ok := t[name]
+	if !ok {
+		return nil, linux.ENOENT
+	}
+	return p9.AttachUser(a, uname, uid)
+}
@@ -0,0 +1,329 @@
+// Package idmap provides a p9.Attacher wrapper that translates user and group
+// IDs between clients and the wrapped file system, like a user namespace's
+// uid_map and gid_map.
+//
+// IDs are translated in both directions: the owners reported by GetAttr and
+// WalkGetAttr are translated from host to client IDs, and those given to
+// SetAttr and the creation calls from client to host IDs. Directory entries
+// and locks carry no IDs, so Readdir and Lock are passed through as is.
+package idmap
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// OverflowID is reported for host IDs that are not mapped, like the kernel's
+// overflowuid and overflowgid.
+const OverflowID = 65534
+
+// A Range maps Length consecutive client IDs starting at ClientID to host
+// IDs starting at HostID.
+type Range struct {
+	ClientID uint32
+	HostID   uint32
+	Length   uint32
+}
+
+// A Map is a set of non-overlapping ranges of IDs.
+//
+// An empty Map maps every ID to itself.
+type Map []Range
+
+// ParseMap parses a comma-separated list of client:host:length ranges, e.g.
+// "0:100000:65536".
+func ParseMap(s string) (Map, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var m Map
+	for _, r := range strings.Split(s, ",") {
+		fields := strings.Split(r, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid ID range %q: want client:host:length", r)
+		}
+		var ids [3]uint32
+		for i, f := range fields {
+			id, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ID range %q: %w", r, err)
+			}
+			ids[i] = uint32(id)
+		}
+		m = append(m, Range{ClientID: ids[0], HostID: ids[1], Length: ids[2]})
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks that ranges are not empty, do not wrap around and do not
+// overlap on either side.
+func (m Map) Validate() error {
+	for i, r := range m {
+		if r.Length == 0 {
+			return fmt.Errorf("ID range %v is empty", r)
+		}
+		if uint64(r.ClientID)+uint64(r.Length) > 1<<32 || uint64(r.HostID)+uint64(r.Length) > 1<<32 {
+			return fmt.Errorf("ID range %v is out of bounds", r)
+		}
+		for _, o := range m[:i] {
+			if overlaps(r.ClientID, o.ClientID, r.Length, o.Length) || overlaps(r.HostID, o.HostID, r.Length, o.Length) {
+				return fmt.Errorf("ID ranges %v and %v overlap", o, r)
+			}
+		}
+	}
+	return nil
+}
+
+func overlaps(a, b, alen, blen uint32) bool {
+	return uint64(a) < uint64(b)+uint64(blen) && uint64(b) < uint64(a)+uint64(alen)
+}
+
+// ToHost translates a client ID to a host ID.
+func (m Map) ToHost(id uint32) (uint32, bool) {
+	if len(m) == 0 {
+		return id, true
+	}
+	for _, r := range m {
+		if id >= r.ClientID && id-r.ClientID < r.Length {
+			return r.HostID + (id - r.ClientID), true
+		}
+	}
+	return 0, false
+}
+
+// ToClient translates a host ID to a client ID.
+func (m Map) ToClient(id uint32) (uint32, bool) {
+	if len(m) == 0 {
+		return id, true
+	}
+	for _, r := range m {
+		if id >= r.HostID && id-r.HostID < r.Length {
+			return r.ClientID + (id - r.HostID), true
+		}
+	}
+	return 0, false
+}
+
+type attacher struct {
+	p9.Attacher
+	uids Map
+	gids Map
+}
+
+// Attacher returns an attacher that translates the user and group IDs of a's
+// files using uids and gids.
+//
+// Host IDs without a client ID are reported as OverflowID. Client IDs without
+// a host ID cannot be set, and return EINVAL.
+func Attacher(a p9.Attacher, uids, gids Map) p9.Attacher {
+	return &attacher{a, uids, gids}
+}
+
+// Attach implements p9.Attacher.Attach.
+func (a *attacher) Attach() (p9.File, error) {
+	f, err := a.Attacher.Attach()
+	if err != nil {
+		return nil, err
+	}
+	return &file{f, a}, nil
+}
+
+// AttachUser implements p9.UserAttacher.AttachUser.
+//
+// Users without a host ID attach as OverflowID.
+func (a *attacher) AttachUser(uname string, uid p9.UID) (p9.File, error) {
+	if uid.Ok() {
+		if id, ok := a.uids.ToHost(uint32(uid)); ok {
+			uid = p9.UID(id)
+		} else {
+			uname, uid = "", OverflowID
+		}
+	}
+	f, err := p9.AttachUser(a.Attacher, uname, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &file{f, a}, nil
+}
+
+// toHost translates the IDs given by a client. NoUID and NoGID are left as
+// they are.
+func (a *attacher) toHost(uid p9.UID, gid p9.GID) (p9.UID, p9.GID, error) {
+	if uid.Ok() {
+		id, ok := a.uids.ToHost(uint32(uid))
+		if !ok {
+			return 0, 0, linux.EINVAL
+		}
+		uid = p9.UID(id)
+	}
+	if gid.Ok() {
+		id, ok := a.gids.ToHost(uint32(gid))
+		if !ok {
+			return 0, 0, linux.EINVAL
+		}
+		gid = p9.GID(id)
+	}
+	return uid, gid, nil
+}
+
+// toClient translates the owner in attr.
+func (a *attacher) toClient(attr *p9.Attr) {
+	if id, ok := a.uids.ToClient(uint32(attr.UID)); ok {
+		attr.UID = p9.UID(id)
+	} else {
+		attr.UID = OverflowID
+	}
+	if id, ok := a.gids.ToClient(uint32(attr.GID)); ok {
+		attr.GID = p9.GID(id)
+	} else {
+		attr.GID = OverflowID
+	}
+}
+
+// file is a p9.File with translated IDs.
+type file struct {
+	p9.File
+	a *attacher
+}
+
+var (
+	_ p9.File = &file{}
+)
+
+// unwrap returns the wrapped file of f, which is passed to other files'
+// methods.
+func unwrap(f p9.File) p9.File {
+	if w, ok := f.(*file); ok {
+		return w.File
+	}
+	return f
+}
+
+// Walk implements p9.File.Walk.
+func (f *file) Walk(names []string) ([]p9.QID, p9.File, error) {
+	qids, nf, err := f.File.Walk(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return qids, &file{nf, f.a}, nil
+}
+
+// WalkGetAttr implements p9.File.WalkGetAttr.
+func (f *file) WalkGetAttr(names []string) ([]p9.QID, p9.File, p9.AttrMask, p9.Attr, error) {
+	qids, nf, mask, attr, err := f.File.WalkGetAttr(names)
+	if err != nil {
+		return nil, nil, p9.AttrMask{}, p9.Attr{}, err
+	}
+	f.a.toClient(&attr)
+	return qids, &file{nf, f.a}, mask, attr, nil
+}
+
+// GetAttr implements p9.File.GetAttr.
+func (f *file) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	qid, mask, attr, err := f.File.GetAttr(req)
+	if err != nil {
+		return qid, mask, attr, err
+	}
+	f.a.toClient(&attr)
+	return qid, mask, attr, nil
+}
+
+// ReadTo implements p9.ReaderTo.ReadTo.
+func (f *file) ReadTo(w io.Writer, count uint32, offset int64) (p9.Payload, error) {
+	return p9.ReadTo(f.File, w, count, offset)
+}
+
+// GetLock implements p9.LockGetter.GetLock.
+func (f *file) GetLock(pid int, locktype p9.LockType, start, length uint64, client string) (p9.LockInfo, error) {
+	return p9.GetLock(f.File, pid, locktype, start, length, client)
+}
+
+// SetAttr implements p9.File.SetAttr.
+func (f *file) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
+	uid, gid := p9.NoUID, p9.NoGID
+	if valid.UID {
+		uid = attr.UID
+	}
+	if valid.GID {
+		gid = attr.GID
+	}
+	uid, gid, err := f.a.toHost(uid, gid)
+	if err != nil {
+		return err
+	}
+	if valid.UID {
+		attr.UID = uid
+	}
+	if valid.GID {
+		attr.GID = gid
+	}
+	return f.File.SetAttr(valid, attr)
+}
+
+// Create implements p9.File.Create.
+func (f *file) Create(name string, flags p9.OpenFlags, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.File, p9.QID, uint32, error) {
+	uid, gid, err := f.a.toHost(uid, gid)
+	if err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+	nf, qid, iounit, err := f.File.Create(name, flags, permissions, uid, gid)
+	if err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+	return &file{nf, f.a}, qid, iounit, nil
+}
+
+// Mkdir implements p9.File.Mkdir.
+func (f *file) Mkdir(name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	uid, gid, err := f.a.toHost(uid, gid)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	return f.File.Mkdir(name, permissions, uid, gid)
+}
+
+// Symlink implements p9.File.Symlink.
+func (f *file) Symlink(oldName string, newName string, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	uid, gid, err := f.a.toHost(uid, gid)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	return f.File.Symlink(oldName, newName, uid, gid)
+}
+
+// Mknod implements p9.File.Mknod.
+func (f *file) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	uid, gid, err := f.a.toHost(uid, gid)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	return f.File.Mknod(name, mode, major, minor, uid, gid)
+}
+
+// Link implements p9.File.Link.
+func (f *file) Link(target p9.File, newName string) error {
+	return f.File.Link(unwrap(target), newName)
+}
+
+// Rename implements p9.File.Rename.
+func (f *file) Rename(newDir p9.File, newName string) error {
+	return f.File.Rename(unwrap(newDir), newName)
+}
+
+// RenameAt implements p9.File.RenameAt.
+func (f *file) RenameAt(oldName string, newDir p9.File, newName string) error {
+	return f.File.RenameAt(oldName, unwrap(newDir), newName)
+}
+
+// Renamed implements p9.File.Renamed.
+func (f *file) Renamed(newDir p9.File, newName string) {
+	f.File.Renamed(unwrap(newDir), newName)
+}
@@ -0,0 +1,99 @@
+package idmap
+
+import (
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/localfs"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+func TestAttacher(t *testing.T) {
+	dir := t.TempDir()
+	// Mapped ownership records any owner without privileges.
+	host := localfs.Attacher(dir, localfs.WithOwnership(localfs.OwnershipMapped))
+	a := Attacher(host, Map{{0, 100000, 1000}}, Map{{0, 200000, 1000}})
+
+	root, err := a.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	f, _, _, err := root.Create("file", p9.ReadWrite, 0644, 0, 10)
+	if err != nil {
+		if linux.ExtractErrno(err) == linux.EOPNOTSUPP {
+			t.Skip("user extended attributes are not supported")
+		}
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := root.Mkdir("dir", 0755, 1, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	hostRoot, err := host.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostRoot.Close()
+
+	check := func(name string, uid p9.UID, gid p9.GID, hostUID p9.UID, hostGID p9.GID) {
+		t.Helper()
+		_, f, mask, attr, err := root.WalkGetAttr([]string{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if !mask.UID || attr.UID != uid || attr.GID != gid {
+			t.Errorf("%s: WalkGetAttr owner = %d:%d, want %d:%d", name, attr.UID, attr.GID, uid, gid)
+		}
+		_, f, err = hostRoot.Walk([]string{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, attr, err = f.GetAttr(p9.AttrMaskAll)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attr.UID != hostUID || attr.GID != hostGID {
+			t.Errorf("%s: host owner = %d:%d, want %d:%d", name, attr.UID, attr.GID, hostUID, hostGID)
+		}
+	}
+	check("file", 0, 10, 100000, 200010)
+	check("dir", 1, 11, 100001, 200011)
+
+	_, f, err = root.Walk([]string{"file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.SetAttr(p9.SetAttrMask{UID: true}, p9.SetAttr{UID: 5}); err != nil {
+		t.Fatalf("SetAttr = %v", err)
+	}
+	check("file", 5, 10, 100005, 200010)
+
+	// Client IDs outside the map cannot be set.
+	if err := f.SetAttr(p9.SetAttrMask{GID: true}, p9.SetAttr{GID: 1000}); err != linux.EINVAL {
+		t.Errorf("SetAttr(unmapped GID) = %v, want EINVAL", err)
+	}
+	if _, err := root.Mkdir("unmapped", 0755, 1000, p9.NoGID); err != linux.EINVAL {
+		t.Errorf("Mkdir(unmapped UID) = %v, want EINVAL", err)
+	}
+
+	// Host IDs outside the map are reported as the overflow ID.
+	if _, err := hostRoot.Mkdir("hostonly", 0755, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	check("hostonly", OverflowID, OverflowID, 1, 1)
+
+	// Files walked to are unwrapped when passed to the wrapped file system.
+	_, d, err := root.Walk([]string{"dir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := root.RenameAt("file", d, "moved"); err != nil {
+		t.Errorf("RenameAt = %v", err)
+	}
+}
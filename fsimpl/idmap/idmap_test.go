@@ -0,0 +1,66 @@
+package idmap
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestParseMap(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want Map
+	}{
+		{s: "", want: nil},
+		{s: "0:100000:65536", want: Map{{ClientID: 0, HostID: 100000, Length: 65536}}},
+		{s: "0:1000:1,1:100000:65535", want: Map{{0, 1000, 1}, {1, 100000, 65535}}},
+		{s: "0:0:4294967295", want: Map{{0, 0, 4294967295}}},
+	} {
+		got, err := ParseMap(tt.s)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("ParseMap(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{
+		"0:100000",
+		"0:100000:65536:1",
+		"a:100000:65536",
+		"0:-1:1",
+		"0:100000:0",
+		"2:0:4294967295",
+		"0:100000:10,5:200000:10",
+		"0:100000:10,10:100005:10",
+	} {
+		if m, err := ParseMap(s); err == nil {
+			t.Errorf("ParseMap(%q) = %v, want error", s, m)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	m := Map{{0, 1000, 1}, {1, 100000, 65535}}
+	for _, tt := range []struct {
+		client, host uint32
+	}{
+		{0, 1000},
+		{1, 100000},
+		{65535, 165534},
+	} {
+		if got, ok := m.ToHost(tt.client); !ok || got != tt.host {
+			t.Errorf("ToHost(%d) = %d, %t, want %d", tt.client, got, ok, tt.host)
+		}
+		if got, ok := m.ToClient(tt.host); !ok || got != tt.client {
+			t.Errorf("ToClient(%d) = %d, %t, want %d", tt.host, got, ok, tt.client)
+		}
+	}
+	if got, ok := m.ToHost(65536); ok {
+		t.Errorf("ToHost(65536) = %d, want unmapped", got)
+	}
+	if got, ok := m.ToClient(0); ok {
+		t.Errorf("ToClient(0) = %d, want unmapped", got)
+	}
+	if got, ok := Map(nil).ToHost(42); !ok || got != 42 {
+		t.Errorf("empty map: ToHost(42) = %d, %t, want 42", got, ok)
+	}
+}
@@ -13,279 +13,202 @@
 // limitations under the License.
 
 // Package localfs exposes the host's local file system as a p9.File.
+//
+// On Linux, files are accessed through file descriptors, and every lookup is
+// made relative to a directory file descriptor without following symlinks.
+// Clients are therefore confined to the exported directory, even if symlinks
+// pointing out of it are created or raced in by other processes on the host.
+//
+// On other platforms, files are accessed by path.
+//
+// QID versions are derived from the files' modification and change times,
+// size and mode, so they change whenever a file is modified, on the host or
+// through the file server.
 package localfs
 
 import (
+	"encoding/binary"
+	"hash/fnv"
 	"os"
-	"path"
-	"path/filepath"
 
-	"github.com/hugelgupf/p9/fsimpl/templatefs"
 	"github.com/hugelgupf/p9/internal"
-	"github.com/hugelgupf/p9/linux"
 	"github.com/hugelgupf/p9/p9"
 )
 
 type attacher struct {
-	root string
-}
-
-var (
-	_ p9.Attacher = &attacher{}
-)
-
-// RootAttacher attaches at the host file system's root.
-func RootAttacher() p9.Attacher {
-	return &attacher{root: "/"}
-}
-
-// Attacher returns an attacher that exposes files under root.
-func Attacher(root string) p9.Attacher {
-	if len(root) == 0 {
-		root = "/"
-	}
-	return &attacher{root: root}
-}
+	root      string
+	ownership Ownership
 
-// Attach implements p9.Attacher.Attach.
-func (a *attacher) Attach() (p9.File, error) {
-	umask(0)
-	return &Local{path: a.root}, nil
-}
+	// quota is the maximum size in bytes reported by StatFS, if not 0.
+	quota uint64
 
-// Local is a p9.File.
-type Local struct {
-	p9.DefaultWalkGetAttr
-	templatefs.NoopFile
+	// multiUser is set by WithMultiUser.
+	multiUser bool
 
-	path string
-	file *os.File
+	// strict is set if ownership was set with WithOwnership. Otherwise,
+	// creations do not fail if the server may not change the owner.
+	strict bool
 }
 
 var (
-	_ p9.File = &Local{}
+	_ p9.Attacher = &attacher{}
 )
 
-// info constructs a QID for this file.
-func (l *Local) info() (p9.QID, os.FileInfo, error) {
-	var (
-		qid p9.QID
-		fi  os.FileInfo
-		err error
-	)
-
-	// Stat the file.
-	if l.file != nil {
-		fi, err = l.file.Stat()
-	} else {
-		fi, err = os.Lstat(l.path)
-	}
-	if err != nil {
-		return qid, nil, err
-	}
+// Ownership determines how the UID and GID given by clients in creation calls
+// are applied to the created files.
+//
+// Ownership is only applied on Linux.
+type Ownership int
+
+const (
+	// OwnershipPassthrough changes the owner of created files to the
+	// requested UID and GID. This requires CAP_CHOWN, and is the default.
+	// Unless it is set with WithOwnership, files the server may not give
+	// to the requested owner are owned by the server's user.
+	OwnershipPassthrough Ownership = iota
+
+	// OwnershipMapped records the requested UID and GID in the
+	// user.virtfs.uid and user.virtfs.gid extended attributes, like
+	// QEMU's mapped-xattr security model, and GetAttr reports them.
+	// Device nodes, FIFOs and sockets are created as regular files with
+	// their mode and device number recorded in user.virtfs.mode and
+	// user.virtfs.rdev.
+	//
+	// Extended attributes cannot be set on symlinks, so their owner is
+	// not recorded.
+	OwnershipMapped
+
+	// OwnershipNone ignores the requested UID and GID. Files are owned by
+	// the server's user.
+	OwnershipNone
+)
 
-	// Construct the QID type.
-	qid.Type = p9.ModeFromOS(fi.Mode()).QIDType()
+// Opt is an option for Attacher and RootAttacher.
+type Opt func(a *attacher)
 
-	// Save the path from the Ino.
-	ninePath, err := localToQid(l.path, fi)
-	if err != nil {
-		return qid, nil, err
+// WithOwnership sets how the ownership requested by clients is applied to
+// created files. The default is OwnershipPassthrough.
+func WithOwnership(o Ownership) Opt {
+	return func(a *attacher) {
+		a.ownership = o
+		a.strict = true
 	}
-
-	qid.Path = ninePath
-
-	return qid, fi, nil
 }
 
-// Walk implements p9.File.Walk.
-func (l *Local) Walk(names []string) ([]p9.QID, p9.File, error) {
-	var qids []p9.QID
-	last := &Local{path: l.path}
-
-	// A walk with no names is a copy of self.
-	if len(names) == 0 {
-		return nil, last, nil
-	}
-
-	for _, name := range names {
-		c := &Local{path: path.Join(last.path, name)}
-		qid, _, err := c.info()
-		if err != nil {
-			return nil, nil, err
-		}
-		qids = append(qids, qid)
-		last = c
+// WithQuota limits the file system size reported by StatFS to quota bytes.
+//
+// Only the reported size is limited, and the free space is reported as at
+// most quota. Usage of the exported directory is not accounted for, and
+// writes are not restricted.
+func WithQuota(quota uint64) Opt {
+	return func(a *attacher) {
+		a.quota = quota
 	}
-	return qids, last, nil
 }
 
-// FSync implements p9.File.FSync.
-func (l *Local) FSync() error {
-	return l.file.Sync()
-}
-
-// GetAttr implements p9.File.GetAttr.
+// WithMultiUser performs file system operations with the credentials of the
+// attaching user, so that the host's permission checks apply to clients as
+// they would to local processes. Files are created with the credentials of
+// the user requesting the creation, which are passed to Create and the like.
 //
-// Not fully implemented.
-func (l *Local) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
-	qid, fi, err := l.info()
-	if err != nil {
-		return qid, p9.AttrMask{}, p9.Attr{}, err
-	}
-
-	stat := internal.InfoToStat(fi)
-	attr := &p9.Attr{
-		Mode:             p9.FileMode(stat.Mode),
-		UID:              p9.UID(stat.Uid),
-		GID:              p9.GID(stat.Gid),
-		NLink:            p9.NLink(stat.Nlink),
-		RDev:             p9.Dev(stat.Rdev),
-		Size:             uint64(stat.Size),
-		BlockSize:        uint64(stat.Blksize),
-		Blocks:           uint64(stat.Blocks),
-		ATimeSeconds:     uint64(stat.Atim.Sec),
-		ATimeNanoSeconds: uint64(stat.Atim.Nsec),
-		MTimeSeconds:     uint64(stat.Mtim.Sec),
-		MTimeNanoSeconds: uint64(stat.Mtim.Nsec),
-		CTimeSeconds:     uint64(stat.Ctim.Sec),
-		CTimeNanoSeconds: uint64(stat.Ctim.Nsec),
+// Clients are trusted to send their users' IDs, as with NFS's AUTH_SYS.
+// Their supplementary groups are those of the same users on the host. Users
+// unknown to the host have no supplementary groups, and their group is the
+// overflow group 65534.
+//
+// The server needs CAP_SETUID and CAP_SETGID. Multi-user mode is only
+// supported on Linux, and implies OwnershipPassthrough.
+func WithMultiUser() Opt {
+	return func(a *attacher) {
+		a.multiUser = true
 	}
-	return qid, req, *attr, nil
 }
 
-// Close implements p9.File.Close.
-func (l *Local) Close() error {
-	if l.file != nil {
-		// We don't set l.file = nil, as Close is called by servers
-		// only in Clunk. Clunk should release the last (direct)
-		// reference to this file.
-		return l.file.Close()
-	}
-	return nil
+// RootAttacher attaches at the host file system's root.
+func RootAttacher(opts ...Opt) p9.Attacher {
+	return Attacher("/", opts...)
 }
 
-// Open implements p9.File.Open.
-func (l *Local) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
-	qid, _, err := l.info()
-	if err != nil {
-		return qid, 0, err
-	}
-
-	// Do the actual open.
-	f, err := os.OpenFile(l.path, int(mode), 0)
-	if err != nil {
-		return qid, 0, err
+// Attacher returns an attacher that exposes files under root.
+func Attacher(root string, opts ...Opt) p9.Attacher {
+	if len(root) == 0 {
+		root = "/"
 	}
-	l.file = f
-
-	return qid, 0, nil
-}
-
-// ReadAt implements p9.File.ReadAt.
-func (l *Local) ReadAt(p []byte, offset int64) (int, error) {
-	return l.file.ReadAt(p, offset)
-}
-
-// Lock implements p9.File.Lock.
-func (l *Local) Lock(pid int, locktype p9.LockType, flags p9.LockFlags, start, length uint64, client string) (p9.LockStatus, error) {
-	return l.lock(pid, locktype, flags, start, length, client)
-}
-
-// WriteAt implements p9.File.WriteAt.
-func (l *Local) WriteAt(p []byte, offset int64) (int, error) {
-	return l.file.WriteAt(p, offset)
-}
-
-// Create implements p9.File.Create.
-func (l *Local) Create(name string, mode p9.OpenFlags, permissions p9.FileMode, _ p9.UID, _ p9.GID) (p9.File, p9.QID, uint32, error) {
-	newName := path.Join(l.path, name)
-	f, err := os.OpenFile(newName, int(mode)|os.O_CREATE|os.O_EXCL, os.FileMode(permissions))
-	if err != nil {
-		return nil, p9.QID{}, 0, err
+	a := &attacher{root: root}
+	for _, opt := range opts {
+		opt(a)
 	}
-
-	l2 := &Local{path: newName, file: f}
-	qid, _, err := l2.info()
-	if err != nil {
-		l2.Close()
-		return nil, p9.QID{}, 0, err
+	if a.multiUser {
+		a.ownership = OwnershipPassthrough
+		a.strict = true
 	}
-	return l2, qid, 0, nil
+	return a
 }
 
-// Mkdir implements p9.File.Mkdir.
+// AttachUser implements p9.UserAttacher.AttachUser.
 //
-// Not properly implemented.
-func (l *Local) Mkdir(name string, permissions p9.FileMode, _ p9.UID, _ p9.GID) (p9.QID, error) {
-	if err := os.Mkdir(path.Join(l.path, name), os.FileMode(permissions)); err != nil {
-		return p9.QID{}, err
+// It is the same as Attach unless WithMultiUser is used.
+func (a *attacher) AttachUser(uname string, uid p9.UID) (p9.File, error) {
+	if !a.multiUser {
+		return a.Attach()
 	}
-
-	// Blank QID.
-	return p9.QID{}, nil
+	return a.attachUser(uname, uid)
 }
 
-// Symlink implements p9.File.Symlink.
-//
-// Not properly implemented.
-func (l *Local) Symlink(oldname string, newname string, _ p9.UID, _ p9.GID) (p9.QID, error) {
-	if err := os.Symlink(oldname, path.Join(l.path, newname)); err != nil {
-		return p9.QID{}, err
+// statFS returns the statistics of the file system containing path, limited
+// to the quota.
+func (a *attacher) statFS(path string) (p9.FSStat, error) {
+	st, err := internal.Statfs(path)
+	if err != nil {
+		return p9.FSStat{}, err
 	}
-
-	// Blank QID.
-	return p9.QID{}, nil
-}
-
-// Link implements p9.File.Link.
-//
-// Not properly implemented.
-func (l *Local) Link(target p9.File, newname string) error {
-	return os.Link(target.(*Local).path, path.Join(l.path, newname))
-}
-
-// RenameAt implements p9.File.RenameAt.
-func (l *Local) RenameAt(oldName string, newDir p9.File, newName string) error {
-	oldPath := path.Join(l.path, oldName)
-	newPath := path.Join(newDir.(*Local).path, newName)
-
-	return os.Rename(oldPath, newPath)
+	stat := p9.FSStat{
+		Type:            st.Type,
+		BlockSize:       st.BlockSize,
+		Blocks:          st.Blocks,
+		BlocksFree:      st.BlocksFree,
+		BlocksAvailable: st.BlocksAvailable,
+		Files:           st.Files,
+		FilesFree:       st.FilesFree,
+		FSID:            st.FSID,
+		NameLength:      st.NameLength,
+	}
+	if a.quota != 0 && stat.BlockSize != 0 {
+		blocks := a.quota / uint64(stat.BlockSize)
+		stat.Blocks = min(stat.Blocks, blocks)
+		stat.BlocksFree = min(stat.BlocksFree, blocks)
+		stat.BlocksAvailable = min(stat.BlocksAvailable, blocks)
+	}
+	return stat, nil
 }
 
-// Readlink implements p9.File.Readlink.
+// FD implements p9.HostFile.FD.
 //
-// Not properly implemented.
-func (l *Local) Readlink() (string, error) {
-	return os.Readlink(l.path)
-}
-
-// Renamed implements p9.File.Renamed.
-func (l *Local) Renamed(parent p9.File, newName string) {
-	l.path = path.Join(parent.(*Local).path, newName)
-}
-
-// SetAttr implements p9.File.SetAttr.
-func (l *Local) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
-	// When truncate(2) is called on Linux, Linux will try to set time & size. Fake it. Sorry.
-	supported := p9.SetAttrMask{Size: true, MTime: true, CTime: true, ATime: true}
-	if !valid.IsSubsetOf(supported) {
-		return linux.ENOSYS
+// Only the file descriptors of regular files are given out. Those of
+// directories would give access to files outside of the exported directory,
+// e.g. with openat(fd, "..").
+func (l *Local) FD() (*os.File, bool) {
+	if l.file == nil {
+		return nil, false
 	}
-
-	if valid.Size {
-		// If more than one thing is ever implemented, we can't just
-		// return an error here.
-		return os.Truncate(l.path, int64(attr.Size))
+	if fi, err := l.file.Stat(); err != nil || !fi.Mode().IsRegular() {
+		return nil, false
 	}
-	return nil
+	return l.file, true
 }
 
-// UnlinkAt implements p9.File.UnlinkAt
-func (l *Local) UnlinkAt(name string, flags uint32) error {
-	// Construct the full path
-	fullPath := filepath.Join(l.path, name)
-
-	// Remove the file or directory
-	return os.Remove(fullPath)
+// qidVersion derives a QID version from a file's change state, e.g. its
+// modification and change times, size and mode.
+//
+// The other attributes are worth including even with the times, as file
+// systems with coarse timestamps may not update them between two quick
+// changes.
+func qidVersion(state ...int64) uint32 {
+	h := fnv.New32a()
+	var b [8]byte
+	for _, v := range state {
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		h.Write(b[:])
+	}
+	return h.Sum32()
 }
@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"testing"
 	"time"
@@ -65,6 +66,75 @@ func TestIntegration(t *testing.T) {
 	)
 }
 
+func TestMultiUserIntegration(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("multi-user mode requires root")
+	}
+	serverSocket, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("err binding: %v", err)
+	}
+	serverPort := serverSocket.Addr().(*net.TCPAddr).Port
+
+	// Users other than root must be able to reach the directory, which
+	// is not the case for t.TempDir.
+	dir, err := os.MkdirTemp("", "localfs-multiuser-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range map[string]os.FileMode{
+		"secret": 0600,
+		"public": 0644,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "shared"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "shared"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test user may only create files in the groups the host gives
+	// it.
+	user, err := lookupCreds("", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run the server.
+	s := p9.NewServer(Attacher(dir, WithMultiUser()), p9.WithServerLogger(ulogtest.Logger{TB: t}))
+
+	// Run the multi-user tests from fsimpl/test/multiuservmtests.
+	vmtest.RunGoTestsInVM(t, []string{"github.com/hugelgupf/p9/fsimpl/test/multiuservmtests"},
+		vmtest.WithVMOpt(
+			vmtest.WithMergedInitramfs(uroot.Opts{
+				Commands: uroot.BusyBoxCmds(
+					"github.com/u-root/u-root/cmds/core/dhclient",
+				),
+			}),
+			vmtest.WithQEMUFn(
+				qemu.WithAppendKernel(fmt.Sprintf("P9_PORT=%d P9_TARGET=192.168.0.2 P9_GID=%d", serverPort, user.gid)),
+				// 192.168.0.0/24
+				vmdriver.HostNetwork(&net.IPNet{
+					IP:   net.IP{192, 168, 0, 0},
+					Mask: net.CIDRMask(24, 32),
+				}),
+				qemu.WithVMTimeout(30*time.Second),
+				qemu.WithTask(func(ctx context.Context, n *qemu.Notifications) error {
+					return s.ServeContext(ctx, serverSocket)
+				}),
+			),
+		),
+	)
+}
+
 func TestBenchmark(t *testing.T) {
 	// Uses host's dd binary.
 	vmtest.SkipIfNotArch(t, qemu.Arch(runtime.GOARCH))
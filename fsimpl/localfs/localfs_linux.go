@@ -0,0 +1,642 @@
+// Copyright 2018 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localfs
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/hugelgupf/p9/fsimpl/templatefs"
+	"github.com/hugelgupf/p9/fsimpl/xattr"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// Attach implements p9.Attacher.Attach.
+func (a *attacher) Attach() (p9.File, error) {
+	umask(0)
+	fd, err := unix.Open(a.root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: a.root, Err: err}
+	}
+	return &Local{a: a, fd: fd}, nil
+}
+
+// Local is a p9.File.
+type Local struct {
+	templatefs.NoopFile
+
+	a *attacher
+
+	// fd is an O_PATH file descriptor referring to the file. It is used
+	// as the directory file descriptor for all operations on children.
+	fd int
+
+	// file is the opened file, if Open or Create were called.
+	file *os.File
+
+	// writable is true if file was opened for writing.
+	writable bool
+
+	// dir is the state of reading a directory.
+	dir dirReader
+}
+
+var (
+	_ p9.File = &Local{}
+)
+
+// procPath returns a path that refers to the file fd refers to.
+//
+// Opening it reopens the file itself rather than resolving a path, so it can
+// be used to reopen O_PATH file descriptors.
+func procPath(fd int) string {
+	return "/proc/self/fd/" + strconv.Itoa(fd)
+}
+
+// checkName makes sure name is a single path component that stays in its
+// directory.
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return linux.EINVAL
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' {
+			return linux.EINVAL
+		}
+	}
+	return nil
+}
+
+// openat2Unsupported is set once openat2 returned ENOSYS.
+var openat2Unsupported atomic.Bool
+
+// openAt opens name in the directory dirfd without following symlinks.
+//
+// openat2's RESOLVE_BENEATH is used when the kernel supports it, even though
+// checkName and O_NOFOLLOW already guarantee that single path components are
+// resolved beneath dirfd.
+func openAt(dirfd int, name string, flags int, mode uint32) (int, error) {
+	if err := checkName(name); err != nil {
+		return -1, err
+	}
+	flags |= unix.O_NOFOLLOW | unix.O_CLOEXEC
+	for {
+		var (
+			fd  int
+			err error
+		)
+		if !openat2Unsupported.Load() {
+			fd, err = unix.Openat2(dirfd, name, &unix.OpenHow{
+				Flags:   uint64(flags),
+				Mode:    uint64(mode),
+				Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS,
+			})
+			if err == unix.ENOSYS {
+				openat2Unsupported.Store(true)
+				continue
+			}
+		} else {
+			fd, err = unix.Openat(dirfd, name, flags, mode)
+		}
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		}
+		return fd, err
+	}
+}
+
+// openFlags are the open flags passed through from clients.
+//
+// O_DIRECT is not, because the server's buffers are not aligned.
+const openFlags = unix.O_ACCMODE | unix.O_APPEND | unix.O_TRUNC | unix.O_NONBLOCK |
+	unix.O_DSYNC | unix.O_SYNC | unix.O_LARGEFILE | unix.O_NOATIME
+
+func (l *Local) stat() (unix.Stat_t, error) {
+	var stat unix.Stat_t
+	err := unix.Fstat(l.fd, &stat)
+	return stat, err
+}
+
+func statToQID(stat *unix.Stat_t) p9.QID {
+	return p9.QID{
+		Type: p9.FileMode(stat.Mode).QIDType(),
+		// ctime changes with every change to data or attributes.
+		Version: qidVersion(
+			int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec),
+			int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec),
+			stat.Size, int64(stat.Mode), int64(stat.Uid), int64(stat.Gid),
+		),
+		Path: qidPath(uint64(stat.Dev), uint64(stat.Ino)),
+	}
+}
+
+// info constructs a QID for this file.
+func (l *Local) info() (p9.QID, unix.Stat_t, error) {
+	stat, err := l.stat()
+	if err != nil {
+		return p9.QID{}, stat, err
+	}
+	return statToQID(&stat), stat, nil
+}
+
+// walk walks to names, and returns the QIDs of all names and the stat of the
+// last.
+func (l *Local) walk(names []string) ([]p9.QID, *Local, unix.Stat_t, error) {
+	// A walk with no names is a copy of self.
+	if len(names) == 0 {
+		stat, err := l.stat()
+		if err != nil {
+			return nil, nil, stat, err
+		}
+		fd, err := unix.FcntlInt(uintptr(l.fd), unix.F_DUPFD_CLOEXEC, 0)
+		if err != nil {
+			return nil, nil, stat, err
+		}
+		return nil, &Local{a: l.a, fd: fd}, stat, nil
+	}
+
+	var (
+		qids []p9.QID
+		last *Local
+		stat unix.Stat_t
+	)
+	dirfd := l.fd
+	for _, name := range names {
+		fd, err := openAt(dirfd, name, unix.O_PATH, 0)
+		if last != nil {
+			last.Close()
+		}
+		if err != nil {
+			return nil, nil, stat, err
+		}
+		last = &Local{a: l.a, fd: fd}
+		if stat, err = last.stat(); err != nil {
+			last.Close()
+			return nil, nil, stat, err
+		}
+		qids = append(qids, statToQID(&stat))
+		dirfd = fd
+	}
+	return qids, last, stat, nil
+}
+
+// Walk implements p9.File.Walk.
+func (l *Local) Walk(names []string) ([]p9.QID, p9.File, error) {
+	qids, last, _, err := l.walk(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return qids, last, nil
+}
+
+// WalkGetAttr implements p9.File.WalkGetAttr.
+//
+// The attributes are those of the stat that the walk does anyway.
+func (l *Local) WalkGetAttr(names []string) ([]p9.QID, p9.File, p9.AttrMask, p9.Attr, error) {
+	qids, last, stat, err := l.walk(names)
+	if err != nil {
+		return nil, nil, p9.AttrMask{}, p9.Attr{}, err
+	}
+	return qids, last, statAttrMask, last.attr(&stat), nil
+}
+
+// statAttrMask are the attributes filled in from a stat.
+var statAttrMask = p9.AttrMask{
+	Mode:   true,
+	NLink:  true,
+	UID:    true,
+	GID:    true,
+	RDev:   true,
+	ATime:  true,
+	MTime:  true,
+	CTime:  true,
+	INo:    true,
+	Size:   true,
+	Blocks: true,
+}
+
+// FSync implements p9.File.FSync.
+func (l *Local) FSync() error {
+	return l.file.Sync()
+}
+
+// GetAttr implements p9.File.GetAttr.
+//
+// Not fully implemented.
+func (l *Local) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	qid, stat, err := l.info()
+	if err != nil {
+		return qid, p9.AttrMask{}, p9.Attr{}, err
+	}
+	return qid, req, l.attr(&stat), nil
+}
+
+// attr converts the stat of l to attributes.
+func (l *Local) attr(stat *unix.Stat_t) p9.Attr {
+	attr := p9.Attr{
+		Mode:             p9.FileMode(stat.Mode),
+		UID:              p9.UID(stat.Uid),
+		GID:              p9.GID(stat.Gid),
+		NLink:            p9.NLink(stat.Nlink),
+		RDev:             p9.Dev(stat.Rdev),
+		Size:             uint64(stat.Size),
+		BlockSize:        uint64(stat.Blksize),
+		Blocks:           uint64(stat.Blocks),
+		ATimeSeconds:     uint64(stat.Atim.Sec),
+		ATimeNanoSeconds: uint64(stat.Atim.Nsec),
+		MTimeSeconds:     uint64(stat.Mtim.Sec),
+		MTimeNanoSeconds: uint64(stat.Mtim.Nsec),
+		CTimeSeconds:     uint64(stat.Ctim.Sec),
+		CTimeNanoSeconds: uint64(stat.Ctim.Nsec),
+	}
+	l.mapAttr(&attr)
+	return attr
+}
+
+// StatFS implements p9.File.StatFS.
+func (l *Local) StatFS() (p9.FSStat, error) {
+	return l.a.statFS(procPath(l.fd))
+}
+
+// Close implements p9.File.Close.
+func (l *Local) Close() error {
+	var err error
+	if l.file != nil {
+		// We don't set l.file = nil, as Close is called by servers
+		// only in Clunk. Clunk should release the last (direct)
+		// reference to this file.
+		err = l.file.Close()
+	}
+	if cerr := unix.Close(l.fd); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Open implements p9.File.Open.
+func (l *Local) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
+	qid, _, err := l.info()
+	if err != nil {
+		return qid, 0, err
+	}
+	if qid.Type&p9.TypeSymlink != 0 {
+		return qid, 0, linux.ELOOP
+	}
+
+	// Reopen the file l.fd refers to, not whatever is at its path now.
+	p := procPath(l.fd)
+	fd, err := unix.Open(p, int(mode)&openFlags|unix.O_CLOEXEC|unix.O_NOCTTY, 0)
+	if err != nil {
+		return qid, 0, err
+	}
+	l.file = os.NewFile(uintptr(fd), p)
+	l.writable = mode.Mode() != p9.ReadOnly
+	return qid, 0, nil
+}
+
+// ReadAt implements p9.File.ReadAt.
+func (l *Local) ReadAt(p []byte, offset int64) (int, error) {
+	return l.file.ReadAt(p, offset)
+}
+
+// WriteAt implements p9.File.WriteAt.
+func (l *Local) WriteAt(p []byte, offset int64) (int, error) {
+	return l.file.WriteAt(p, offset)
+}
+
+// Create implements p9.File.Create.
+func (l *Local) Create(name string, mode p9.OpenFlags, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.File, p9.QID, uint32, error) {
+	fd, err := openAt(l.fd, name, int(mode)&openFlags|unix.O_CREAT|unix.O_EXCL, uint32(permissions.Permissions()))
+	if err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+	f := os.NewFile(uintptr(fd), name)
+
+	// Reopen the created file, rather than looking up name again. A
+	// duplicate of fd would share its open file description, and with
+	// it, its locks.
+	pathfd, err := unix.Open(procPath(fd), unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		f.Close()
+		unix.Unlinkat(l.fd, name, 0)
+		return nil, p9.QID{}, 0, err
+	}
+
+	l2 := &Local{a: l.a, fd: pathfd, file: f, writable: mode.Mode() != p9.ReadOnly}
+	qid, err := l.created(l2, name, false, uid, gid)
+	if err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+	return l2, qid, 0, nil
+}
+
+// created applies the client's ownership to the newly created name, which l2
+// refers to, and returns its QID.
+//
+// If that fails, l2 is closed and name is removed again.
+func (l *Local) created(l2 *Local, name string, isDir bool, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	qid, _, err := l2.info()
+	if err == nil && (uid.Ok() || gid.Ok()) {
+		// Changing the owner changes the QID version.
+		if err = l.setOwner(l2.fd, qid.Type&p9.TypeSymlink != 0, uid, gid); err == nil {
+			qid, _, err = l2.info()
+		}
+	}
+	if err != nil {
+		l2.Close()
+		var flags int
+		if isDir {
+			flags = unix.AT_REMOVEDIR
+		}
+		unix.Unlinkat(l.fd, name, flags)
+		return p9.QID{}, err
+	}
+	return qid, nil
+}
+
+// createdPath is like created for files created without opening them.
+func (l *Local) createdPath(name string, isDir bool, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	fd, err := openAt(l.fd, name, unix.O_PATH, 0)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	l2 := &Local{a: l.a, fd: fd}
+	qid, err := l.created(l2, name, isDir, uid, gid)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	return qid, l2.Close()
+}
+
+// Mkdir implements p9.File.Mkdir.
+func (l *Local) Mkdir(name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	if err := checkName(name); err != nil {
+		return p9.QID{}, err
+	}
+	if err := unix.Mkdirat(l.fd, name, uint32(permissions.Permissions())); err != nil {
+		return p9.QID{}, err
+	}
+	return l.createdPath(name, true, uid, gid)
+}
+
+// Symlink implements p9.File.Symlink.
+func (l *Local) Symlink(oldname string, newname string, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	if err := checkName(newname); err != nil {
+		return p9.QID{}, err
+	}
+	if err := unix.Symlinkat(oldname, l.fd, newname); err != nil {
+		return p9.QID{}, err
+	}
+	return l.createdPath(newname, false, uid, gid)
+}
+
+// Mknod implements p9.File.Mknod.
+//
+// With OwnershipMapped, special files are created as regular files, as
+// creating device nodes requires CAP_MKNOD and user extended attributes
+// cannot be set on special files.
+func (l *Local) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	if err := checkName(name); err != nil {
+		return p9.QID{}, err
+	}
+	switch mode.FileType() {
+	case p9.ModeRegular, p9.ModeNamedPipe, p9.ModeSocket, p9.ModeCharacterDevice, p9.ModeBlockDevice:
+	case 0:
+		mode |= p9.ModeRegular
+	default:
+		return p9.QID{}, linux.EINVAL
+	}
+	rdev := unix.Mkdev(major, minor)
+
+	if l.a.ownership == OwnershipMapped && !mode.IsRegular() {
+		return l.mknodMapped(name, mode, rdev, uid, gid)
+	}
+	if err := unix.Mknodat(l.fd, name, uint32(mode), int(rdev)); err != nil {
+		return p9.QID{}, err
+	}
+	return l.createdPath(name, false, uid, gid)
+}
+
+// mknodMapped creates a regular file in place of a special file, and records
+// its mode and device number for OwnershipMapped.
+func (l *Local) mknodMapped(name string, mode p9.FileMode, rdev uint64, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	if err := unix.Mknodat(l.fd, name, uint32(p9.ModeRegular|mode.Permissions()), 0); err != nil {
+		return p9.QID{}, err
+	}
+	fd, err := openAt(l.fd, name, unix.O_PATH, 0)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	l2 := &Local{a: l.a, fd: fd}
+	err = unix.Setxattr(procPath(fd), mappedMode, le32(uint32(mode)), 0)
+	if err == nil {
+		err = unix.Setxattr(procPath(fd), mappedRDev, binary.LittleEndian.AppendUint64(nil, rdev), 0)
+	}
+	if err != nil {
+		l2.Close()
+		unix.Unlinkat(l.fd, name, 0)
+		return p9.QID{}, err
+	}
+	qid, err := l.created(l2, name, false, uid, gid)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	return qid, l2.Close()
+}
+
+// Link implements p9.File.Link.
+func (l *Local) Link(target p9.File, newname string) error {
+	t, ok := target.(*Local)
+	if !ok {
+		return linux.EXDEV
+	}
+	if err := checkName(newname); err != nil {
+		return err
+	}
+	// linkat with AT_EMPTY_PATH needs CAP_DAC_READ_SEARCH, following the
+	// /proc link does not.
+	return unix.Linkat(unix.AT_FDCWD, procPath(t.fd), l.fd, newname, unix.AT_SYMLINK_FOLLOW)
+}
+
+// RenameAt implements p9.File.RenameAt.
+func (l *Local) RenameAt(oldName string, newDir p9.File, newName string) error {
+	d, ok := newDir.(*Local)
+	if !ok {
+		return linux.EXDEV
+	}
+	if err := checkName(oldName); err != nil {
+		return err
+	}
+	if err := checkName(newName); err != nil {
+		return err
+	}
+	return unix.Renameat(l.fd, oldName, d.fd, newName)
+}
+
+// Readlink implements p9.File.Readlink.
+func (l *Local) Readlink() (string, error) {
+	for size := 256; ; size *= 2 {
+		buf := make([]byte, size)
+		n, err := unix.Readlinkat(l.fd, "", buf)
+		if err != nil {
+			return "", err
+		}
+		if n < size {
+			return string(buf[:n]), nil
+		}
+	}
+}
+
+// SetAttr implements p9.File.SetAttr.
+//
+// The attributes are applied in the order chown(2) and chmod(2) would be
+// called by "cp -p": the owner first, as changing it may clear the set-user-ID
+// and set-group-ID bits, then permissions, size and times. The first error
+// stops SetAttr, and attributes set until then are not rolled back.
+func (l *Local) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
+	var stat unix.Stat_t
+	if valid.UID || valid.GID || valid.Permissions {
+		var err error
+		if stat, err = l.stat(); err != nil {
+			return err
+		}
+	}
+	isSymlink := p9.FileMode(stat.Mode).IsSymlink()
+
+	if valid.UID || valid.GID {
+		uid, gid := p9.NoUID, p9.NoGID
+		if valid.UID {
+			uid = attr.UID
+		}
+		if valid.GID {
+			gid = attr.GID
+		}
+		if err := l.chown(isSymlink, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if valid.Permissions {
+		if isSymlink {
+			// Linux does not support symlink permissions.
+			return linux.EOPNOTSUPP
+		}
+		if err := l.chmod(attr.Permissions); err != nil {
+			return err
+		}
+	}
+
+	if valid.Size {
+		var err error
+		if l.file != nil && l.writable {
+			err = unix.Ftruncate(int(l.file.Fd()), int64(attr.Size))
+		} else {
+			err = unix.Truncate(procPath(l.fd), int64(attr.Size))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The change time is always updated by the above or by setting the
+	// access or modification time, and cannot be set explicitly.
+	if valid.ATime || valid.MTime {
+		ts := []unix.Timespec{
+			{Nsec: unix.UTIME_OMIT},
+			{Nsec: unix.UTIME_OMIT},
+		}
+		var err error
+		if valid.ATime {
+			if ts[0], err = timespec(valid.ATimeNotSystemTime, attr.ATimeSeconds, attr.ATimeNanoSeconds); err != nil {
+				return err
+			}
+		}
+		if valid.MTime {
+			if ts[1], err = timespec(valid.MTimeNotSystemTime, attr.MTimeSeconds, attr.MTimeNanoSeconds); err != nil {
+				return err
+			}
+		}
+		// utimensat does not accept O_PATH file descriptors, but the
+		// /proc link refers to the file itself, even for symlinks.
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, procPath(l.fd), ts, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timespec returns the time given by a client, or the current time if set is
+// false.
+func timespec(set bool, sec, nsec uint64) (unix.Timespec, error) {
+	if !set {
+		return unix.Timespec{Nsec: unix.UTIME_NOW}, nil
+	}
+	// TimeToTimespec fails with ERANGE if the time does not fit the
+	// platform's time_t.
+	return unix.TimeToTimespec(time.Unix(int64(sec), int64(nsec)))
+}
+
+// UnlinkAt implements p9.File.UnlinkAt.
+func (l *Local) UnlinkAt(name string, flags uint32) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	return unix.Unlinkat(l.fd, name, int(flags))
+}
+
+// SetXattr implements p9.File.SetXattr.
+func (l *Local) SetXattr(attr string, data []byte, flags p9.XattrFlags) error {
+	if err := l.checkXattr(attr); err != nil {
+		return err
+	}
+	return unix.Setxattr(procPath(l.fd), attr, data, int(flags))
+}
+
+// ListXattrs implements p9.File.ListXattrs.
+func (l *Local) ListXattrs() ([]string, error) {
+	attrs, err := xattr.List(procPath(l.fd))
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, attr := range attrs {
+		if !l.isMappedXattr(attr) {
+			attrs[n] = attr
+			n++
+		}
+	}
+	return attrs[:n], nil
+}
+
+// GetXattr implements p9.File.GetXattr.
+func (l *Local) GetXattr(attr string) ([]byte, error) {
+	if l.isMappedXattr(attr) {
+		return nil, linux.ENODATA
+	}
+	return xattr.Get(procPath(l.fd), attr)
+}
+
+// RemoveXattr implements p9.File.RemoveXattr.
+func (l *Local) RemoveXattr(attr string) error {
+	if err := l.checkXattr(attr); err != nil {
+		return err
+	}
+	return unix.Removexattr(procPath(l.fd), attr)
+}
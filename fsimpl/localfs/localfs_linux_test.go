@@ -0,0 +1,539 @@
+package localfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// setupEscape creates an export with a symlink "link" pointing at a directory
+// outside of it, which contains the file "secret".
+func setupEscape(t *testing.T) (root p9.File, export, outside string) {
+	t.Helper()
+	export, outside = t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(export, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/", filepath.Join(export, "rootlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(export, "secretlink")); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := Attacher(export).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	return root, export, outside
+}
+
+func TestSymlinkEscape(t *testing.T) {
+	root, _, outside := setupEscape(t)
+
+	for _, names := range [][]string{
+		{"link", "secret"},
+		{"rootlink", "etc"},
+		{".."},
+		{"."},
+		{"link/secret"},
+		{""},
+	} {
+		if _, f, err := root.Walk(names); err == nil {
+			f.Close()
+			t.Errorf("Walk(%q) succeeded, want error", names)
+		}
+	}
+
+	// Symlinks themselves can be walked to, but not opened.
+	for _, name := range []string{"link", "secretlink"} {
+		_, f, err := root.Walk([]string{name})
+		if err != nil {
+			t.Fatalf("Walk(%s) = %v", name, err)
+		}
+		if _, _, err := f.Open(p9.ReadOnly); err == nil {
+			t.Errorf("Open(%s) succeeded, want error", name)
+		}
+		if _, _, _, err := f.Create("new", p9.ReadWrite, 0666, p9.NoUID, p9.NoGID); err == nil {
+			t.Errorf("Create(%s/new) succeeded, want error", name)
+		}
+		if _, err := f.Mkdir("newdir", 0777, p9.NoUID, p9.NoGID); err == nil {
+			t.Errorf("Mkdir(%s/newdir) succeeded, want error", name)
+		}
+		f.Close()
+	}
+
+	// Names must be single components.
+	if _, _, _, err := root.Create("link/new", p9.ReadWrite, 0666, p9.NoUID, p9.NoGID); linux.ExtractErrno(err) != linux.EINVAL {
+		t.Errorf("Create(link/new) = %v, want EINVAL", err)
+	}
+	if err := root.UnlinkAt("link/secret", 0); linux.ExtractErrno(err) != linux.EINVAL {
+		t.Errorf("UnlinkAt(link/secret) = %v, want EINVAL", err)
+	}
+	if err := root.RenameAt("link/secret", root, "stolen"); linux.ExtractErrno(err) != linux.EINVAL {
+		t.Errorf("RenameAt(link/secret) = %v, want EINVAL", err)
+	}
+	if err := root.RenameAt("secretlink", root, ".."); linux.ExtractErrno(err) != linux.EINVAL {
+		t.Errorf("RenameAt(secretlink, ..) = %v, want EINVAL", err)
+	}
+
+	// Creating a file over a dangling or outside symlink fails rather
+	// than writing through it.
+	if _, _, _, err := root.Create("secretlink", p9.ReadWrite, 0666, p9.NoUID, p9.NoGID); linux.ExtractErrno(err) != linux.EEXIST {
+		t.Errorf("Create(secretlink) = %v, want EEXIST", err)
+	}
+
+	entries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "secret" {
+		t.Errorf("outside dir was modified: %v", entries)
+	}
+}
+
+func TestRenamedOnHost(t *testing.T) {
+	export, outside := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(export, "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := Attacher(export).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	_, dir, err := root.Walk([]string{"dir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+
+	// Replace dir with a symlink to the outside after the client walked
+	// to it.
+	if err := os.Rename(filepath.Join(export, "dir"), filepath.Join(export, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(export, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, _, _, err := dir.Create("file", p9.ReadWrite, 0666, p9.NoUID, p9.NoGID)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	f.Close()
+
+	if _, err := os.Stat(filepath.Join(export, "moved", "file")); err != nil {
+		t.Errorf("file was not created in the walked directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "file")); !os.IsNotExist(err) {
+		t.Errorf("file was created outside of the export: %v", err)
+	}
+}
+
+func TestSymlinkSwapRace(t *testing.T) {
+	export, outside := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(export, "dir")
+	tmp := filepath.Join(export, "tmp")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("inside"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := Attacher(export).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	// Keep swapping dir between a real directory and a symlink to the
+	// outside.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			os.Rename(dir, tmp)
+			os.Symlink(outside, dir)
+			os.Remove(dir)
+			os.Rename(tmp, dir)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		_, f, err := root.Walk([]string{"dir", "secret"})
+		if err == nil {
+			if _, _, err := f.Open(p9.ReadOnly); err == nil {
+				buf := make([]byte, 16)
+				n, _ := f.ReadAt(buf, 0)
+				if got := string(buf[:n]); got != "inside" {
+					t.Errorf("read %q through walked file, want inside", got)
+				}
+			}
+			f.Close()
+		}
+
+		_, d, err := root.Walk([]string{"dir"})
+		if err == nil {
+			if f, _, _, err := d.Create("new", p9.ReadWrite, 0666, p9.NoUID, p9.NoGID); err == nil {
+				f.Close()
+				d.UnlinkAt("new", 0)
+			}
+			d.Close()
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	entries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "secret" {
+		t.Errorf("outside dir was modified: %v", entries)
+	}
+}
+
+func TestSetAttr(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := Attacher(dir).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	walk := func(name string) p9.File {
+		t.Helper()
+		_, f, err := root.Walk([]string{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	lstat := func(name string) *syscall.Stat_t {
+		t.Helper()
+		fi, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Sys().(*syscall.Stat_t)
+	}
+
+	f := walk("file")
+	if err := f.SetAttr(p9.SetAttrMask{Permissions: true}, p9.SetAttr{Permissions: 0600 | p9.Setgid}); err != nil {
+		t.Fatalf("SetAttr(Permissions) = %v", err)
+	}
+	if got := lstat("file").Mode & 07777; got != 02600 {
+		t.Errorf("mode = %o, want 2600", got)
+	}
+
+	if err := f.SetAttr(p9.SetAttrMask{Size: true}, p9.SetAttr{Size: 3}); err != nil {
+		t.Fatalf("SetAttr(Size) = %v", err)
+	}
+	if got := lstat("file").Size; got != 3 {
+		t.Errorf("size = %d, want 3", got)
+	}
+
+	if err := f.SetAttr(p9.SetAttrMask{ATime: true, MTime: true, ATimeNotSystemTime: true, MTimeNotSystemTime: true}, p9.SetAttr{
+		ATimeSeconds:     1000,
+		ATimeNanoSeconds: 1,
+		MTimeSeconds:     2000,
+		MTimeNanoSeconds: 2,
+	}); err != nil {
+		t.Fatalf("SetAttr(times) = %v", err)
+	}
+	if st := lstat("file"); st.Atim != (syscall.Timespec{Sec: 1000, Nsec: 1}) || st.Mtim != (syscall.Timespec{Sec: 2000, Nsec: 2}) {
+		t.Errorf("atime = %v, mtime = %v, want 1000.000000001, 2000.000000002", st.Atim, st.Mtim)
+	}
+
+	// Only the modification time is set to the current time.
+	if err := f.SetAttr(p9.SetAttrMask{MTime: true}, p9.SetAttr{MTimeSeconds: 5}); err != nil {
+		t.Fatalf("SetAttr(MTime) = %v", err)
+	}
+	if st := lstat("file"); st.Atim.Sec != 1000 || st.Mtim.Sec < 1000000000 {
+		t.Errorf("atime = %v, mtime = %v, want 1000 and now", st.Atim, st.Mtim)
+	}
+
+	// Symlink times can be set, but not their permissions.
+	s := walk("symlink")
+	if err := s.SetAttr(p9.SetAttrMask{MTime: true, MTimeNotSystemTime: true}, p9.SetAttr{MTimeSeconds: 3000}); err != nil {
+		t.Fatalf("SetAttr(symlink times) = %v", err)
+	}
+	if st := lstat("symlink"); st.Mtim.Sec != 3000 {
+		t.Errorf("symlink mtime = %v, want 3000", st.Mtim)
+	}
+	if st := lstat("file"); st.Mtim.Sec == 3000 {
+		t.Errorf("SetAttr on symlink changed its target's mtime")
+	}
+	if err := s.SetAttr(p9.SetAttrMask{Permissions: true}, p9.SetAttr{Permissions: 0600}); linux.ExtractErrno(err) != linux.EOPNOTSUPP {
+		t.Errorf("SetAttr(symlink permissions) = %v, want EOPNOTSUPP", err)
+	}
+
+	// Open files are truncated through their file descriptor, even if
+	// they are no longer reachable by path.
+	o := walk("file")
+	if _, _, err := o.Open(p9.ReadWrite); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "file"), filepath.Join(dir, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.SetAttr(p9.SetAttrMask{Size: true}, p9.SetAttr{Size: 1}); err != nil {
+		t.Fatalf("SetAttr(Size) on open file = %v", err)
+	}
+	if got := lstat("moved").Size; got != 1 {
+		t.Errorf("size = %d, want 1", got)
+	}
+
+	// Files opened read-only can still be truncated.
+	r := walk("moved")
+	if _, _, err := r.Open(p9.ReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetAttr(p9.SetAttrMask{Size: true}, p9.SetAttr{Size: 0}); err != nil {
+		t.Fatalf("SetAttr(Size) on read-only file = %v", err)
+	}
+	if got := lstat("moved").Size; got != 0 {
+		t.Errorf("size = %d, want 0", got)
+	}
+
+	if os.Geteuid() == 0 {
+		if err := r.SetAttr(p9.SetAttrMask{UID: true, GID: true}, p9.SetAttr{UID: testUID, GID: testGID}); err != nil {
+			t.Fatalf("SetAttr(UID, GID) = %v", err)
+		}
+		if st := lstat("moved"); st.Uid != uint32(testUID) || st.Gid != uint32(testGID) {
+			t.Errorf("owner = %d:%d, want %d:%d", st.Uid, st.Gid, testUID, testGID)
+		}
+	}
+}
+
+func TestSetAttrMapped(t *testing.T) {
+	dir := t.TempDir()
+	if err := unix.Setxattr(dir, "user.p9.test", []byte("y"), 0); err == unix.ENOTSUP {
+		t.Skip("user extended attributes are not supported")
+	}
+	root, err := Attacher(dir, WithOwnership(OwnershipMapped)).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	if _, err := root.Mknod("fifo", p9.ModeNamedPipe|0600, 0, 0, p9.NoUID, p9.NoGID); err != nil {
+		t.Fatal(err)
+	}
+	_, f, err := root.Walk([]string{"fifo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := f.SetAttr(p9.SetAttrMask{UID: true, GID: true, Permissions: true}, p9.SetAttr{UID: testUID, GID: testGID, Permissions: 0640}); err != nil {
+		t.Fatalf("SetAttr = %v", err)
+	}
+	_, _, attr, err := f.GetAttr(p9.AttrMaskAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr.UID != testUID || attr.GID != testGID || attr.Mode != p9.ModeNamedPipe|0640 {
+		t.Errorf("GetAttr = %d:%d %o, want %d:%d %o", attr.UID, attr.GID, attr.Mode, testUID, testGID, p9.ModeNamedPipe|0640)
+	}
+}
+
+func TestReaddir(t *testing.T) {
+	dir := t.TempDir()
+	const n = 1000
+	want := make(map[string]p9.QIDType)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("file-with-a-long-name-%04d", i)
+		want[name] = p9.TypeRegular
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	want["dir"] = p9.TypeDir
+	if err := os.Symlink("dir", filepath.Join(dir, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+	want["symlink"] = p9.TypeSymlink
+
+	root, err := Attacher(dir).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	readdir := func(count uint32) []p9.Dirent {
+		_, d, err := root.Walk(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		if _, _, err := d.Open(p9.ReadOnly); err != nil {
+			t.Fatal(err)
+		}
+
+		var all []p9.Dirent
+		var offset uint64
+		for {
+			ents, err := d.Readdir(offset, count)
+			if err != nil {
+				t.Fatalf("Readdir(%d, %d) = %v", offset, count, err)
+			}
+			if len(ents) == 0 {
+				return all
+			}
+			// Entries fit in count bytes, but there is at least
+			// one.
+			size := 0
+			for _, ent := range ents {
+				size += direntSize([]byte(ent.Name))
+			}
+			if len(ents) > 1 && size > int(count) {
+				t.Fatalf("Readdir(%d, %d) returned %d entries of %d bytes", offset, count, len(ents), size)
+			}
+			all = append(all, ents...)
+			offset = ents[len(ents)-1].Offset
+		}
+	}
+
+	for _, count := range []uint32{1, 200, 8192} {
+		got := make(map[string]p9.QIDType)
+		for _, ent := range readdir(count) {
+			if _, ok := got[ent.Name]; ok {
+				t.Errorf("count %d: %s returned twice", count, ent.Name)
+			}
+			got[ent.Name] = ent.Type
+			if ent.QID.Type != ent.Type {
+				t.Errorf("count %d: %s has QID type %v, type %v", count, ent.Name, ent.QID.Type, ent.Type)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("count %d: Readdir returned %d entries, want %d", count, len(got), len(want))
+		}
+	}
+
+	// Offsets returned on one open file can be continued on another.
+	all := readdir(8192)
+	_, d, err := root.Walk(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, _, err := d.Open(p9.ReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{500, 10, len(all) - 2} {
+		ents, err := d.Readdir(all[i].Offset, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ents) != 1 || ents[0] != all[i+1] {
+			t.Errorf("Readdir(%d, 1) = %v, want %v", all[i].Offset, ents, all[i+1])
+		}
+	}
+	ents, err := d.Readdir(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 || ents[0] != all[0] {
+		t.Errorf("Readdir(0, 1) = %v, want %v", ents, all[0])
+	}
+
+	// QIDs match those returned by Walk, except for the version, which
+	// Readdir does not know.
+	qids, f, err := root.Walk([]string{"dir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	for _, ent := range all {
+		if ent.Name == "dir" && (ent.QID.Type != qids[0].Type || ent.QID.Path != qids[0].Path) {
+			t.Errorf("Readdir QID = %v, Walk QID = %v", ent.QID, qids[0])
+		}
+	}
+}
+
+func TestWalkGetAttr(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a", "b"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", "b", "file"), []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	root, err := Attacher(dir).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	for _, names := range [][]string{nil, {"a"}, {"a", "b", "file"}} {
+		wantQIDs, f, err := root.Walk(names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantQID, _, wantAttr, err := f.GetAttr(p9.AttrMaskAll)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		qids, f, valid, attr, err := root.WalkGetAttr(names)
+		if err != nil {
+			t.Fatalf("WalkGetAttr(%v) = %v", names, err)
+		}
+		gotQID, _, _, err := f.GetAttr(p9.AttrMaskAll)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(qids, wantQIDs) || gotQID != wantQID {
+			t.Errorf("WalkGetAttr(%v) QIDs = %v (file %v), want %v (file %v)", names, qids, gotQID, wantQIDs, wantQID)
+		}
+		if !valid.Mode || !valid.Size || !valid.MTime || valid.BTime {
+			t.Errorf("WalkGetAttr(%v) valid = %v", names, valid)
+		}
+		if attr != wantAttr {
+			t.Errorf("WalkGetAttr(%v) attr = %v, want %v", names, attr, wantAttr)
+		}
+	}
+
+	if _, _, _, _, err := root.WalkGetAttr([]string{"a", "nonexistent"}); linux.ExtractErrno(err) != linux.ENOENT {
+		t.Errorf("WalkGetAttr(a/nonexistent) = %v, want ENOENT", err)
+	}
+}
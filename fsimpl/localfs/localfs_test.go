@@ -17,9 +17,12 @@ package localfs
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/hugelgupf/p9/fsimpl/test"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
 )
 
 func TestLocalFS(t *testing.T) {
@@ -33,3 +36,141 @@ func TestLocalFS(t *testing.T) {
 	test.TestReadOnlyFS(t, Attacher(tempDir))
 	test.TestReadWriteFS(t, Attacher(tempDir))
 }
+
+func TestStatFS(t *testing.T) {
+	dir := t.TempDir()
+	root, err := Attacher(dir).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	stat, err := root.StatFS()
+	if linux.ExtractErrno(err) == linux.ENOSYS {
+		t.Skip("StatFS is not supported on this platform")
+	} else if err != nil {
+		t.Fatalf("StatFS = %v", err)
+	}
+	if stat.BlockSize == 0 || stat.Blocks == 0 || stat.NameLength == 0 {
+		t.Errorf("StatFS = %+v, want block size, blocks and name length", stat)
+	}
+	if stat.BlocksFree > stat.Blocks || stat.BlocksAvailable > stat.BlocksFree {
+		t.Errorf("StatFS = %+v, want available <= free <= blocks", stat)
+	}
+
+	const quota = 1 << 20
+	root, err = Attacher(dir, WithQuota(quota)).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	qstat, err := root.StatFS()
+	if err != nil {
+		t.Fatalf("StatFS = %v", err)
+	}
+	limit := uint64(quota / qstat.BlockSize)
+	if qstat.Blocks != min(stat.Blocks, limit) || qstat.BlocksFree > limit || qstat.BlocksAvailable > limit {
+		t.Errorf("StatFS with quota = %+v, want at most %d blocks", qstat, limit)
+	}
+	if qstat.Files != stat.Files || qstat.Type != stat.Type {
+		t.Errorf("StatFS with quota = %+v, want files and type of %+v", qstat, stat)
+	}
+}
+
+func TestQIDVersion(t *testing.T) {
+	root, err := Attacher(t.TempDir()).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	f, created, _, err := root.Create("file", p9.ReadWrite, 0644, p9.NoUID, p9.NoGID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	version := func() uint32 {
+		t.Helper()
+		qid, _, _, err := f.GetAttr(p9.AttrMask{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if qid.Path != created.Path {
+			t.Fatalf("GetAttr QID = %v, want path %d", qid, created.Path)
+		}
+		return qid.Version
+	}
+
+	v := version()
+	if v != created.Version {
+		t.Errorf("GetAttr version = %d, want creation version %d", v, created.Version)
+	}
+	if got := version(); got != v {
+		t.Errorf("unmodified file: version = %d, want %d", got, v)
+	}
+	for _, tt := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"write", func() error {
+			_, err := f.WriteAt([]byte("hello"), 0)
+			return err
+		}},
+		{"chmod", func() error {
+			return f.SetAttr(p9.SetAttrMask{Permissions: true}, p9.SetAttr{Permissions: 0600})
+		}},
+		{"truncate", func() error {
+			return f.SetAttr(p9.SetAttrMask{Size: true}, p9.SetAttr{Size: 1})
+		}},
+	} {
+		if err := tt.fn(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := version(); got == v {
+			t.Errorf("%s: version unchanged (%d)", tt.name, got)
+		} else {
+			v = got
+		}
+	}
+}
+
+func TestFD(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := Attacher(dir).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	for _, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"file", true},
+		// Directories would give access outside of the export.
+		{"", false},
+	} {
+		var names []string
+		if tt.name != "" {
+			names = []string{tt.name}
+		}
+		_, f, err := root.Walk(names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, ok := f.(p9.HostFile).FD(); ok {
+			t.Errorf("%q: FD() before Open = true", tt.name)
+		}
+		if _, _, err := f.Open(p9.ReadOnly); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := f.(p9.HostFile).FD(); ok != tt.want {
+			t.Errorf("%q: FD() = %t, want %t", tt.name, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package localfs
+
+import (
+	"math"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// flock converts a 9P lock request to a struct flock for open file
+// description locks.
+func flock(locktype p9.LockType, start, length uint64) (*unix.Flock_t, error) {
+	var typ int16
+	switch locktype {
+	case p9.ReadLock:
+		typ = unix.F_RDLCK
+	case p9.WriteLock:
+		typ = unix.F_WRLCK
+	case p9.Unlock:
+		typ = unix.F_UNLCK
+	default:
+		return nil, linux.EINVAL
+	}
+	if start > math.MaxInt64 || length > math.MaxInt64 {
+		return nil, linux.EINVAL
+	}
+	// The PID must be 0 for open file description locks.
+	return &unix.Flock_t{
+		Type:   typ,
+		Whence: unix.SEEK_SET,
+		Start:  int64(start),
+		Len:    int64(length),
+	}, nil
+}
+
+// Lock implements p9.File.Lock.
+//
+// Locks are open file description locks, so they are owned by the open fid
+// and released when it is clunked. Locks of different fids conflict, even if
+// they belong to the same client process.
+//
+// The server never waits for a lock. Conflicting requests return
+// LockStatusBlocked, and the Linux v9fs client retries those that are
+// blocking.
+func (l *Local) Lock(pid int, locktype p9.LockType, flags p9.LockFlags, start, length uint64, client string) (p9.LockStatus, error) {
+	if l.file == nil {
+		return p9.LockStatusError, linux.EBADF
+	}
+	lk, err := flock(locktype, start, length)
+	if err != nil {
+		return p9.LockStatusError, err
+	}
+	for {
+		err = unix.FcntlFlock(l.file.Fd(), unix.F_OFD_SETLK, lk)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	switch err {
+	case nil:
+		return p9.LockStatusOK, nil
+	case unix.EAGAIN, unix.EACCES:
+		return p9.LockStatusBlocked, nil
+	default:
+		return p9.LockStatusError, err
+	}
+}
+
+// GetLock implements p9.LockGetter.GetLock.
+//
+// Conflicting locks held by other fids are reported with a PID of -1.
+func (l *Local) GetLock(pid int, locktype p9.LockType, start, length uint64, client string) (p9.LockInfo, error) {
+	if l.file == nil {
+		return p9.LockInfo{}, linux.EBADF
+	}
+	lk, err := flock(locktype, start, length)
+	if err != nil {
+		return p9.LockInfo{}, err
+	}
+	if err := unix.FcntlFlock(l.file.Fd(), unix.F_OFD_GETLK, lk); err != nil {
+		return p9.LockInfo{}, err
+	}
+	if lk.Type == unix.F_UNLCK {
+		return p9.LockInfo{
+			Type:   p9.Unlock,
+			Start:  start,
+			Length: length,
+			PID:    int32(pid),
+			Client: client,
+		}, nil
+	}
+
+	info := p9.LockInfo{
+		Type:   p9.WriteLock,
+		Start:  uint64(lk.Start),
+		Length: uint64(lk.Len),
+		PID:    lk.Pid,
+	}
+	if lk.Type == unix.F_RDLCK {
+		info.Type = p9.ReadLock
+	}
+	return info, nil
+}
@@ -0,0 +1,90 @@
+package localfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	root, err := Attacher(dir).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	open := func(mode p9.OpenFlags) p9.File {
+		t.Helper()
+		_, f, err := root.Walk([]string{"file"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := f.Open(mode); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	lock := func(f p9.File, locktype p9.LockType, flags p9.LockFlags, start, length uint64, want p9.LockStatus) {
+		t.Helper()
+		if got, err := f.Lock(1, locktype, flags, start, length, "client"); err != nil || got != want {
+			t.Errorf("Lock(%v, %d, %d) = %v, %v, want %v", locktype, start, length, got, err, want)
+		}
+	}
+
+	a, b := open(p9.ReadWrite), open(p9.ReadWrite)
+	defer b.Close()
+
+	lock(a, p9.WriteLock, 0, 0, 10, p9.LockStatusOK)
+	lock(b, p9.ReadLock, 0, 5, 5, p9.LockStatusBlocked)
+	lock(b, p9.ReadLock, p9.LockFlagsBlock, 5, 5, p9.LockStatusBlocked)
+	lock(b, p9.WriteLock, 0, 10, 0, p9.LockStatusOK)
+
+	got, err := p9.GetLock(b, 1, p9.WriteLock, 0, 0, "client")
+	if err != nil {
+		t.Fatalf("GetLock = %v", err)
+	}
+	if want := (p9.LockInfo{Type: p9.WriteLock, Start: 0, Length: 10, PID: -1}); got != want {
+		t.Errorf("GetLock = %v, want %v", got, want)
+	}
+
+	// Unlocking part of the range makes it available.
+	lock(a, p9.Unlock, 0, 0, 5, p9.LockStatusOK)
+	lock(b, p9.ReadLock, 0, 0, 5, p9.LockStatusOK)
+	got, err = p9.GetLock(b, 1, p9.ReadLock, 0, 5, "client")
+	if err != nil {
+		t.Fatalf("GetLock = %v", err)
+	}
+	if want := (p9.LockInfo{Type: p9.Unlock, Start: 0, Length: 5, PID: 1, Client: "client"}); got != want {
+		t.Errorf("GetLock = %v, want %v", got, want)
+	}
+
+	// Read locks are shared.
+	c := open(p9.ReadOnly)
+	lock(c, p9.ReadLock, 0, 0, 5, p9.LockStatusOK)
+	lock(c, p9.ReadLock, 0, 5, 5, p9.LockStatusBlocked)
+	if got, err := c.Lock(1, p9.WriteLock, 0, 20, 1, "client"); linux.ExtractErrno(err) != linux.EBADF {
+		t.Errorf("Lock(WriteLock) on read-only file = %v, %v, want EBADF", got, err)
+	}
+
+	// Clunking releases the locks.
+	a.Close()
+	c.Close()
+	lock(b, p9.WriteLock, 0, 0, 0, p9.LockStatusOK)
+
+	// Locks need an open file.
+	_, d, err := root.Walk([]string{"file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.Lock(1, p9.ReadLock, 0, 0, 0, "client"); linux.ExtractErrno(err) != linux.EBADF {
+		t.Errorf("Lock on unopened file = %v, want EBADF", err)
+	}
+}
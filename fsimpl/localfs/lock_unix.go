@@ -0,0 +1,39 @@
+//go:build !windows && !linux
+
+package localfs
+
+import (
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// lock implements p9.File.Lock.
+//
+// Byte-range locks are approximated by whole-file flock(2) locks, as POSIX
+// record locks are owned by the server process and would never conflict.
+func (l *Local) lock(pid int, locktype p9.LockType, flags p9.LockFlags, start, length uint64, client string) (p9.LockStatus, error) {
+	if l.file == nil {
+		return p9.LockStatusError, linux.EBADF
+	}
+
+	var how int
+	switch locktype {
+	case p9.ReadLock:
+		how = unix.LOCK_SH
+	case p9.WriteLock:
+		how = unix.LOCK_EX
+	case p9.Unlock:
+		how = unix.LOCK_UN
+	default:
+		return p9.LockStatusError, linux.EINVAL
+	}
+
+	// Never block the server. Clients retry blocking requests.
+	if err := unix.Flock(int(l.file.Fd()), how|unix.LOCK_NB); err == unix.EWOULDBLOCK {
+		return p9.LockStatusBlocked, nil
+	} else if err != nil {
+		return p9.LockStatusError, err
+	}
+	return p9.LockStatusOK, nil
+}
@@ -0,0 +1,416 @@
+package localfs
+
+import (
+	"os"
+	"os/user"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// overflowGID is the group of users unknown to the host.
+const overflowGID = 65534
+
+// creds are file system credentials.
+type creds struct {
+	uid    int
+	gid    int
+	groups []int
+}
+
+// serverCreds are the file server's own credentials, which are restored after
+// running as a user.
+var serverCreds = sync.OnceValues(func() (*creds, error) {
+	groups, err := unix.Getgroups()
+	if err != nil {
+		return nil, err
+	}
+	return &creds{uid: os.Geteuid(), gid: os.Getegid(), groups: groups}, nil
+})
+
+// lookupCreds returns the credentials of the user with the given ID, or with
+// the given name if uid is NoUID.
+func lookupCreds(uname string, uid p9.UID) (*creds, error) {
+	var (
+		u   *user.User
+		err error
+	)
+	switch {
+	case uid.Ok():
+		u, err = user.LookupId(strconv.FormatUint(uint64(uid), 10))
+		if err != nil {
+			// Users unknown to the host are still identified by
+			// their ID.
+			return &creds{uid: int(uid), gid: overflowGID}, nil
+		}
+	case uname != "":
+		if u, err = user.Lookup(uname); err != nil {
+			return nil, linux.EPERM
+		}
+	default:
+		return nil, linux.EINVAL
+	}
+
+	c := &creds{}
+	if c.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, err
+	}
+	if c.gid, err = strconv.Atoi(u.Gid); err != nil {
+		return nil, err
+	}
+	// If the groups cannot be listed, the user only has their primary
+	// group.
+	ids, _ := u.GroupIds()
+	for _, id := range ids {
+		if gid, err := strconv.Atoi(id); err == nil {
+			c.groups = append(c.groups, gid)
+		}
+	}
+	return c, nil
+}
+
+// set sets the file system credentials of the calling thread.
+func (c *creds) set() error {
+	// Unlike syscall.Setgroups, unix.Setgroups only affects the calling
+	// thread.
+	if err := unix.Setgroups(c.groups); err != nil {
+		return err
+	}
+	// setfsgid and setfsuid don't report errors, so check that the new
+	// IDs took effect.
+	unix.SetfsgidRetGid(c.gid)
+	if gid, _ := unix.SetfsgidRetGid(-1); gid != c.gid {
+		return linux.EPERM
+	}
+	unix.SetfsuidRetUid(c.uid)
+	if uid, _ := unix.SetfsuidRetUid(-1); uid != c.uid {
+		return linux.EPERM
+	}
+	return nil
+}
+
+// run runs fn on a locked OS thread with the file system credentials c.
+//
+// If the server's credentials cannot be restored afterwards, the thread stays
+// locked to the calling goroutine, so that it exits with the goroutine
+// instead of running other goroutines with c.
+func (c *creds) run(fn func() error) error {
+	server, err := serverCreds()
+	if err != nil {
+		return err
+	}
+	runtime.LockOSThread()
+	err = c.set()
+	if err == nil {
+		err = fn()
+	}
+	if server.set() == nil {
+		runtime.UnlockOSThread()
+	}
+	return err
+}
+
+// users are the users of an attach: the attaching user, and the users given
+// to creation calls.
+type users struct {
+	attacher *creds
+
+	mu    sync.Mutex
+	byUID map[p9.UID]*creds
+}
+
+// creator returns the credentials to create files with for the given owner.
+// If uid or gid are not given, the attaching user's are used.
+//
+// Users other than root may only create files as themselves, in one of their
+// groups.
+func (u *users) creator(uid p9.UID, gid p9.GID) (*creds, error) {
+	c := u.attacher
+	if err := u.attacher.mayOwn(uid, gid); err != nil {
+		return nil, err
+	}
+	if uid.Ok() && uid != p9.UID(c.uid) {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if c = u.byUID[uid]; c == nil {
+			var err error
+			if c, err = lookupCreds("", uid); err != nil {
+				return nil, err
+			}
+			u.byUID[uid] = c
+		}
+	}
+	if gid.Ok() && gid != p9.GID(c.gid) {
+		c = &creds{uid: c.uid, gid: int(gid), groups: c.groups}
+	}
+	return c, nil
+}
+
+// mayOwn returns EPERM if c may not give files the owner uid and group gid,
+// which are ignored if not given.
+func (c *creds) mayOwn(uid p9.UID, gid p9.GID) error {
+	if c.uid == 0 {
+		return nil
+	}
+	if uid.Ok() && uid != p9.UID(c.uid) {
+		return linux.EPERM
+	}
+	if gid.Ok() && gid != p9.GID(c.gid) && !slices.Contains(c.groups, int(gid)) {
+		return linux.EPERM
+	}
+	return nil
+}
+
+// attachUser attaches in multi-user mode.
+func (a *attacher) attachUser(uname string, uid p9.UID) (p9.File, error) {
+	c, err := lookupCreds(uname, uid)
+	if err != nil {
+		return nil, err
+	}
+	u := &users{attacher: c, byUID: make(map[p9.UID]*creds)}
+
+	var root p9.File
+	if err := c.run(func() (err error) {
+		root, err = a.Attach()
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return &userFile{root.(*Local), u}, nil
+}
+
+// userFile is a Local whose operations run with the credentials of its
+// users.
+//
+// Operations on open files and fstat do not check permissions, so they run
+// with the server's credentials, except for WriteAt, which clears the setuid
+// and setgid bits depending on the credentials.
+type userFile struct {
+	*Local
+	u *users
+}
+
+// unwrapUser returns the Local of a userFile, which is passed to other Local
+// methods.
+func unwrapUser(f p9.File) p9.File {
+	if uf, ok := f.(*userFile); ok {
+		return uf.Local
+	}
+	return f
+}
+
+// Walk implements p9.File.Walk.
+func (f *userFile) Walk(names []string) ([]p9.QID, p9.File, error) {
+	var (
+		qids []p9.QID
+		nf   p9.File
+	)
+	if err := f.u.attacher.run(func() (err error) {
+		qids, nf, err = f.Local.Walk(names)
+		return err
+	}); err != nil {
+		return nil, nil, err
+	}
+	return qids, &userFile{nf.(*Local), f.u}, nil
+}
+
+// WalkGetAttr implements p9.File.WalkGetAttr.
+func (f *userFile) WalkGetAttr(names []string) ([]p9.QID, p9.File, p9.AttrMask, p9.Attr, error) {
+	var (
+		qids []p9.QID
+		nf   p9.File
+		mask p9.AttrMask
+		attr p9.Attr
+	)
+	if err := f.u.attacher.run(func() (err error) {
+		qids, nf, mask, attr, err = f.Local.WalkGetAttr(names)
+		return err
+	}); err != nil {
+		return nil, nil, p9.AttrMask{}, p9.Attr{}, err
+	}
+	return qids, &userFile{nf.(*Local), f.u}, mask, attr, nil
+}
+
+// StatFS implements p9.File.StatFS.
+func (f *userFile) StatFS() (stat p9.FSStat, err error) {
+	err = f.u.attacher.run(func() (err error) {
+		stat, err = f.Local.StatFS()
+		return err
+	})
+	return stat, err
+}
+
+// SetAttr implements p9.File.SetAttr.
+func (f *userFile) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
+	uid, gid := p9.NoUID, p9.NoGID
+	if valid.UID {
+		uid = attr.UID
+	}
+	if valid.GID {
+		gid = attr.GID
+	}
+	if err := f.u.attacher.mayOwn(uid, gid); err != nil {
+		return err
+	}
+	return f.u.attacher.run(func() error {
+		return f.Local.SetAttr(valid, attr)
+	})
+}
+
+// Open implements p9.File.Open.
+func (f *userFile) Open(mode p9.OpenFlags) (qid p9.QID, iounit uint32, err error) {
+	err = f.u.attacher.run(func() (err error) {
+		qid, iounit, err = f.Local.Open(mode)
+		return err
+	})
+	return qid, iounit, err
+}
+
+// WriteAt implements p9.File.WriteAt.
+func (f *userFile) WriteAt(p []byte, offset int64) (n int, err error) {
+	err = f.u.attacher.run(func() (err error) {
+		n, err = f.Local.WriteAt(p, offset)
+		return err
+	})
+	return n, err
+}
+
+// SetXattr implements p9.File.SetXattr.
+func (f *userFile) SetXattr(attr string, data []byte, flags p9.XattrFlags) error {
+	return f.u.attacher.run(func() error {
+		return f.Local.SetXattr(attr, data, flags)
+	})
+}
+
+// GetXattr implements p9.File.GetXattr.
+func (f *userFile) GetXattr(attr string) (data []byte, err error) {
+	err = f.u.attacher.run(func() (err error) {
+		data, err = f.Local.GetXattr(attr)
+		return err
+	})
+	return data, err
+}
+
+// ListXattrs implements p9.File.ListXattrs.
+func (f *userFile) ListXattrs() (attrs []string, err error) {
+	err = f.u.attacher.run(func() (err error) {
+		attrs, err = f.Local.ListXattrs()
+		return err
+	})
+	return attrs, err
+}
+
+// RemoveXattr implements p9.File.RemoveXattr.
+func (f *userFile) RemoveXattr(attr string) error {
+	return f.u.attacher.run(func() error {
+		return f.Local.RemoveXattr(attr)
+	})
+}
+
+// Create implements p9.File.Create.
+//
+// The file is created by the given user and group, rather than having its
+// owner changed.
+func (f *userFile) Create(name string, flags p9.OpenFlags, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.File, p9.QID, uint32, error) {
+	c, err := f.u.creator(uid, gid)
+	if err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+	var (
+		nf     p9.File
+		qid    p9.QID
+		iounit uint32
+	)
+	if err := c.run(func() (err error) {
+		nf, qid, iounit, err = f.Local.Create(name, flags, permissions, p9.NoUID, p9.NoGID)
+		return err
+	}); err != nil {
+		return nil, p9.QID{}, 0, err
+	}
+	return &userFile{nf.(*Local), f.u}, qid, iounit, nil
+}
+
+// Mkdir implements p9.File.Mkdir.
+func (f *userFile) Mkdir(name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (qid p9.QID, err error) {
+	c, err := f.u.creator(uid, gid)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	err = c.run(func() (err error) {
+		qid, err = f.Local.Mkdir(name, permissions, p9.NoUID, p9.NoGID)
+		return err
+	})
+	return qid, err
+}
+
+// Symlink implements p9.File.Symlink.
+func (f *userFile) Symlink(oldName string, newName string, uid p9.UID, gid p9.GID) (qid p9.QID, err error) {
+	c, err := f.u.creator(uid, gid)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	err = c.run(func() (err error) {
+		qid, err = f.Local.Symlink(oldName, newName, p9.NoUID, p9.NoGID)
+		return err
+	})
+	return qid, err
+}
+
+// Mknod implements p9.File.Mknod.
+func (f *userFile) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, uid p9.UID, gid p9.GID) (qid p9.QID, err error) {
+	c, err := f.u.creator(uid, gid)
+	if err != nil {
+		return p9.QID{}, err
+	}
+	err = c.run(func() (err error) {
+		qid, err = f.Local.Mknod(name, mode, major, minor, p9.NoUID, p9.NoGID)
+		return err
+	})
+	return qid, err
+}
+
+// Link implements p9.File.Link.
+func (f *userFile) Link(target p9.File, newName string) error {
+	return f.u.attacher.run(func() error {
+		return f.Local.Link(unwrapUser(target), newName)
+	})
+}
+
+// RenameAt implements p9.File.RenameAt.
+func (f *userFile) RenameAt(oldName string, newDir p9.File, newName string) error {
+	return f.u.attacher.run(func() error {
+		return f.Local.RenameAt(oldName, unwrapUser(newDir), newName)
+	})
+}
+
+// UnlinkAt implements p9.File.UnlinkAt.
+func (f *userFile) UnlinkAt(name string, flags uint32) error {
+	return f.u.attacher.run(func() error {
+		return f.Local.UnlinkAt(name, flags)
+	})
+}
+
+// Readdir implements p9.File.Readdir.
+//
+// Entries of unknown type are looked up.
+func (f *userFile) Readdir(offset uint64, count uint32) (dirents p9.Dirents, err error) {
+	err = f.u.attacher.run(func() (err error) {
+		dirents, err = f.Local.Readdir(offset, count)
+		return err
+	})
+	return dirents, err
+}
+
+// Readlink implements p9.File.Readlink.
+func (f *userFile) Readlink() (target string, err error) {
+	err = f.u.attacher.run(func() (err error) {
+		target, err = f.Local.Readlink()
+		return err
+	})
+	return target, err
+}
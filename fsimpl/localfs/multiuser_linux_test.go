@@ -0,0 +1,207 @@
+package localfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// multiUserDir returns a directory that other users may traverse, with files
+// owned by root and by testUID.
+func multiUserDir(t *testing.T) string {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root")
+	}
+	// t.TempDir is not accessible to other users.
+	dir, err := os.MkdirTemp("", "localfs-multiuser-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, f := range []struct {
+		name string
+		mode os.FileMode
+		uid  int
+	}{
+		{"secret", 0600, 0},
+		{"public", 0644, 0},
+		{"userdir", os.ModeDir | 0700, int(testUID)},
+		{"rootdir", os.ModeDir | 0755, 0},
+	} {
+		path := filepath.Join(dir, f.name)
+		if f.mode.IsDir() {
+			err = os.Mkdir(path, 0)
+		} else {
+			err = os.WriteFile(path, []byte("content"), 0)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, f.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(path, f.uid, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMultiUser(t *testing.T) {
+	dir := multiUserDir(t)
+	a := Attacher(dir, WithMultiUser()).(p9.UserAttacher)
+
+	root, err := a.AttachUser("", testUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	open := func(name string, mode p9.OpenFlags) error {
+		t.Helper()
+		_, f, err := root.Walk([]string{name})
+		if err != nil {
+			t.Fatalf("Walk(%s) = %v", name, err)
+		}
+		defer f.Close()
+		_, _, err = f.Open(mode)
+		return err
+	}
+	if err := open("secret", p9.ReadOnly); !errors.Is(err, unix.EACCES) {
+		t.Errorf("Open(secret, ReadOnly) = %v, want EACCES", err)
+	}
+	if err := open("public", p9.ReadOnly); err != nil {
+		t.Errorf("Open(public, ReadOnly) = %v", err)
+	}
+	if err := open("public", p9.WriteOnly); !errors.Is(err, unix.EACCES) {
+		t.Errorf("Open(public, WriteOnly) = %v, want EACCES", err)
+	}
+
+	_, rootdir, err := root.Walk([]string{"rootdir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rootdir.Close()
+	if _, err := rootdir.Mkdir("new", 0755, p9.NoUID, p9.NoGID); !errors.Is(err, unix.EACCES) {
+		t.Errorf("Mkdir in rootdir = %v, want EACCES", err)
+	}
+
+	_, public, err := root.Walk([]string{"public"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer public.Close()
+	if err := public.SetAttr(p9.SetAttrMask{Permissions: true}, p9.SetAttr{Permissions: 0777}); !errors.Is(err, unix.EPERM) {
+		t.Errorf("SetAttr(public) = %v, want EPERM", err)
+	}
+
+	// Files are created by the user, with the requested group or the
+	// user's.
+	_, userdir, err := root.Walk([]string{"userdir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer userdir.Close()
+	f, _, _, err := userdir.Create("file", p9.ReadWrite, 0644, testUID, overflowGID)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	f.Close()
+	if _, err := userdir.Mkdir("dir", 0755, p9.NoUID, p9.NoGID); err != nil {
+		t.Fatalf("Mkdir = %v", err)
+	}
+	for _, name := range []string{"file", "dir"} {
+		if uid, gid := statOwner(t, filepath.Join(dir, "userdir", name)); uid != uint32(testUID) || gid != overflowGID {
+			t.Errorf("%s: owner = %d:%d, want %d:%d", name, uid, gid, testUID, overflowGID)
+		}
+	}
+
+	// Other owners are refused.
+	for _, tt := range []struct {
+		uid p9.UID
+		gid p9.GID
+	}{
+		{0, p9.NoGID},
+		{testUID + 1, p9.NoGID},
+		{p9.NoUID, 0},
+		{p9.NoUID, testGID},
+	} {
+		if _, err := userdir.Mkdir("other", 0755, tt.uid, tt.gid); err != linux.EPERM {
+			t.Errorf("Mkdir as %d:%d = %v, want EPERM", tt.uid, tt.gid, err)
+		}
+		if _, _, _, err := userdir.Create("other", p9.ReadWrite, 0644, tt.uid, tt.gid); err != linux.EPERM {
+			t.Errorf("Create as %d:%d = %v, want EPERM", tt.uid, tt.gid, err)
+		}
+		if _, err := userdir.Symlink("file", "other", tt.uid, tt.gid); err != linux.EPERM {
+			t.Errorf("Symlink as %d:%d = %v, want EPERM", tt.uid, tt.gid, err)
+		}
+		if _, err := userdir.Mknod("other", p9.ModeRegular|0644, 0, 0, tt.uid, tt.gid); err != linux.EPERM {
+			t.Errorf("Mknod as %d:%d = %v, want EPERM", tt.uid, tt.gid, err)
+		}
+	}
+	_, file, err := userdir.Walk([]string{"file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := file.SetAttr(p9.SetAttrMask{UID: true}, p9.SetAttr{UID: 0}); err != linux.EPERM {
+		t.Errorf("SetAttr(UID: 0) = %v, want EPERM", err)
+	}
+	if err := file.SetAttr(p9.SetAttrMask{GID: true}, p9.SetAttr{GID: 0}); err != linux.EPERM {
+		t.Errorf("SetAttr(GID: 0) = %v, want EPERM", err)
+	}
+
+	// The server's credentials are restored.
+	if _, err := os.ReadFile(filepath.Join(dir, "secret")); err != nil {
+		t.Errorf("reading secret as root: %v", err)
+	}
+}
+
+func TestMultiUserByName(t *testing.T) {
+	dir := multiUserDir(t)
+	a := Attacher(dir, WithMultiUser()).(p9.UserAttacher)
+
+	root, err := a.AttachUser("root", p9.NoUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	_, f, err := root.Walk([]string{"secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, _, err := f.Open(p9.ReadOnly); err != nil {
+		t.Errorf("Open(secret) as root = %v", err)
+	}
+
+	// Root may create files for other users.
+	_, userdir, err := root.Walk([]string{"userdir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer userdir.Close()
+	if _, err := userdir.Mkdir("dir", 0755, testUID, testGID); err != nil {
+		t.Fatalf("Mkdir as %d:%d = %v", testUID, testGID, err)
+	}
+	if uid, gid := statOwner(t, filepath.Join(dir, "userdir", "dir")); uid != uint32(testUID) || gid != uint32(testGID) {
+		t.Errorf("owner = %d:%d, want %d:%d", uid, gid, testUID, testGID)
+	}
+
+	if _, err := a.AttachUser("p9-no-such-user", p9.NoUID); err != linux.EPERM {
+		t.Errorf("AttachUser(unknown name) = %v, want EPERM", err)
+	}
+	if _, err := a.AttachUser("", p9.NoUID); err != linux.EINVAL {
+		t.Errorf("AttachUser(no user) = %v, want EINVAL", err)
+	}
+}
@@ -0,0 +1,142 @@
+package localfs
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// Extended attributes used by OwnershipMapped. They are the same as QEMU's,
+// so directories can be shared between the two.
+const (
+	mappedPrefix = "user.virtfs."
+	mappedUID    = mappedPrefix + "uid"
+	mappedGID    = mappedPrefix + "gid"
+	mappedMode   = mappedPrefix + "mode"
+	mappedRDev   = mappedPrefix + "rdev"
+)
+
+// idArg converts a client-given ID to a chown argument.
+func idArg(id uint32, ok bool) int {
+	if !ok {
+		return -1
+	}
+	return int(id)
+}
+
+// setOwner applies the ownership given by a client to the newly created file
+// fd refers to.
+func (l *Local) setOwner(fd int, isSymlink bool, uid p9.UID, gid p9.GID) error {
+	if !uid.Ok() && !gid.Ok() {
+		return nil
+	}
+	switch l.a.ownership {
+	case OwnershipPassthrough:
+		err := unix.Fchownat(fd, "", idArg(uint32(uid), uid.Ok()), idArg(uint32(gid), gid.Ok()), unix.AT_EMPTY_PATH)
+		if err == unix.EPERM && !l.a.strict {
+			return nil
+		}
+		return err
+
+	case OwnershipMapped:
+		if isSymlink {
+			return nil
+		}
+		if uid.Ok() {
+			if err := unix.Setxattr(procPath(fd), mappedUID, le32(uint32(uid)), 0); err != nil {
+				return err
+			}
+		}
+		if gid.Ok() {
+			return unix.Setxattr(procPath(fd), mappedGID, le32(uint32(gid)), 0)
+		}
+	}
+	return nil
+}
+
+// chown changes the owner of l, honoring OwnershipMapped.
+func (l *Local) chown(isSymlink bool, uid p9.UID, gid p9.GID) error {
+	if l.a.ownership != OwnershipMapped {
+		return unix.Fchownat(l.fd, "", idArg(uint32(uid), uid.Ok()), idArg(uint32(gid), gid.Ok()), unix.AT_EMPTY_PATH)
+	}
+	if isSymlink {
+		return linux.EOPNOTSUPP
+	}
+	if uid.Ok() {
+		if err := unix.Setxattr(procPath(l.fd), mappedUID, le32(uint32(uid)), 0); err != nil {
+			return err
+		}
+	}
+	if gid.Ok() {
+		return unix.Setxattr(procPath(l.fd), mappedGID, le32(uint32(gid)), 0)
+	}
+	return nil
+}
+
+// chmod changes the permissions of l. For special files emulated by
+// OwnershipMapped, the recorded mode is changed.
+func (l *Local) chmod(perms p9.FileMode) error {
+	if l.a.ownership == OwnershipMapped {
+		if mode, ok := getMapped(l.fd, mappedMode); ok {
+			mode := p9.FileMode(mode).FileType() | perms.Permissions()
+			return unix.Setxattr(procPath(l.fd), mappedMode, le32(uint32(mode)), 0)
+		}
+	}
+	return unix.Fchmodat(unix.AT_FDCWD, procPath(l.fd), uint32(perms.Permissions()), 0)
+}
+
+func le32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+// getMapped returns one of the OwnershipMapped extended attributes.
+func getMapped(fd int, attr string) (uint64, bool) {
+	var buf [8]byte
+	n, err := unix.Getxattr(procPath(fd), attr, buf[:])
+	switch {
+	case err != nil:
+		return 0, false
+	case n == 4:
+		return uint64(binary.LittleEndian.Uint32(buf[:])), true
+	case n == 8:
+		return binary.LittleEndian.Uint64(buf[:]), true
+	}
+	return 0, false
+}
+
+// mapAttr replaces the attributes in attr recorded by OwnershipMapped.
+func (l *Local) mapAttr(attr *p9.Attr) {
+	if l.a.ownership != OwnershipMapped || attr.Mode.IsSymlink() {
+		return
+	}
+	if uid, ok := getMapped(l.fd, mappedUID); ok {
+		attr.UID = p9.UID(uid)
+	}
+	if gid, ok := getMapped(l.fd, mappedGID); ok {
+		attr.GID = p9.GID(gid)
+	}
+	if mode, ok := getMapped(l.fd, mappedMode); ok {
+		attr.Mode = p9.FileMode(mode)
+	}
+	if rdev, ok := getMapped(l.fd, mappedRDev); ok {
+		attr.RDev = p9.Dev(rdev)
+	}
+}
+
+// isMappedXattr returns true if attr is used by OwnershipMapped, in which
+// case it is hidden from clients.
+func (l *Local) isMappedXattr(attr string) bool {
+	return l.a.ownership == OwnershipMapped && strings.HasPrefix(attr, mappedPrefix)
+}
+
+// checkXattr rejects client access to extended attributes used by
+// OwnershipMapped.
+func (l *Local) checkXattr(attr string) error {
+	if l.isMappedXattr(attr) {
+		return linux.EPERM
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package localfs
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	testUID p9.UID = 1234
+	testGID p9.GID = 5678
+)
+
+// createAll creates one file of each kind with the given owner in root.
+func createAll(t *testing.T, root p9.File, uid p9.UID, gid p9.GID) map[string]p9.QID {
+	t.Helper()
+	qids := make(map[string]p9.QID)
+
+	f, qid, _, err := root.Create("file", p9.ReadWrite, 0640, uid, gid)
+	if err != nil {
+		t.Fatalf("Create = %v", err)
+	}
+	f.Close()
+	qids["file"] = qid
+
+	if qids["dir"], err = root.Mkdir("dir", 0750, uid, gid); err != nil {
+		t.Fatalf("Mkdir = %v", err)
+	}
+	if qids["symlink"], err = root.Symlink("file", "symlink", uid, gid); err != nil {
+		t.Fatalf("Symlink = %v", err)
+	}
+	if qids["fifo"], err = root.Mknod("fifo", p9.ModeNamedPipe|0600, 0, 0, uid, gid); err != nil {
+		t.Fatalf("Mknod(fifo) = %v", err)
+	}
+	if qids["socket"], err = root.Mknod("socket", p9.ModeSocket|0600, 0, 0, uid, gid); err != nil {
+		t.Fatalf("Mknod(socket) = %v", err)
+	}
+	return qids
+}
+
+func checkQIDs(t *testing.T, root p9.File, qids map[string]p9.QID) {
+	t.Helper()
+	for name, want := range qids {
+		got, f, err := root.Walk([]string{name})
+		if err != nil {
+			t.Fatalf("Walk(%s) = %v", name, err)
+		}
+		f.Close()
+		if want.Path == 0 || got[0] != want {
+			t.Errorf("%s: creation QID = %v, walk QID = %v", name, want, got[0])
+		}
+	}
+}
+
+func statOwner(t *testing.T, name string) (uint32, uint32) {
+	t.Helper()
+	fi, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	return st.Uid, st.Gid
+}
+
+func TestOwnershipPassthrough(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root")
+	}
+	dir := t.TempDir()
+	root, err := Attacher(dir, WithOwnership(OwnershipPassthrough)).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	qids := createAll(t, root, testUID, testGID)
+	checkQIDs(t, root, qids)
+	for name := range qids {
+		if uid, gid := statOwner(t, filepath.Join(dir, name)); uid != uint32(testUID) || gid != uint32(testGID) {
+			t.Errorf("%s: owner = %d:%d, want %d:%d", name, uid, gid, testUID, testGID)
+		}
+	}
+
+	// Only the GID is changed with NoUID.
+	if _, err := root.Mkdir("gidonly", 0777, p9.NoUID, testGID); err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := statOwner(t, filepath.Join(dir, "gidonly")); uid != 0 || gid != uint32(testGID) {
+		t.Errorf("gidonly: owner = %d:%d, want 0:%d", uid, gid, testGID)
+	}
+
+	if _, err := root.Mknod("chr", p9.ModeCharacterDevice|0600, 1, 3, testUID, testGID); err != nil {
+		t.Fatalf("Mknod(chr) = %v", err)
+	}
+	fi, err := os.Lstat(filepath.Join(dir, "chr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rdev := fi.Sys().(*syscall.Stat_t).Rdev; fi.Mode()&os.ModeCharDevice == 0 || rdev != unix.Mkdev(1, 3) {
+		t.Errorf("chr: mode = %v, rdev = %#x, want character device 1:3", fi.Mode(), rdev)
+	}
+}
+
+func TestOwnershipDefault(t *testing.T) {
+	dir := t.TempDir()
+	root, err := Attacher(dir).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	qids := createAll(t, root, testUID, testGID)
+	checkQIDs(t, root, qids)
+
+	// Without CAP_CHOWN, files are owned by the server's user.
+	want := uint32(testUID)
+	if os.Geteuid() != 0 {
+		want = uint32(os.Geteuid())
+	}
+	for name := range qids {
+		p := filepath.Join(dir, name)
+		if uid, _ := statOwner(t, p); uid != want {
+			t.Errorf("%s: owner = %d, want %d", name, uid, want)
+		}
+		if _, err := unix.Lgetxattr(p, mappedUID, nil); err != unix.ENODATA && err != unix.ENOTSUP {
+			t.Errorf("%s: Lgetxattr(%s) = %v, want ENODATA", name, mappedUID, err)
+		}
+	}
+}
+
+func TestOwnershipMapped(t *testing.T) {
+	dir := t.TempDir()
+	if err := unix.Setxattr(dir, "user.p9.test", []byte("y"), 0); err == unix.ENOTSUP {
+		t.Skip("user extended attributes are not supported")
+	}
+
+	root, err := Attacher(dir, WithOwnership(OwnershipMapped)).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	qids := createAll(t, root, testUID, testGID)
+	checkQIDs(t, root, qids)
+	if _, err := root.Mknod("chr", p9.ModeCharacterDevice|0600, 1, 3, testUID, testGID); err != nil {
+		t.Fatalf("Mknod(chr) = %v", err)
+	}
+
+	for _, name := range []string{"file", "dir", "fifo", "socket", "chr"} {
+		_, f, err := root.Walk([]string{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, attr, err := f.GetAttr(p9.AttrMaskAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attr.UID != testUID || attr.GID != testGID {
+			t.Errorf("%s: GetAttr owner = %d:%d, want %d:%d", name, attr.UID, attr.GID, testUID, testGID)
+		}
+		if name == "chr" {
+			if !attr.Mode.IsCharacterDevice() || attr.RDev != p9.Dev(unix.Mkdev(1, 3)) {
+				t.Errorf("chr: GetAttr mode = %v, rdev = %#x, want character device 1:3", attr.Mode, attr.RDev)
+			}
+		}
+
+		// The mapping attributes are hidden from clients.
+		attrs, err := f.ListXattrs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(attrs) != 0 {
+			t.Errorf("%s: ListXattrs = %v, want none", name, attrs)
+		}
+		if _, err := f.GetXattr(mappedUID); linux.ExtractErrno(err) != linux.ENODATA {
+			t.Errorf("%s: GetXattr(%s) = %v, want ENODATA", name, mappedUID, err)
+		}
+		if err := f.SetXattr(mappedUID, []byte{0, 0, 0, 0}, 0); linux.ExtractErrno(err) != linux.EPERM {
+			t.Errorf("%s: SetXattr(%s) = %v, want EPERM", name, mappedUID, err)
+		}
+		f.Close()
+	}
+
+	// Special files are regular files on the host.
+	fi, err := os.Lstat(filepath.Join(dir, "chr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.Mode().IsRegular() {
+		t.Errorf("chr: host mode = %v, want regular file", fi.Mode())
+	}
+}
+
+func TestOwnershipNone(t *testing.T) {
+	dir := t.TempDir()
+	root, err := Attacher(dir, WithOwnership(OwnershipNone)).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	qids := createAll(t, root, testUID, testGID)
+	checkQIDs(t, root, qids)
+	for name := range qids {
+		if uid, _ := statOwner(t, filepath.Join(dir, name)); uid != uint32(os.Geteuid()) {
+			t.Errorf("%s: owner = %d, want %d", name, uid, os.Geteuid())
+		}
+	}
+}
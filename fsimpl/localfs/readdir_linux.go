@@ -0,0 +1,159 @@
+package localfs
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// minDirentsSize is the minimum getdents buffer size. It fits any
+	// entry.
+	minDirentsSize = 4096
+
+	// maxDirentsSize is the maximum getdents buffer size.
+	maxDirentsSize = 64 * 1024
+)
+
+// dirReader reads a directory with getdents64(2).
+//
+// The d_off cookies returned by the kernel are used as Readdir offsets, so
+// any returned offset can be seeked to, even on another open file.
+type dirReader struct {
+	mu sync.Mutex
+
+	// pos is the offset of the open file.
+	pos uint64
+
+	// dev is the directory's device.
+	dev uint64
+
+	buf []byte
+}
+
+// dtypeToQIDType converts a d_type to a QID type.
+func dtypeToQIDType(dtype uint8) (p9.QIDType, bool) {
+	switch dtype {
+	case unix.DT_DIR:
+		return p9.TypeDir, true
+	case unix.DT_LNK:
+		return p9.TypeSymlink, true
+	case unix.DT_REG, unix.DT_FIFO, unix.DT_SOCK, unix.DT_CHR, unix.DT_BLK:
+		return p9.TypeRegular, true
+	}
+	return 0, false
+}
+
+// direntSize is the size of an entry called name in an Rreaddir message: its
+// QID, offset, type and name.
+func direntSize(name []byte) int {
+	return 13 + 8 + 1 + 2 + len(name)
+}
+
+// Readdir implements p9.File.Readdir.
+//
+// Entries are returned up to count bytes as the server encodes them, but at
+// least one. Each call reads at most one buffer of entries, whose size
+// depends on count. Reading a directory is therefore linear in its size.
+func (l *Local) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
+	if l.file == nil {
+		return nil, linux.EBADF
+	}
+	d := &l.dir
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fd := int(l.file.Fd())
+	if d.buf == nil {
+		stat, err := l.stat()
+		if err != nil {
+			return nil, err
+		}
+		d.dev = uint64(stat.Dev)
+		d.buf = make([]byte, min(max(int(count), minDirentsSize), maxDirentsSize))
+	}
+	if offset != d.pos {
+		if _, err := unix.Seek(fd, int64(offset), unix.SEEK_SET); err != nil {
+			return nil, err
+		}
+		d.pos = offset
+	}
+
+	var (
+		ents p9.Dirents
+		size int
+	)
+	for len(ents) == 0 {
+		n, err := unix.Getdents(fd, d.buf)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, nil
+		}
+
+		// struct linux_dirent64 {
+		//	ino64_t        d_ino;
+		//	off64_t        d_off;
+		//	unsigned short d_reclen;
+		//	unsigned char  d_type;
+		//	char           d_name[];
+		// };
+		for buf := d.buf[:n]; len(buf) > 0; {
+			ino := binary.NativeEndian.Uint64(buf[0:])
+			off := binary.NativeEndian.Uint64(buf[8:])
+			reclen := binary.NativeEndian.Uint16(buf[16:])
+			dtype := buf[18]
+			name := buf[19:reclen]
+			for i, c := range name {
+				if c == 0 {
+					name = name[:i]
+					break
+				}
+			}
+
+			if len(ents) > 0 && size+direntSize(name) > int(count) {
+				// Rewind to the next entry the client will
+				// ask for.
+				if _, err := unix.Seek(fd, int64(d.pos), unix.SEEK_SET); err != nil {
+					return nil, err
+				}
+				return ents, nil
+			}
+			buf = buf[reclen:]
+			d.pos = off
+
+			if string(name) == "." || string(name) == ".." {
+				continue
+			}
+			typ, ok := dtypeToQIDType(dtype)
+			if !ok {
+				// Not all file systems fill in d_type.
+				var stat unix.Stat_t
+				if err := unix.Fstatat(l.fd, string(name), &stat, unix.AT_SYMLINK_NOFOLLOW); err == unix.ENOENT {
+					// Removed since.
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				typ = p9.FileMode(stat.Mode).QIDType()
+			}
+			ents = append(ents, p9.Dirent{
+				QID: p9.QID{
+					Type: typ,
+					Path: qidPath(d.dev, ino),
+				},
+				Offset: off,
+				Type:   typ,
+				Name:   string(name),
+			})
+			size += direntSize(name)
+		}
+	}
+	return ents, nil
+}
@@ -0,0 +1,161 @@
+package localfs
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// spliceMinCount is the smallest read that is spliced. Smaller reads are
+// cheaper to copy than to set up a pipe for.
+const spliceMinCount = 32 << 10
+
+// ReadTo implements p9.ReaderTo.ReadTo.
+//
+// Reads from regular files to TCP and unix sockets are spliced from the file
+// into a pipe, and from the pipe to the socket, without copying the data to
+// user space.
+func (l *Local) ReadTo(w io.Writer, count uint32, offset int64) (p9.Payload, error) {
+	if count < spliceMinCount {
+		return nil, errors.ErrUnsupported
+	}
+	switch w.(type) {
+	case *net.TCPConn, *net.UnixConn:
+	default:
+		return nil, errors.ErrUnsupported
+	}
+
+	// The pipe needs a buffer per page, and an unaligned read spans an
+	// extra page.
+	p, err := newPipe(int(count) + os.Getpagesize())
+	if err != nil {
+		return nil, errors.ErrUnsupported
+	}
+	if err := p.fill(l.file, int(count), offset); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// pipe is a p9.Payload of file data spliced into a pipe.
+type pipe struct {
+	r, w int
+
+	// n is the number of bytes in the pipe.
+	n int
+}
+
+// newPipe returns a pipe that holds at least size bytes.
+func newPipe(size int) (*pipe, error) {
+	var fds [2]int
+	if err := unix.Pipe2(fds[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		return nil, err
+	}
+	p := &pipe{r: fds[0], w: fds[1]}
+	// Fails if size is above /proc/sys/fs/pipe-max-size, unless the
+	// server is privileged.
+	if got, err := unix.FcntlInt(uintptr(p.w), unix.F_SETPIPE_SZ, size); err != nil || got < size {
+		p.Close()
+		if err == nil {
+			err = unix.ENOMEM
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// fill splices up to count bytes at offset from f into the pipe.
+//
+// It returns errors.ErrUnsupported if f cannot be spliced from.
+func (p *pipe) fill(f *os.File, count int, offset int64) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Read(func(fd uintptr) bool {
+		for p.n < count {
+			off := offset + int64(p.n)
+			n, err := unix.Splice(int(fd), &off, p.w, nil, count-p.n, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+			if n > 0 {
+				p.n += int(n)
+			}
+			switch {
+			case err == unix.EINTR:
+				continue
+			case (err == unix.EINVAL || err == unix.EAGAIN) && p.n == 0:
+				// Not all files support splice.
+				serr = errors.ErrUnsupported
+			case err == unix.EAGAIN:
+				// The pipe is full, which should not happen
+				// given its size. Short reads are allowed.
+			case err != nil:
+				serr = &os.PathError{Op: "splice", Path: f.Name(), Err: err}
+			}
+			if err != nil || n == 0 {
+				break
+			}
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// Len implements p9.Payload.Len.
+func (p *pipe) Len() int {
+	return p.n
+}
+
+// WriteTo implements p9.Payload.WriteTo.
+func (p *pipe) WriteTo(w io.Writer) (int64, error) {
+	sc, ok := w.(syscall.Conn)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		written int64
+		serr    error
+	)
+	if err := rc.Write(func(fd uintptr) bool {
+		for p.n > 0 {
+			n, err := unix.Splice(p.r, nil, int(fd), nil, p.n, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+			if n > 0 {
+				written += int64(n)
+				p.n -= int(n)
+			}
+			switch err {
+			case nil:
+			case unix.EINTR:
+				continue
+			case unix.EAGAIN:
+				// Wait for the socket to be writable.
+				return false
+			default:
+				serr = os.NewSyscallError("splice", err)
+				return true
+			}
+		}
+		return true
+	}); err != nil {
+		return written, err
+	}
+	return written, serr
+}
+
+// Close implements p9.Payload.Close.
+func (p *pipe) Close() error {
+	unix.Close(p.r)
+	return unix.Close(p.w)
+}
@@ -0,0 +1,154 @@
+package localfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugelgupf/p9/p9"
+)
+
+// unixConns returns a connected pair of unix sockets.
+func unixConns(t *testing.T) (srv, cli net.Conn) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cli, err = net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, cli
+}
+
+func TestReadTo(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 3<<20+123)
+	rand.New(rand.NewSource(1)).Read(content)
+	if err := os.WriteFile(filepath.Join(dir, "file"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		conn func(t *testing.T) (net.Conn, net.Conn)
+	}{
+		{"unix", unixConns},
+		// Not a socket, so data is copied.
+		{"pipe", func(*testing.T) (net.Conn, net.Conn) { return net.Pipe() }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cli := tt.conn(t)
+			s := p9.NewServer(Attacher(dir))
+			done := make(chan struct{})
+			go func() {
+				_ = s.Handle(srv, srv)
+				close(done)
+			}()
+			defer func() {
+				cli.Close()
+				<-done
+			}()
+
+			c, err := p9.NewClient(cli, p9.WithMessageSize(1<<20))
+			if err != nil {
+				t.Fatal(err)
+			}
+			root, err := c.Attach("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer root.Close()
+			_, f, err := root.Walk([]string{"file"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, _, err := f.Open(p9.ReadOnly); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, r := range []struct {
+				offset, count int
+			}{
+				{0, 1 << 20},
+				{4097, 1<<20 - 1000},
+				{100, 40000},
+				{len(content) - 50000, 1 << 20},
+				{len(content), 1 << 20},
+			} {
+				buf := make([]byte, r.count)
+				n, err := f.ReadAt(buf, int64(r.offset))
+				if err != nil && n == 0 && r.offset < len(content) {
+					t.Errorf("ReadAt(%d, %d) = %v", r.offset, r.count, err)
+					continue
+				}
+				want := content[r.offset:min(r.offset+r.count, len(content))]
+				if !bytes.Equal(buf[:n], want) {
+					t.Errorf("ReadAt(%d, %d) = %d bytes, want %d matching bytes", r.offset, r.count, n, len(want))
+				}
+			}
+		})
+	}
+}
+
+func TestReadToPayload(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	if err := os.WriteFile(filepath.Join(dir, "file"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := Attacher(dir).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	_, f, err := root.Walk([]string{"file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, _, err := f.Open(p9.ReadOnly); err != nil {
+		t.Fatal(err)
+	}
+
+	pipeSrv, pipeCli := net.Pipe()
+	defer pipeSrv.Close()
+	defer pipeCli.Close()
+	if _, err := p9.ReadTo(f, pipeSrv, 1<<16, 0); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ReadTo(net.Pipe) = %v, want ErrUnsupported", err)
+	}
+
+	srv, cli := unixConns(t)
+	defer srv.Close()
+	defer cli.Close()
+	p, err := p9.ReadTo(f, srv, 1<<16, 50000)
+	if err != nil {
+		t.Fatalf("ReadTo(unix) = %v", err)
+	}
+	defer p.Close()
+	// Short at the end of the file.
+	if p.Len() != 50000 {
+		t.Errorf("Len = %d, want 50000", p.Len())
+	}
+	if n, err := p.WriteTo(srv); n != 50000 || err != nil {
+		t.Fatalf("WriteTo = %d, %v, want 50000", n, err)
+	}
+	got := make([]byte, 50000)
+	if _, err := io.ReadFull(cli, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content[50000:]) {
+		t.Errorf("spliced data differs from file")
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"sync/atomic"
 	"syscall"
 
-	"github.com/hugelgupf/p9/p9"
 	"golang.org/x/sys/unix"
 )
 
@@ -120,35 +119,20 @@ var nextQid atomic.Uint64 // set to 1 << 63 in init()
 
 func localToQid(_ string, fi os.FileInfo) (uint64, error) {
 	stat := fi.Sys().(*syscall.Stat_t)
-	if q, ok := encodeLikely(uint64(stat.Dev), stat.Ino); ok {
-		return q, nil
+	return qidPath(uint64(stat.Dev), uint64(stat.Ino)), nil
+}
+
+// qidPath returns a QID path unique to the given device and inode.
+func qidPath(dev, ino uint64) uint64 {
+	if q, ok := encodeLikely(dev, ino); ok {
+		return q
 	}
-	di := &devino{uint64(stat.Dev), stat.Ino}
+	di := devino{dev, ino}
 	if q, ok := qids.Load(di); ok {
-		return q.(uint64), nil
+		return q.(uint64)
 	}
 	// Could race and have two nextQids, but only one will win.  The other
 	// will be ignored.  This is fine.
 	q, _ := qids.LoadOrStore(di, nextQid.Add(1))
-	return q.(uint64), nil
-}
-
-// lock implements p9.File.Lock.
-func (l *Local) lock(pid int, locktype p9.LockType, flags p9.LockFlags, start, length uint64, client string) (p9.LockStatus, error) {
-	switch locktype {
-	case p9.ReadLock, p9.WriteLock:
-		if err := unix.Flock(int(l.file.Fd()), unix.LOCK_EX); err != nil {
-			return p9.LockStatusError, nil
-		}
-
-	case p9.Unlock:
-		if err := unix.Flock(int(l.file.Fd()), unix.LOCK_UN); err != nil {
-			return p9.LockStatusError, nil
-		}
-
-	default:
-		return p9.LockStatusOK, unix.ENOSYS
-	}
-
-	return p9.LockStatusOK, nil
+	return q.(uint64)
 }
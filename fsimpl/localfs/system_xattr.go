@@ -1,4 +1,4 @@
-//go:build unix && !solaris && !openbsd
+//go:build unix && !solaris && !openbsd && !linux
 
 package localfs
 
@@ -0,0 +1,286 @@
+package overlayfs
+
+import (
+	"io"
+	"strings"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// copyUp copies the file of n and its parents to the upper layer if they are
+// not there yet.
+//
+// Directories are copied without their contents, which stay merged with the
+// lower layer.
+//
+// Precondition: fs.mu is held for writing.
+func (fs *FS) copyUp(n *node) error {
+	if n.upper != nil {
+		return nil
+	}
+	// The root always exists in the upper layer, so only removed files
+	// have no parent.
+	if n.parent == nil {
+		return linux.ENOENT
+	}
+	if err := fs.copyUp(n.parent); err != nil {
+		return err
+	}
+	return copyUpEntry(n.parent.upper, n.name, &n.entry)
+}
+
+// copyUpTree copies n and everything below it to the upper layer, and makes
+// all copied directories opaque.
+//
+// Precondition: fs.mu is held for writing.
+func (fs *FS) copyUpTree(n *node) error {
+	if err := fs.copyUp(n); err != nil {
+		return err
+	}
+	if !n.isDir() {
+		return nil
+	}
+
+	children, _, err := fs.readMergedDir(&n.entry)
+	if err != nil {
+		return err
+	}
+	for _, name := range children {
+		c, err := fs.lookupChild(n, name)
+		if err != nil {
+			return err
+		}
+		err = fs.copyUpTree(c)
+		// Nothing below an opaque directory is in the lower layer.
+		c.inLower = false
+		fs.release(c)
+		if err != nil {
+			return err
+		}
+	}
+	return fs.markOpaque(n)
+}
+
+// markOpaque marks the upper directory of n as opaque, which hides its lower
+// directory.
+//
+// Precondition: fs.mu is held for writing.
+func (fs *FS) markOpaque(n *node) error {
+	if err := markOpaque(n.upper); err != nil {
+		return err
+	}
+	if n.lower != nil {
+		n.lower.Close()
+		n.lower = nil
+	}
+	return nil
+}
+
+// copyUpEntry copies the lower file of e to name in the upper directory dir,
+// and updates e to refer to the copy.
+func copyUpEntry(dir p9.File, name string, e *entry) error {
+	_, _, attr, err := e.lower.GetAttr(p9.AttrMaskAll)
+	if err != nil {
+		return err
+	}
+
+	perm := attr.Mode.Permissions()
+	switch {
+	case attr.Mode.IsDir():
+		_, err = dir.Mkdir(name, perm, attr.UID, attr.GID)
+	case attr.Mode.IsSymlink():
+		var target string
+		if target, err = e.lower.Readlink(); err == nil {
+			_, err = dir.Symlink(target, name, attr.UID, attr.GID)
+		}
+	case attr.Mode.IsRegular():
+		err = copyFile(dir, name, e.lower, perm, attr.UID, attr.GID)
+	default:
+		major, minor := unixDev(attr.RDev)
+		_, err = dir.Mknod(name, attr.Mode, major, minor, attr.UID, attr.GID)
+	}
+	if err != nil {
+		return err
+	}
+
+	qid, f, err := walkOne(dir, name)
+	if err == nil && f == nil {
+		err = linux.ENOENT
+	}
+	if err == nil {
+		if err = copyMetadata(e.lower, f, attr); err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		var flags uint32
+		if attr.Mode.IsDir() {
+			flags = unlinkRemoveDir
+		}
+		dir.UnlinkAt(name, flags)
+		return err
+	}
+
+	e.upper, e.upperQID = f, qid
+	if !attr.Mode.IsDir() {
+		e.lower.Close()
+		e.lower = nil
+	}
+	return nil
+}
+
+// copyFile creates name in dir with the contents of src.
+func copyFile(dir p9.File, name string, src p9.File, perm p9.FileMode, uid p9.UID, gid p9.GID) error {
+	_, r, err := src.Walk(nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, _, err := r.Open(p9.ReadOnly); err != nil {
+		return err
+	}
+
+	w, _, _, err := dir.Create(name, p9.WriteOnly, perm, uid, gid)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	buf := make([]byte, 64*1024)
+	var offset int64
+	for {
+		n, err := r.ReadAt(buf, offset)
+		if n > 0 {
+			if _, err := w.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || (err == nil && n == 0) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isUnsupported returns whether err means that an optional operation is not
+// supported by a layer.
+func isUnsupported(err error) bool {
+	errno := linux.ExtractErrno(err)
+	return errno == linux.ENOSYS || errno == linux.EOPNOTSUPP
+}
+
+// copyMetadata copies extended attributes and timestamps from src to dst.
+//
+// Layers that do not support either are tolerated.
+func copyMetadata(src, dst p9.File, attr p9.Attr) error {
+	if !attr.Mode.IsSymlink() {
+		names, err := src.ListXattrs()
+		if err != nil && !isUnsupported(err) {
+			return err
+		}
+		for _, name := range names {
+			data, err := src.GetXattr(name)
+			if err != nil {
+				return err
+			}
+			if err := dst.SetXattr(name, data, 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	err := dst.SetAttr(p9.SetAttrMask{
+		ATime:              true,
+		ATimeNotSystemTime: true,
+		MTime:              true,
+		MTimeNotSystemTime: true,
+	}, p9.SetAttr{
+		ATimeSeconds:     attr.ATimeSeconds,
+		ATimeNanoSeconds: attr.ATimeNanoSeconds,
+		MTimeSeconds:     attr.MTimeSeconds,
+		MTimeNanoSeconds: attr.MTimeNanoSeconds,
+	})
+	if err != nil && !isUnsupported(err) {
+		return err
+	}
+	return nil
+}
+
+// unixDev splits a Linux device number into its major and minor numbers.
+func unixDev(dev p9.Dev) (uint32, uint32) {
+	major := uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+	minor := uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+	return major, minor
+}
+
+// createMarker creates an empty file called name in dir, if it does not
+// exist yet.
+func createMarker(dir p9.File, name string) error {
+	f, _, _, err := dir.Create(name, p9.ReadOnly, 0, p9.NoUID, p9.NoGID)
+	if err != nil {
+		if linux.ExtractErrno(err) == linux.EEXIST {
+			return nil
+		}
+		return err
+	}
+	return f.Close()
+}
+
+// markOpaque marks the upper directory dir as opaque.
+func markOpaque(dir p9.File) error {
+	return createMarker(dir, opaqueName)
+}
+
+// whiteout hides name in the lower layer below the upper directory dir.
+func whiteout(dir p9.File, name string) error {
+	return createMarker(dir, whiteoutPrefix+name)
+}
+
+// removeWhiteout removes the whiteout of name from the upper directory dir.
+func removeWhiteout(dir p9.File, name string) error {
+	return dir.UnlinkAt(whiteoutPrefix+name, 0)
+}
+
+// removeWhiteoutIfExists is removeWhiteout, but does not fail if there is no
+// whiteout.
+func removeWhiteoutIfExists(dir p9.File, name string) error {
+	if err := removeWhiteout(dir, name); err != nil && linux.ExtractErrno(err) != linux.ENOENT {
+		return err
+	}
+	return nil
+}
+
+// removeWhiteouts removes all whiteouts and the opaque marker from the upper
+// directory dir, so that it can be removed or replaced.
+func removeWhiteouts(dir p9.File) error {
+	dirents, err := readdirAll(dir)
+	if err != nil {
+		return err
+	}
+	for _, de := range dirents {
+		if strings.HasPrefix(de.Name, whiteoutPrefix) {
+			if err := dir.UnlinkAt(de.Name, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkEmpty returns ENOTEMPTY if the directory e has any visible entries.
+//
+// Precondition: fs.mu is held.
+func (fs *FS) checkEmpty(e *entry) error {
+	names, _, err := fs.readMergedDir(e)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return linux.ENOTEMPTY
+	}
+	return nil
+}
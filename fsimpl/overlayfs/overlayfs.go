@@ -0,0 +1,1019 @@
+// Package overlayfs provides a p9.Attacher that overlays a writable upper file
+// system on top of a read-only lower file system.
+//
+// Lookups see the upper layer first. Directories present in both layers are
+// merged. The lower layer is never modified: the first modification of a lower
+// file copies it (including its extended attributes) to the upper layer, and
+// deletions of lower files are recorded in the upper layer.
+//
+// Deletions and directory replacements are recorded in the style of aufs,
+// which works with any upper layer that can create regular files:
+//
+//   - A whiteout ".wh.<name>" hides <name> in the lower layer.
+//   - An opaque marker ".wh..wh..opq" in an upper directory hides the
+//     contents of the corresponding lower directory.
+//
+// Names starting with ".wh." are therefore reserved and cannot be looked up
+// or created through the overlay.
+//
+// Each file refers to a node, which holds the file resolved in both layers.
+// Nodes are shared by all files of the same overlay file, updated in place by
+// copy-ups and moved by renames, so a lookup only walks one level in each
+// layer.
+package overlayfs
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/hugelgupf/p9/fsimpl/qids"
+	"github.com/hugelgupf/p9/fsimpl/readdir"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueName     = whiteoutPrefix + whiteoutPrefix + ".opq"
+
+	// unlinkRemoveDir is AT_REMOVEDIR, as passed to UnlinkAt.
+	unlinkRemoveDir = 0x200
+)
+
+// FS is a p9.Attacher that overlays a writable upper file system on top of a
+// read-only lower one.
+type FS struct {
+	// mu is held for writing by operations that change the upper layer,
+	// and for reading by lookups, so that lookups see consistent layers.
+	// It protects the entries, parents and names of all nodes.
+	mu sync.RWMutex
+
+	// nodesMu protects the references and children of all nodes.
+	nodesMu sync.Mutex
+
+	root *node
+
+	lowerQIDs *qids.Mapper
+	upperQIDs *qids.Mapper
+}
+
+var (
+	_ p9.Attacher = &FS{}
+	_ p9.File     = &file{}
+)
+
+// New attaches to lower and upper and returns an overlay of the two.
+//
+// lower is never modified.
+func New(lower, upper p9.Attacher) (*FS, error) {
+	l, err := lower.Attach()
+	if err != nil {
+		return nil, err
+	}
+	u, err := upper.Attach()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	root := &node{
+		entry: entry{
+			upper:    u,
+			upperQID: p9.QID{Type: p9.TypeDir},
+			lower:    l,
+			lowerQID: p9.QID{Type: p9.TypeDir},
+			inLower:  true,
+		},
+		// The reference of the FS, which is never dropped.
+		refs: 1,
+	}
+	fs := &FS{root: root}
+	if err := fs.mergeLower(&root.entry); err != nil {
+		root.close()
+		return nil, err
+	}
+	g := &qids.PathGenerator{}
+	fs.lowerQIDs = qids.NewMapper(g)
+	fs.upperQIDs = qids.NewMapper(g)
+	return fs, nil
+}
+
+// Attach implements p9.Attacher.Attach.
+func (fs *FS) Attach() (p9.File, error) {
+	return &file{fs: fs, node: fs.ref(fs.root)}, nil
+}
+
+// entry is a file resolved in both layers.
+type entry struct {
+	// upper is the file in the upper layer, or nil.
+	upper    p9.File
+	upperQID p9.QID
+
+	// lower is the file in the lower layer, if it is visible through
+	// upper. Only directories are merged, so lower is nil if upper is not
+	// a directory.
+	lower    p9.File
+	lowerQID p9.QID
+
+	// inLower is whether the name exists in the lower layer, even if it
+	// is shadowed by upper.
+	inLower bool
+}
+
+func (e *entry) close() {
+	if e.upper != nil {
+		e.upper.Close()
+	}
+	if e.lower != nil {
+		e.lower.Close()
+	}
+}
+
+func (e *entry) isDir() bool {
+	if e.upper != nil {
+		return e.upperQID.Type&p9.TypeDir != 0
+	}
+	return e.lowerQID.Type&p9.TypeDir != 0
+}
+
+// top returns the file that determines the entry's contents and attributes.
+func (e *entry) top() p9.File {
+	if e.upper != nil {
+		return e.upper
+	}
+	return e.lower
+}
+
+// qid returns the overlay QID of the entry's top layer.
+func (fs *FS) qid(e *entry) p9.QID {
+	if e.upper != nil {
+		return fs.upperQIDs.QIDFor(e.upperQID)
+	}
+	return fs.lowerQIDs.QIDFor(e.lowerQID)
+}
+
+// walkOne walks dir to name, returning a nil file if name does not exist.
+func walkOne(dir p9.File, name string) (p9.QID, p9.File, error) {
+	qids, f, err := dir.Walk([]string{name})
+	if err != nil {
+		if linux.ExtractErrno(err) == linux.ENOENT {
+			return p9.QID{}, nil, nil
+		}
+		return p9.QID{}, nil, err
+	}
+	if len(qids) != 1 {
+		f.Close()
+		return p9.QID{}, nil, linux.EIO
+	}
+	return qids[0], f, nil
+}
+
+// exists returns whether dir has an entry called name.
+func exists(dir p9.File, name string) (bool, error) {
+	_, f, err := walkOne(dir, name)
+	if f != nil {
+		f.Close()
+	}
+	return f != nil, err
+}
+
+// mergeLower drops e.lower if e.upper is an opaque directory.
+func (fs *FS) mergeLower(e *entry) error {
+	if e.upper == nil || e.lower == nil {
+		return nil
+	}
+	opaque, err := exists(e.upper, opaqueName)
+	if err != nil {
+		return err
+	}
+	if opaque {
+		e.lower.Close()
+		e.lower = nil
+	}
+	return nil
+}
+
+// node is an overlay file resolved in both layers, shared by all files that
+// refer to it.
+type node struct {
+	entry
+
+	// parent is nil for the root, and for files that were removed.
+	parent *node
+	name   string
+
+	// refs is the number of files and children that refer to the node.
+	refs int
+
+	// children are the nodes of the directory that are referred to, by
+	// name.
+	children map[string]*node
+}
+
+// ref adds a reference to n and returns it.
+func (fs *FS) ref(n *node) *node {
+	fs.nodesMu.Lock()
+	defer fs.nodesMu.Unlock()
+	n.refs++
+	return n
+}
+
+// release drops a reference to n. Nodes without references are closed, and
+// release their parents.
+func (fs *FS) release(n *node) {
+	for n != nil {
+		fs.nodesMu.Lock()
+		if n.refs--; n.refs > 0 {
+			fs.nodesMu.Unlock()
+			return
+		}
+		parent := n.parent
+		if parent != nil {
+			delete(parent.children, n.name)
+		}
+		fs.nodesMu.Unlock()
+
+		n.close()
+		n = parent
+	}
+}
+
+// detach removes n from its parent after the file was removed or replaced.
+// It is not found by lookups anymore, but keeps referring to the same files.
+//
+// Precondition: fs.mu is held for writing.
+func (fs *FS) detach(n *node) {
+	fs.nodesMu.Lock()
+	parent := n.parent
+	if parent != nil {
+		delete(parent.children, n.name)
+		n.parent = nil
+	}
+	fs.nodesMu.Unlock()
+
+	if parent != nil {
+		fs.release(parent)
+	}
+}
+
+// move moves n to name in the directory dir after a rename.
+//
+// Precondition: fs.mu is held for writing, and nothing is called name in dir.
+func (fs *FS) move(n, dir *node, name string) {
+	fs.nodesMu.Lock()
+	parent := n.parent
+	delete(parent.children, n.name)
+	if dir.children == nil {
+		dir.children = make(map[string]*node)
+	}
+	dir.children[name] = n
+	dir.refs++
+	n.parent, n.name = dir, name
+	fs.nodesMu.Unlock()
+
+	fs.release(parent)
+	fs.renamed(n)
+}
+
+// renamed calls Renamed on the upper files of n and everything below it after
+// n was moved, as the server does for the overlay's files. Layers may track
+// their files by path.
+//
+// Precondition: fs.mu is held for writing.
+func (fs *FS) renamed(n *node) {
+	if n.upper != nil && n.parent.upper != nil {
+		n.upper.Renamed(n.parent.upper, n.name)
+	}
+
+	fs.nodesMu.Lock()
+	children := make([]*node, 0, len(n.children))
+	for _, c := range n.children {
+		c.refs++
+		children = append(children, c)
+	}
+	fs.nodesMu.Unlock()
+
+	for _, c := range children {
+		fs.renamed(c)
+		fs.release(c)
+	}
+}
+
+// lookupChild returns a reference to the node of name in the directory dir.
+//
+// Precondition: fs.mu is held.
+func (fs *FS) lookupChild(dir *node, name string) (*node, error) {
+	if strings.HasPrefix(name, whiteoutPrefix) {
+		return nil, linux.ENOENT
+	}
+
+	fs.nodesMu.Lock()
+	c, ok := dir.children[name]
+	if ok {
+		c.refs++
+	}
+	fs.nodesMu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	e, err := fs.resolveChild(&dir.entry, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.nodesMu.Lock()
+	defer fs.nodesMu.Unlock()
+	// Another lookup may have resolved name in the meantime.
+	if c, ok := dir.children[name]; ok {
+		c.refs++
+		e.close()
+		return c, nil
+	}
+	c = &node{entry: *e, parent: dir, name: name, refs: 1}
+	if dir.children == nil {
+		dir.children = make(map[string]*node)
+	}
+	dir.children[name] = c
+	dir.refs++
+	return c, nil
+}
+
+// resolveChild resolves name in the directory dir in both layers.
+//
+// Precondition: fs.mu is held.
+func (fs *FS) resolveChild(dir *entry, name string) (*entry, error) {
+	c := &entry{}
+	lower := dir.lower
+	if dir.upper != nil {
+		qid, f, err := walkOne(dir.upper, name)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			c.upper, c.upperQID = f, qid
+		} else if wh, err := exists(dir.upper, whiteoutPrefix+name); err != nil {
+			return nil, err
+		} else if wh {
+			lower = nil
+		}
+	}
+
+	if lower != nil {
+		qid, f, err := walkOne(lower, name)
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		c.inLower = f != nil
+		if f != nil && (c.upper == nil || (c.isDir() && qid.Type&p9.TypeDir != 0)) {
+			c.lower, c.lowerQID = f, qid
+		} else if f != nil {
+			f.Close()
+		}
+	}
+
+	if c.upper == nil && c.lower == nil {
+		return nil, linux.ENOENT
+	}
+	if err := fs.mergeLower(c); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// readdirAll returns all entries of the directory f in a single layer.
+func readdirAll(f p9.File) (p9.Dirents, error) {
+	_, d, err := f.Walk(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	if _, _, err := d.Open(p9.ReadOnly); err != nil {
+		return nil, err
+	}
+
+	var (
+		dirents p9.Dirents
+		offset  uint64
+	)
+	for {
+		ds, err := d.Readdir(offset, 64*1024)
+		if err != nil {
+			return nil, err
+		}
+		if len(ds) == 0 {
+			return dirents, nil
+		}
+		for _, de := range ds {
+			if de.Name != "." && de.Name != ".." {
+				dirents = append(dirents, de)
+			}
+		}
+		offset = ds[len(ds)-1].Offset
+	}
+}
+
+// readMergedDir returns the sorted names and overlay QIDs of the directory e.
+//
+// Precondition: fs.mu is held.
+func (fs *FS) readMergedDir(e *entry) ([]string, map[string]p9.QID, error) {
+	var names []string
+	qids := make(map[string]p9.QID)
+	hidden := make(map[string]struct{})
+
+	if e.upper != nil {
+		dirents, err := readdirAll(e.upper)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, de := range dirents {
+			if strings.HasPrefix(de.Name, whiteoutPrefix) {
+				hidden[strings.TrimPrefix(de.Name, whiteoutPrefix)] = struct{}{}
+				continue
+			}
+			names = append(names, de.Name)
+			qids[de.Name] = fs.upperQIDs.QIDFor(de.QID)
+		}
+	}
+	if e.lower != nil {
+		dirents, err := readdirAll(e.lower)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, de := range dirents {
+			if _, ok := hidden[de.Name]; ok {
+				continue
+			}
+			if _, ok := qids[de.Name]; ok {
+				continue
+			}
+			names = append(names, de.Name)
+			qids[de.Name] = fs.lowerQIDs.QIDFor(de.QID)
+		}
+	}
+	slices.Sort(names)
+	return names, qids, nil
+}
+
+// file is a p9.File in the overlay.
+type file struct {
+	p9.DefaultWalkGetAttr
+
+	fs   *FS
+	node *node
+
+	// open is the opened file in either layer, and openUpper whether
+	// it is in the upper layer.
+	open      p9.File
+	openUpper bool
+
+	// mu protects the directory listing of an opened directory.
+	mu      sync.Mutex
+	dirents []string
+	qids    map[string]p9.QID
+}
+
+// Walk implements p9.File.Walk.
+func (f *file) Walk(names []string) ([]p9.QID, p9.File, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+
+	n := f.fs.ref(f.node)
+	qids := make([]p9.QID, 0, len(names))
+	for _, name := range names {
+		if !n.isDir() {
+			f.fs.release(n)
+			return nil, nil, linux.ENOTDIR
+		}
+		c, err := f.fs.lookupChild(n, name)
+		f.fs.release(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		n = c
+		qids = append(qids, f.fs.qid(&n.entry))
+	}
+	return qids, &file{fs: f.fs, node: n}, nil
+}
+
+// StatFS implements p9.File.StatFS.
+func (f *file) StatFS() (p9.FSStat, error) {
+	return f.fs.root.upper.StatFS()
+}
+
+// GetAttr implements p9.File.GetAttr.
+func (f *file) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	if f.open != nil {
+		qid, valid, attr, err := f.open.GetAttr(req)
+		if f.openUpper {
+			return f.fs.upperQIDs.QIDFor(qid), valid, attr, err
+		}
+		return f.fs.lowerQIDs.QIDFor(qid), valid, attr, err
+	}
+
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+
+	_, valid, attr, err := f.node.top().GetAttr(req)
+	return f.fs.qid(&f.node.entry), valid, attr, err
+}
+
+// SetAttr implements p9.File.SetAttr.
+func (f *file) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
+	if f.open != nil && f.openUpper {
+		return f.open.SetAttr(valid, attr)
+	}
+
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.copyUp(f.node); err != nil {
+		return err
+	}
+	return f.node.upper.SetAttr(valid, attr)
+}
+
+// Close implements p9.File.Close.
+func (f *file) Close() error {
+	var err error
+	if f.open != nil {
+		err = f.open.Close()
+	}
+	f.fs.release(f.node)
+	return err
+}
+
+// Open implements p9.File.Open.
+//
+// Files opened for writing are copied up first.
+func (f *file) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
+	open, qid, upper, err := f.clone(mode.Mode() != p9.ReadOnly)
+	if err != nil {
+		return p9.QID{}, 0, err
+	}
+	_, iounit, err := open.Open(mode)
+	if err != nil {
+		open.Close()
+		return p9.QID{}, 0, err
+	}
+	f.open, f.openUpper = open, upper
+	return qid, iounit, nil
+}
+
+// clone returns a new file for the top layer of f, its overlay QID, and
+// whether it is in the upper layer. f is copied up first if copyUp is set.
+func (f *file) clone(copyUp bool) (p9.File, p9.QID, bool, error) {
+	if copyUp {
+		f.fs.mu.Lock()
+		defer f.fs.mu.Unlock()
+		if err := f.fs.copyUp(f.node); err != nil {
+			return nil, p9.QID{}, false, err
+		}
+	} else {
+		f.fs.mu.RLock()
+		defer f.fs.mu.RUnlock()
+	}
+
+	_, c, err := f.node.top().Walk(nil)
+	if err != nil {
+		return nil, p9.QID{}, false, err
+	}
+	return c, f.fs.qid(&f.node.entry), f.node.upper != nil, nil
+}
+
+// ReadAt implements p9.File.ReadAt.
+func (f *file) ReadAt(p []byte, offset int64) (int, error) {
+	if f.open == nil {
+		return 0, linux.EBADF
+	}
+	return f.open.ReadAt(p, offset)
+}
+
+// ReadTo implements p9.ReaderTo.ReadTo.
+func (f *file) ReadTo(w io.Writer, count uint32, offset int64) (p9.Payload, error) {
+	if f.open == nil {
+		return nil, linux.EBADF
+	}
+	return p9.ReadTo(f.open, w, count, offset)
+}
+
+// WriteAt implements p9.File.WriteAt.
+func (f *file) WriteAt(p []byte, offset int64) (int, error) {
+	if f.open == nil || !f.openUpper {
+		return 0, linux.EBADF
+	}
+	return f.open.WriteAt(p, offset)
+}
+
+// FSync implements p9.File.FSync.
+func (f *file) FSync() error {
+	if f.open == nil {
+		return linux.EBADF
+	}
+	return f.open.FSync()
+}
+
+// Lock implements p9.File.Lock.
+func (f *file) Lock(pid int, locktype p9.LockType, flags p9.LockFlags, start, length uint64, client string) (p9.LockStatus, error) {
+	if f.open == nil {
+		return p9.LockStatusError, linux.EBADF
+	}
+	return f.open.Lock(pid, locktype, flags, start, length, client)
+}
+
+// GetLock implements p9.LockGetter.GetLock.
+func (f *file) GetLock(pid int, locktype p9.LockType, start, length uint64, client string) (p9.LockInfo, error) {
+	if f.open == nil {
+		return p9.LockInfo{}, linux.EBADF
+	}
+	return p9.GetLock(f.open, pid, locktype, start, length, client)
+}
+
+// SetXattr implements p9.File.SetXattr.
+func (f *file) SetXattr(attr string, data []byte, flags p9.XattrFlags) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.copyUp(f.node); err != nil {
+		return err
+	}
+	return f.node.upper.SetXattr(attr, data, flags)
+}
+
+// GetXattr implements p9.File.GetXattr.
+func (f *file) GetXattr(attr string) ([]byte, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	return f.node.top().GetXattr(attr)
+}
+
+// ListXattrs implements p9.File.ListXattrs.
+func (f *file) ListXattrs() ([]string, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	return f.node.top().ListXattrs()
+}
+
+// RemoveXattr implements p9.File.RemoveXattr.
+func (f *file) RemoveXattr(attr string) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.copyUp(f.node); err != nil {
+		return err
+	}
+	return f.node.upper.RemoveXattr(attr)
+}
+
+// create prepares the directory f for creating name in its upper layer, and
+// calls fn to do so. Any whiteout of name is removed afterwards.
+//
+// If a directory replaces a whiteout, the new directory is made opaque so the
+// lower directory's contents stay hidden.
+//
+// Precondition: f.fs.mu is held for writing.
+func (f *file) create(name string, isDir bool, fn func(dir p9.File) error) error {
+	if strings.HasPrefix(name, whiteoutPrefix) {
+		return linux.EINVAL
+	}
+
+	if err := f.fs.copyUp(f.node); err != nil {
+		return err
+	}
+	if !f.node.isDir() {
+		return linux.ENOTDIR
+	}
+	if c, err := f.fs.lookupChild(f.node, name); err == nil {
+		f.fs.release(c)
+		return linux.EEXIST
+	} else if linux.ExtractErrno(err) != linux.ENOENT {
+		return err
+	}
+
+	dir := f.node.upper
+	if err := fn(dir); err != nil {
+		return err
+	}
+	if ok, err := exists(dir, whiteoutPrefix+name); err != nil || !ok {
+		return err
+	}
+	if isDir {
+		_, d, err := walkOne(dir, name)
+		if err != nil {
+			return err
+		}
+		if d != nil {
+			err = markOpaque(d)
+			d.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return removeWhiteout(dir, name)
+}
+
+// Create implements p9.File.Create.
+func (f *file) Create(name string, flags p9.OpenFlags, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.File, p9.QID, uint32, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	var (
+		nf     p9.File
+		qid    p9.QID
+		iounit uint32
+	)
+	err := f.create(name, false, func(dir p9.File) (err error) {
+		nf, qid, iounit, err = dir.Create(name, flags, permissions, uid, gid)
+		return err
+	})
+	var n *node
+	if err == nil {
+		n, err = f.fs.lookupChild(f.node, name)
+	}
+	if err != nil {
+		if nf != nil {
+			nf.Close()
+		}
+		return nil, p9.QID{}, 0, err
+	}
+	return &file{
+		fs:        f.fs,
+		node:      n,
+		open:      nf,
+		openUpper: true,
+	}, f.fs.upperQIDs.QIDFor(qid), iounit, nil
+}
+
+// Mkdir implements p9.File.Mkdir.
+func (f *file) Mkdir(name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	var qid p9.QID
+	err := f.create(name, true, func(dir p9.File) (err error) {
+		qid, err = dir.Mkdir(name, permissions, uid, gid)
+		return err
+	})
+	return f.fs.upperQIDs.QIDFor(qid), err
+}
+
+// Symlink implements p9.File.Symlink.
+func (f *file) Symlink(oldName string, newName string, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	var qid p9.QID
+	err := f.create(newName, false, func(dir p9.File) (err error) {
+		qid, err = dir.Symlink(oldName, newName, uid, gid)
+		return err
+	})
+	return f.fs.upperQIDs.QIDFor(qid), err
+}
+
+// Mknod implements p9.File.Mknod.
+func (f *file) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	var qid p9.QID
+	err := f.create(name, false, func(dir p9.File) (err error) {
+		qid, err = dir.Mknod(name, mode, major, minor, uid, gid)
+		return err
+	})
+	return f.fs.upperQIDs.QIDFor(qid), err
+}
+
+// Link implements p9.File.Link.
+//
+// The target is copied up first.
+func (f *file) Link(target p9.File, newName string) error {
+	t, ok := target.(*file)
+	if !ok {
+		return linux.EXDEV
+	}
+
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.copyUp(t.node); err != nil {
+		return err
+	}
+	if t.node.isDir() {
+		return linux.EPERM
+	}
+
+	return f.create(newName, false, func(dir p9.File) error {
+		return dir.Link(t.node.upper, newName)
+	})
+}
+
+// UnlinkAt implements p9.File.UnlinkAt.
+//
+// Names that exist in the lower layer are replaced by whiteouts.
+func (f *file) UnlinkAt(name string, flags uint32) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.copyUp(f.node); err != nil {
+		return err
+	}
+
+	c, err := f.fs.lookupChild(f.node, name)
+	if err != nil {
+		return err
+	}
+	defer f.fs.release(c)
+
+	if removeDir := flags&unlinkRemoveDir != 0; removeDir && !c.isDir() {
+		return linux.ENOTDIR
+	} else if !removeDir && c.isDir() {
+		return linux.EISDIR
+	}
+	if c.isDir() {
+		if err := f.fs.checkEmpty(&c.entry); err != nil {
+			return err
+		}
+	}
+
+	if c.upper != nil {
+		if c.isDir() {
+			if err := removeWhiteouts(c.upper); err != nil {
+				return err
+			}
+		}
+		if err := f.node.upper.UnlinkAt(name, flags); err != nil {
+			return err
+		}
+	}
+	f.fs.detach(c)
+	if c.inLower {
+		return whiteout(f.node.upper, name)
+	}
+	return nil
+}
+
+// RenameAt implements p9.File.RenameAt.
+func (f *file) RenameAt(oldName string, newDir p9.File, newName string) error {
+	nd, ok := newDir.(*file)
+	if !ok {
+		return linux.EXDEV
+	}
+
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.fs.renameAt(f.node, oldName, nd.node, newName)
+}
+
+// renameAt renames oldName in the directory dir to newName in newDir.
+//
+// Renamed files are copied up first. Renamed directories are copied up
+// recursively and made opaque, so that they do not pick up the contents of a
+// lower directory at their new name.
+//
+// Precondition: fs.mu is held for writing.
+func (fs *FS) renameAt(dir *node, oldName string, newDir *node, newName string) error {
+	if strings.HasPrefix(newName, whiteoutPrefix) {
+		return linux.EINVAL
+	}
+	if dir == newDir && oldName == newName {
+		return nil
+	}
+
+	src, err := fs.lookupChild(dir, oldName)
+	if err != nil {
+		return err
+	}
+	defer fs.release(src)
+	srcIsDir, srcInLower := src.isDir(), src.inLower
+
+	if err := fs.copyUp(newDir); err != nil {
+		return err
+	}
+
+	// Make sure the rename will not move the new name's lower
+	// counterpart into view.
+	var (
+		hideLower  bool
+		replaceDir p9.File
+	)
+	dst, err := fs.lookupChild(newDir, newName)
+	switch {
+	case err == nil:
+		defer fs.release(dst)
+		if dstIsDir := dst.isDir(); srcIsDir && !dstIsDir {
+			return linux.ENOTDIR
+		} else if !srcIsDir && dstIsDir {
+			return linux.EISDIR
+		} else if dstIsDir {
+			if err := fs.checkEmpty(&dst.entry); err != nil {
+				return err
+			}
+			replaceDir = dst.upper
+		}
+		hideLower = dst.inLower
+	case linux.ExtractErrno(err) == linux.ENOENT:
+		// A whiteout may hide the new name in the lower layer.
+		hideLower, err = exists(newDir.upper, whiteoutPrefix+newName)
+		if err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	if srcIsDir && srcInLower {
+		if err := fs.copyUpTree(src); err != nil {
+			return err
+		}
+	}
+	// This also copies up dir.
+	if err := fs.copyUp(src); err != nil {
+		return err
+	}
+	if srcIsDir && hideLower {
+		if err := fs.markOpaque(src); err != nil {
+			return err
+		}
+	}
+
+	// Not every upper layer can rename over a directory, so remove it
+	// first.
+	if replaceDir != nil {
+		if err := removeWhiteouts(replaceDir); err != nil {
+			return err
+		}
+		if err := newDir.upper.UnlinkAt(newName, unlinkRemoveDir); err != nil {
+			return err
+		}
+		fs.detach(dst)
+	}
+	if err := dir.upper.RenameAt(oldName, newDir.upper, newName); err != nil {
+		return err
+	}
+	if dst != nil {
+		fs.detach(dst)
+	}
+	fs.move(src, newDir, newName)
+	src.inLower = hideLower
+
+	if srcInLower {
+		if err := whiteout(dir.upper, oldName); err != nil {
+			return err
+		}
+	}
+	return removeWhiteoutIfExists(newDir.upper, newName)
+}
+
+// Rename implements p9.File.Rename.
+func (f *file) Rename(newDir p9.File, newName string) error {
+	nd, ok := newDir.(*file)
+	if !ok {
+		return linux.EXDEV
+	}
+
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.node == f.fs.root {
+		return linux.EBUSY
+	}
+	if f.node.parent == nil {
+		return linux.ENOENT
+	}
+	return f.fs.renameAt(f.node.parent, f.node.name, nd.node, newName)
+}
+
+// Renamed implements p9.File.Renamed.
+//
+// Nodes are moved by the rename itself.
+func (f *file) Renamed(newDir p9.File, newName string) {}
+
+// Readdir implements p9.File.Readdir.
+//
+// The merged directory listing is read when offset is 0.
+func (f *file) Readdir(offset uint64, count uint32) (p9.Dirents, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if offset == 0 || f.qids == nil {
+		f.fs.mu.RLock()
+		var err error
+		f.dirents, f.qids, err = f.fs.readMergedDir(&f.node.entry)
+		f.fs.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return readdir.Readdir(offset, count, f.dirents, f.qids)
+}
+
+// Readlink implements p9.File.Readlink.
+func (f *file) Readlink() (string, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	return f.node.top().Readlink()
+}
@@ -0,0 +1,60 @@
+package overlayfs
+
+import (
+	"testing"
+
+	"github.com/hugelgupf/p9/p9"
+)
+
+// TestRenameKeepsReplacedFiles needs files of the layers that refer to the
+// same file after it was replaced, as only Linux localfs files do.
+func TestRenameKeepsReplacedFiles(t *testing.T) {
+	_, root, _, _ := newOverlay(t, nil, map[string]string{
+		"a": "upper a",
+		"b": "bb",
+	})
+
+	_, a, err := root.Walk([]string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	if err := root.RenameAt("b", root, "a"); err != nil {
+		t.Fatalf("RenameAt(b, a) = %v", err)
+	}
+
+	// a still refers to the replaced file, not to b at its name.
+	if _, _, attr, err := a.GetAttr(p9.AttrMask{Size: true}); err != nil || attr.Size != 7 {
+		t.Errorf("GetAttr(a) = size %d, %v, want 7", attr.Size, err)
+	}
+}
+
+func TestGetLock(t *testing.T) {
+	_, root, _, _ := newOverlay(t, map[string]string{"file": "lower"}, nil)
+
+	open := func() p9.File {
+		t.Helper()
+		_, f, err := root.Walk([]string{"file"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := f.Open(p9.ReadWrite); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	a, b := open(), open()
+
+	if got, err := a.Lock(1, p9.WriteLock, 0, 0, 10, "client"); err != nil || got != p9.LockStatusOK {
+		t.Fatalf("Lock = %v, %v, want OK", got, err)
+	}
+	got, err := p9.GetLock(b, 1, p9.WriteLock, 0, 0, "client")
+	if err != nil {
+		t.Fatalf("GetLock = %v", err)
+	}
+	if want := (p9.LockInfo{Type: p9.WriteLock, Start: 0, Length: 10, PID: -1}); got != want {
+		t.Errorf("GetLock = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,412 @@
+package overlayfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/localfs"
+	"github.com/hugelgupf/p9/fsimpl/test"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// writeFiles creates files with the given contents under dir. Names ending in
+// "/" are directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(p, 0777); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func newOverlay(t *testing.T, lowerFiles, upperFiles map[string]string) (fs *FS, root p9.File, lower, upper string) {
+	t.Helper()
+	lower, upper = t.TempDir(), t.TempDir()
+	writeFiles(t, lower, lowerFiles)
+	writeFiles(t, upper, upperFiles)
+
+	fs, err := New(localfs.Attacher(lower), localfs.Attacher(upper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err = fs.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	return fs, root, lower, upper
+}
+
+func TestOverlayFS(t *testing.T) {
+	fs, _, _, _ := newOverlay(t, nil, nil)
+
+	test.TestFile(t, fs)
+	test.TestReadOnlyFS(t, fs)
+	test.TestReadWriteFS(t, fs)
+}
+
+func TestMergedDirs(t *testing.T) {
+	fs, _, _, _ := newOverlay(t, map[string]string{
+		"a":         "lower a",
+		"shadowed":  "lower",
+		"dir/x":     "lower x",
+		"lowerdir/": "",
+	}, map[string]string{
+		"b":        "upper b",
+		"shadowed": "upper",
+		"dir/y":    "upper y",
+	})
+
+	test.TestReadOnlyFS(t, fs,
+		test.WithDir("", "a", "b", "dir", "lowerdir", "shadowed"),
+		test.WithDir("dir", "x", "y"),
+		test.WithDir("lowerdir"),
+		test.WithFile("a", "lower a", p9.Attr{Size: 7}, p9.AttrMask{Size: true}),
+		test.WithFile("shadowed", "upper", p9.Attr{Size: 5}, p9.AttrMask{Size: true}),
+		test.WithFile("dir/x", "lower x", p9.Attr{Size: 7}, p9.AttrMask{Size: true}),
+		test.WithFile("dir/y", "upper y", p9.Attr{Size: 7}, p9.AttrMask{Size: true}),
+	)
+}
+
+func TestCopyUp(t *testing.T) {
+	fs, root, lower, upper := newOverlay(t, map[string]string{
+		"dir/sub/file": "lower content",
+		"dir/other":    "other",
+	}, nil)
+
+	// Set an xattr in the lower layer, if supported.
+	lroot, err := localfs.Attacher(lower).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lroot.Close()
+	_, lf, err := lroot.Walk([]string{"dir", "sub", "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	wantXattr := true
+	if err := lf.SetXattr("user.p9.test", []byte("y"), 0); isUnsupported(err) {
+		wantXattr = false
+	} else if err != nil {
+		t.Fatalf("SetXattr = %v", err)
+	}
+
+	_, f, err := root.Walk([]string{"dir", "sub", "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, _, err := f.Open(p9.ReadWrite); err != nil {
+		t.Fatalf("Open(ReadWrite) = %v", err)
+	}
+	if _, err := f.WriteAt([]byte("upper"), 0); err != nil {
+		t.Fatalf("WriteAt = %v", err)
+	}
+
+	if got, want := readFile(t, filepath.Join(lower, "dir/sub/file")), "lower content"; got != want {
+		t.Errorf("lower file = %q, want %q", got, want)
+	}
+	if got, want := readFile(t, filepath.Join(upper, "dir/sub/file")), "upper content"; got != want {
+		t.Errorf("upper file = %q, want %q", got, want)
+	}
+	if wantXattr {
+		got, err := f.GetXattr("user.p9.test")
+		if err != nil || string(got) != "y" {
+			t.Errorf("GetXattr = %q, %v, want y", got, err)
+		}
+	}
+	// Only the parents were copied up, not their other contents.
+	if _, err := os.Stat(filepath.Join(upper, "dir/other")); !os.IsNotExist(err) {
+		t.Errorf("dir/other was copied up: %v", err)
+	}
+
+	test.TestReadOnlyFS(t, fs,
+		test.WithDir("dir", "other", "sub"),
+		test.WithFile("dir/sub/file", "upper content", p9.Attr{Size: 13}, p9.AttrMask{Size: true}),
+	)
+}
+
+func TestWhiteout(t *testing.T) {
+	fs, root, lower, _ := newOverlay(t, map[string]string{
+		"file":      "lower",
+		"dir/x":     "x",
+		"dir/sub/y": "y",
+	}, nil)
+
+	if err := root.UnlinkAt("file", 0); err != nil {
+		t.Fatalf("UnlinkAt(file) = %v", err)
+	}
+	if _, _, err := root.Walk([]string{"file"}); linux.ExtractErrno(err) != linux.ENOENT {
+		t.Errorf("Walk(file) = %v, want ENOENT", err)
+	}
+	if _, err := os.Stat(filepath.Join(lower, "file")); err != nil {
+		t.Errorf("lower file was removed: %v", err)
+	}
+
+	// Non-empty directories cannot be removed.
+	if err := root.UnlinkAt("dir", unlinkRemoveDir); linux.ExtractErrno(err) != linux.ENOTEMPTY {
+		t.Errorf("UnlinkAt(dir) = %v, want ENOTEMPTY", err)
+	}
+	_, dir, err := root.Walk([]string{"dir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	if err := dir.UnlinkAt("x", 0); err != nil {
+		t.Fatalf("UnlinkAt(dir/x) = %v", err)
+	}
+	if err := dir.UnlinkAt("sub", 0); linux.ExtractErrno(err) != linux.EISDIR {
+		t.Errorf("UnlinkAt(dir/sub, 0) = %v, want EISDIR", err)
+	}
+	if err := dir.UnlinkAt("sub", unlinkRemoveDir); linux.ExtractErrno(err) != linux.ENOTEMPTY {
+		t.Errorf("UnlinkAt(dir/sub) = %v, want ENOTEMPTY", err)
+	}
+	_, sub, err := dir.Walk([]string{"sub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := sub.UnlinkAt("y", 0); err != nil {
+		t.Fatalf("UnlinkAt(dir/sub/y) = %v", err)
+	}
+	if err := dir.UnlinkAt("sub", unlinkRemoveDir); err != nil {
+		t.Fatalf("UnlinkAt(dir/sub) = %v", err)
+	}
+	if err := root.UnlinkAt("dir", unlinkRemoveDir); err != nil {
+		t.Fatalf("UnlinkAt(dir) = %v", err)
+	}
+	test.TestReadOnlyFS(t, fs, test.WithDir(""))
+
+	// Recreated names do not show lower contents.
+	f, _, _, err := root.Create("file", p9.ReadWrite, 0666, p9.NoUID, p9.NoGID)
+	if err != nil {
+		t.Fatalf("Create(file) = %v", err)
+	}
+	if _, err := f.WriteAt([]byte("new"), 0); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := root.Mkdir("dir", 0777, p9.NoUID, p9.NoGID); err != nil {
+		t.Fatalf("Mkdir(dir) = %v", err)
+	}
+	test.TestReadOnlyFS(t, fs,
+		test.WithDir("", "dir", "file"),
+		test.WithDir("dir"),
+		test.WithFile("file", "new", p9.Attr{Size: 3}, p9.AttrMask{Size: true}),
+	)
+}
+
+func TestRename(t *testing.T) {
+	fs, root, lower, _ := newOverlay(t, map[string]string{
+		"file":       "lower file",
+		"dir/x":      "x",
+		"dir/sub/y":  "y",
+		"target/z":   "z",
+		"replaced":   "replaced",
+		"emptydir/":  "",
+		"otherdir/w": "w",
+	}, nil)
+
+	if err := root.RenameAt("file", root, "renamed"); err != nil {
+		t.Fatalf("RenameAt(file, renamed) = %v", err)
+	}
+	if err := root.RenameAt("renamed", root, "replaced"); err != nil {
+		t.Fatalf("RenameAt(renamed, replaced) = %v", err)
+	}
+
+	// Directories are moved with their lower contents.
+	if err := root.RenameAt("dir", root, "moved"); err != nil {
+		t.Fatalf("RenameAt(dir, moved) = %v", err)
+	}
+	if err := root.RenameAt("moved", root, "target"); linux.ExtractErrno(err) != linux.ENOTEMPTY {
+		t.Errorf("RenameAt(moved, target) = %v, want ENOTEMPTY", err)
+	}
+
+	// The lower contents of a replaced directory stay hidden.
+	if err := root.UnlinkAt("target", unlinkRemoveDir); linux.ExtractErrno(err) != linux.ENOTEMPTY {
+		t.Errorf("UnlinkAt(target) = %v, want ENOTEMPTY", err)
+	}
+	_, target, err := root.Walk([]string{"target"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	if err := target.UnlinkAt("z", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.RenameAt("emptydir", root, "target"); err != nil {
+		t.Fatalf("RenameAt(emptydir, target) = %v", err)
+	}
+	if err := root.RenameAt("moved", root, "otherdir"); linux.ExtractErrno(err) != linux.ENOTEMPTY {
+		t.Errorf("RenameAt(moved, otherdir) = %v, want ENOTEMPTY", err)
+	}
+	if err := root.RenameAt("replaced", root, "otherdir"); linux.ExtractErrno(err) != linux.EISDIR {
+		t.Errorf("RenameAt(replaced, otherdir) = %v, want EISDIR", err)
+	}
+
+	test.TestReadOnlyFS(t, fs,
+		test.WithDir("", "moved", "otherdir", "replaced", "target"),
+		test.WithDir("moved", "sub", "x"),
+		test.WithDir("moved/sub", "y"),
+		test.WithDir("target"),
+		test.WithFile("replaced", "lower file", p9.Attr{Size: 10}, p9.AttrMask{Size: true}),
+		test.WithFile("moved/sub/y", "y", p9.Attr{Size: 1}, p9.AttrMask{Size: true}),
+	)
+
+	// The lower layer is untouched.
+	for _, name := range []string{"file", "dir/x", "dir/sub/y", "target/z", "replaced", "emptydir"} {
+		if _, err := os.Stat(filepath.Join(lower, name)); err != nil {
+			t.Errorf("lower %s: %v", name, err)
+		}
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	_, root, _, _ := newOverlay(t, nil, nil)
+
+	if _, _, _, err := root.Create(whiteoutPrefix+"foo", p9.ReadWrite, 0666, p9.NoUID, p9.NoGID); linux.ExtractErrno(err) != linux.EINVAL {
+		t.Errorf("Create(%s) = %v, want EINVAL", whiteoutPrefix+"foo", err)
+	}
+	if _, _, err := root.Walk([]string{opaqueName}); linux.ExtractErrno(err) != linux.ENOENT {
+		t.Errorf("Walk(%s) = %v, want ENOENT", opaqueName, err)
+	}
+}
+
+func TestRenameMovesFiles(t *testing.T) {
+	_, root, _, _ := newOverlay(t, map[string]string{
+		"dir/x": "lower x",
+	}, map[string]string{
+		"up/y": "upper y",
+	})
+
+	walk := func(names ...string) p9.File {
+		t.Helper()
+		_, f, err := root.Walk(names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	read := func(f p9.File, want string) {
+		t.Helper()
+		if _, _, err := f.Open(p9.ReadOnly); err != nil {
+			t.Fatalf("Open = %v", err)
+		}
+		buf := make([]byte, 16)
+		if n, err := f.ReadAt(buf, 0); string(buf[:n]) != want {
+			t.Errorf("ReadAt = %q, %v, want %q", buf[:n], err, want)
+		}
+	}
+	x, up, y := walk("dir", "x"), walk("up"), walk("up", "y")
+
+	if err := root.RenameAt("dir", root, "moved"); err != nil {
+		t.Fatalf("RenameAt(dir, moved) = %v", err)
+	}
+	if err := root.RenameAt("up", root, "moved2"); err != nil {
+		t.Fatalf("RenameAt(up, moved2) = %v", err)
+	}
+
+	// Files moved with their directories.
+	read(x, "lower x")
+	read(y, "upper y")
+	_, y2, err := up.Walk([]string{"y"})
+	if err != nil {
+		t.Fatalf("Walk(y) in moved directory = %v", err)
+	}
+	defer y2.Close()
+	read(y2, "upper y")
+}
+
+// countingFile counts the walks of a file and of all files walked from it.
+type countingFile struct {
+	p9.File
+	walks *int
+}
+
+func (f countingFile) Walk(names []string) ([]p9.QID, p9.File, error) {
+	*f.walks++
+	qids, c, err := f.File.Walk(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return qids, countingFile{c, f.walks}, nil
+}
+
+type countingAttacher struct {
+	p9.Attacher
+	walks *int
+}
+
+func (a countingAttacher) Attach() (p9.File, error) {
+	f, err := a.Attacher.Attach()
+	if err != nil {
+		return nil, err
+	}
+	return countingFile{f, a.walks}, nil
+}
+
+func TestLookupCost(t *testing.T) {
+	lower, upper := t.TempDir(), t.TempDir()
+	writeFiles(t, lower, map[string]string{"a/b/c/d/e/file": "lower"})
+	writeFiles(t, upper, map[string]string{"a/b/c/d/e/": ""})
+
+	var walks int
+	fs, err := New(countingAttacher{localfs.Attacher(lower), &walks}, countingAttacher{localfs.Attacher(upper), &walks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fs.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	_, dir, err := root.Walk([]string{"a", "b", "c", "d", "e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+
+	walks = 0
+	if _, _, _, err := dir.GetAttr(p9.AttrMask{Mode: true}); err != nil {
+		t.Fatal(err)
+	}
+	if walks != 0 {
+		t.Errorf("GetAttr walked %d times, want 0", walks)
+	}
+
+	walks = 0
+	_, f, err := dir.Walk([]string{"file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// A walk in the upper directory, a whiteout check, a walk in the
+	// lower directory and an opaque check do not depend on the depth.
+	if walks > 4 {
+		t.Errorf("Walk(file) walked %d times, want at most 4", walks)
+	}
+}
@@ -1,6 +1,8 @@
 package qids
 
 import (
+	"io"
+	"sync"
 	"sync/atomic"
 
 	"github.com/hugelgupf/p9/p9"
@@ -27,7 +29,11 @@ func (g *PathGenerator) NewPath() uint64 {
 }
 
 type Mapper struct {
-	g     *PathGenerator
+	g *PathGenerator
+
+	// mu protects paths. Mappers are shared by all files walked to from a
+	// wrapped file, which may be used concurrently.
+	mu    sync.Mutex
 	paths map[uint64]uint64
 }
 
@@ -38,6 +44,9 @@ func NewMapper(g *PathGenerator) *Mapper {
 }
 
 func (m *Mapper) QIDFor(q p9.QID) p9.QID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if path, ok := m.paths[q.Path]; ok {
 		return p9.QID{
 			Type:    q.Type,
@@ -130,3 +139,33 @@ func (q qidTransformFile) Readdir(offset uint64, count uint32) (p9.Dirents, erro
 	}
 	return dirents, err
 }
+
+// ReadTo implements p9.ReaderTo.ReadTo.
+func (q qidTransformFile) ReadTo(w io.Writer, count uint32, offset int64) (p9.Payload, error) {
+	return p9.ReadTo(q.File, w, count, offset)
+}
+
+// GetLock implements p9.LockGetter.GetLock.
+func (q qidTransformFile) GetLock(pid int, locktype p9.LockType, start, length uint64, client string) (p9.LockInfo, error) {
+	return p9.GetLock(q.File, pid, locktype, start, length, client)
+}
+
+// A Version tracks the QID version of a file kept by the file server itself,
+// e.g. in memory. Such file servers call Bump on every write or SetAttr, so
+// that clients caching the file notice the change.
+//
+// The zero value is ready to use.
+type Version struct {
+	v atomic.Uint32
+}
+
+// Bump increments the version.
+func (v *Version) Bump() {
+	v.v.Add(1)
+}
+
+// QID returns q with the current version.
+func (v *Version) QID(q p9.QID) p9.QID {
+	q.Version = v.v.Load()
+	return q
+}
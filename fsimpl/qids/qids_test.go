@@ -1,8 +1,12 @@
 package qids
 
 import (
+	"io"
+	"net"
+	"sync"
 	"testing"
 
+	"github.com/hugelgupf/p9/fsimpl/templatefs"
 	"github.com/hugelgupf/p9/p9"
 )
 
@@ -29,3 +33,140 @@ func TestGen(t *testing.T) {
 		}
 	}
 }
+
+// optionalFile implements the optional interfaces of p9.File.
+type optionalFile struct {
+	p9.File
+}
+
+func (optionalFile) ReadTo(w io.Writer, count uint32, offset int64) (p9.Payload, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (optionalFile) GetLock(pid int, locktype p9.LockType, start, length uint64, client string) (p9.LockInfo, error) {
+	return p9.LockInfo{Type: p9.WriteLock, PID: 42}, nil
+}
+
+func TestWrapperFileInterfaces(t *testing.T) {
+	f := NewWrapperFile(optionalFile{}, NewMapper(&PathGenerator{}))
+
+	if _, err := p9.ReadTo(f, nil, 1, 0); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadTo = %v, want the wrapped file's error", err)
+	}
+	if got, err := p9.GetLock(f, 1, p9.WriteLock, 0, 0, "client"); err != nil || got.PID != 42 {
+		t.Errorf("GetLock = %v, %v, want the wrapped file's lock", got, err)
+	}
+}
+
+// memFile is a file kept in memory, whose version is bumped by writes and
+// SetAttr.
+type memFile struct {
+	templatefs.NoopFile
+	p9.DefaultWalkGetAttr
+
+	mu      sync.Mutex
+	data    []byte
+	version Version
+}
+
+func (f *memFile) qid() p9.QID {
+	return f.version.QID(p9.QID{Type: p9.TypeRegular, Path: 1})
+}
+
+func (f *memFile) Walk(names []string) ([]p9.QID, p9.File, error) {
+	return nil, f, nil
+}
+
+func (f *memFile) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
+	return f.qid(), 0, nil
+}
+
+func (f *memFile) GetAttr(req p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.qid(), p9.AttrMask{Mode: true, Size: true}, p9.Attr{Mode: p9.ModeRegular | 0o644, Size: uint64(len(f.data))}, nil
+}
+
+func (f *memFile) WriteAt(p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := int(offset) + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	copy(f.data[offset:], p)
+	f.version.Bump()
+	return len(p), nil
+}
+
+func (f *memFile) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if valid.Size {
+		f.data = f.data[:min(int(attr.Size), len(f.data))]
+	}
+	f.version.Bump()
+	return nil
+}
+
+type memAttacher struct {
+	f *memFile
+}
+
+func (a memAttacher) Attach() (p9.File, error) {
+	return a.f, nil
+}
+
+func TestVersion(t *testing.T) {
+	srv, cli := net.Pipe()
+	s := p9.NewServer(memAttacher{&memFile{}})
+	done := make(chan struct{})
+	go func() {
+		_ = s.Handle(srv, srv)
+		close(done)
+	}()
+	defer func() {
+		cli.Close()
+		<-done
+	}()
+
+	c, err := p9.NewClient(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.Attach("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	version := func() uint32 {
+		t.Helper()
+		qid, _, _, err := f.GetAttr(p9.AttrMask{Size: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return qid.Version
+	}
+
+	v := version()
+	if v2 := version(); v2 != v {
+		t.Errorf("version changed from %d to %d without changes", v, v2)
+	}
+	if _, _, err := f.Open(p9.ReadWrite); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("data"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v2 := version(); v2 == v {
+		t.Errorf("version %d did not change after WriteAt", v)
+	} else {
+		v = v2
+	}
+	if err := f.SetAttr(p9.SetAttrMask{Size: true}, p9.SetAttr{Size: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if v2 := version(); v2 == v {
+		t.Errorf("version %d did not change after SetAttr", v)
+	}
+}
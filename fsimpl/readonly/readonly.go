@@ -0,0 +1,171 @@
+// Package readonly provides a p9.Attacher wrapper that rejects all
+// modifications with EROFS.
+package readonly
+
+import (
+	"io"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// openTruncate is O_TRUNC as sent by Linux clients in Tlopen.
+const openTruncate = 0x200
+
+type attacher struct {
+	p9.Attacher
+}
+
+// Attacher returns an attacher that exposes a's files read-only.
+//
+// Every mutating operation returns EROFS, regardless of whether a supports
+// it.
+func Attacher(a p9.Attacher) p9.Attacher {
+	return &attacher{a}
+}
+
+// Attach implements p9.Attacher.Attach.
+func (a *attacher) Attach() (p9.File, error) {
+	f, err := a.Attacher.Attach()
+	if err != nil {
+		return nil, err
+	}
+	return &file{f}, nil
+}
+
+// AttachUser implements p9.UserAttacher.AttachUser.
+func (a *attacher) AttachUser(uname string, uid p9.UID) (p9.File, error) {
+	f, err := p9.AttachUser(a.Attacher, uname, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &file{f}, nil
+}
+
+// file is a read-only p9.File.
+type file struct {
+	p9.File
+}
+
+var (
+	_ p9.File = &file{}
+)
+
+// Walk implements p9.File.Walk.
+func (f *file) Walk(names []string) ([]p9.QID, p9.File, error) {
+	qids, nf, err := f.File.Walk(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return qids, &file{nf}, nil
+}
+
+// WalkGetAttr implements p9.File.WalkGetAttr.
+func (f *file) WalkGetAttr(names []string) ([]p9.QID, p9.File, p9.AttrMask, p9.Attr, error) {
+	qids, nf, mask, attr, err := f.File.WalkGetAttr(names)
+	if err != nil {
+		return nil, nil, p9.AttrMask{}, p9.Attr{}, err
+	}
+	return qids, &file{nf}, mask, attr, nil
+}
+
+// StatFS implements p9.File.StatFS.
+//
+// There is no read-only flag in 9P's statfs, so no space or inodes are
+// reported as free.
+func (f *file) StatFS() (p9.FSStat, error) {
+	stat, err := f.File.StatFS()
+	if err != nil {
+		return p9.FSStat{}, err
+	}
+	stat.BlocksFree = 0
+	stat.BlocksAvailable = 0
+	stat.FilesFree = 0
+	return stat, nil
+}
+
+// Open implements p9.File.Open.
+func (f *file) Open(mode p9.OpenFlags) (p9.QID, uint32, error) {
+	if mode.Mode() != p9.ReadOnly || mode&openTruncate != 0 {
+		return p9.QID{}, 0, linux.EROFS
+	}
+	return f.File.Open(mode)
+}
+
+// ReadTo implements p9.ReaderTo.ReadTo.
+func (f *file) ReadTo(w io.Writer, count uint32, offset int64) (p9.Payload, error) {
+	return p9.ReadTo(f.File, w, count, offset)
+}
+
+// GetLock implements p9.LockGetter.GetLock.
+func (f *file) GetLock(pid int, locktype p9.LockType, start, length uint64, client string) (p9.LockInfo, error) {
+	return p9.GetLock(f.File, pid, locktype, start, length, client)
+}
+
+// WriteAt implements p9.File.WriteAt.
+func (*file) WriteAt(p []byte, offset int64) (int, error) {
+	return 0, linux.EROFS
+}
+
+// SetAttr implements p9.File.SetAttr.
+func (*file) SetAttr(valid p9.SetAttrMask, attr p9.SetAttr) error {
+	return linux.EROFS
+}
+
+// SetXattr implements p9.File.SetXattr.
+func (*file) SetXattr(attr string, data []byte, flags p9.XattrFlags) error {
+	return linux.EROFS
+}
+
+// RemoveXattr implements p9.File.RemoveXattr.
+func (*file) RemoveXattr(attr string) error {
+	return linux.EROFS
+}
+
+// Create implements p9.File.Create.
+func (*file) Create(name string, flags p9.OpenFlags, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.File, p9.QID, uint32, error) {
+	return nil, p9.QID{}, 0, linux.EROFS
+}
+
+// Mkdir implements p9.File.Mkdir.
+func (*file) Mkdir(name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	return p9.QID{}, linux.EROFS
+}
+
+// Symlink implements p9.File.Symlink.
+func (*file) Symlink(oldName string, newName string, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	return p9.QID{}, linux.EROFS
+}
+
+// Link implements p9.File.Link.
+func (*file) Link(target p9.File, newName string) error {
+	return linux.EROFS
+}
+
+// Mknod implements p9.File.Mknod.
+func (*file) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	return p9.QID{}, linux.EROFS
+}
+
+// Rename implements p9.File.Rename.
+func (*file) Rename(newDir p9.File, newName string) error {
+	return linux.EROFS
+}
+
+// RenameAt implements p9.File.RenameAt.
+func (*file) RenameAt(oldName string, newDir p9.File, newName string) error {
+	return linux.EROFS
+}
+
+// UnlinkAt implements p9.File.UnlinkAt.
+func (*file) UnlinkAt(name string, flags uint32) error {
+	return linux.EROFS
+}
+
+// Renamed implements p9.File.Renamed.
+func (f *file) Renamed(newDir p9.File, newName string) {
+	if d, ok := newDir.(*file); ok {
+		newDir = d.File
+	}
+	f.File.Renamed(newDir, newName)
+}
@@ -0,0 +1,145 @@
+package readonly
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/localfs"
+	"github.com/hugelgupf/p9/fsimpl/templatefs"
+	"github.com/hugelgupf/p9/fsimpl/test"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	a := Attacher(localfs.Attacher(dir))
+	test.TestReadOnlyFS(t, a,
+		test.WithDir("", "dir", "file"),
+		test.WithDir("dir"),
+		test.WithFile("file", "content", p9.Attr{Size: 7}, p9.AttrMask{Size: true}),
+	)
+
+	root, err := a.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	_, f, err := root.Walk([]string{"file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, d, err := root.Walk([]string{"dir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, tt := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"create", func() error {
+			_, _, _, err := root.Create("new", p9.ReadWrite, 0666, p9.NoUID, p9.NoGID)
+			return err
+		}},
+		{"mkdir", func() error {
+			_, err := root.Mkdir("new", 0777, p9.NoUID, p9.NoGID)
+			return err
+		}},
+		{"symlink", func() error {
+			_, err := root.Symlink("file", "new", p9.NoUID, p9.NoGID)
+			return err
+		}},
+		{"link", func() error { return root.Link(f, "new") }},
+		{"mknod", func() error {
+			_, err := root.Mknod("new", p9.ModeNamedPipe|0666, 0, 0, p9.NoUID, p9.NoGID)
+			return err
+		}},
+		{"rename", func() error { return f.Rename(d, "new") }},
+		{"renameat", func() error { return root.RenameAt("file", d, "new") }},
+		{"unlinkat", func() error { return root.UnlinkAt("file", 0) }},
+		{"setattr", func() error { return f.SetAttr(p9.SetAttrMask{Size: true}, p9.SetAttr{}) }},
+		{"setxattr", func() error { return f.SetXattr("user.foo", []byte("bar"), 0) }},
+		{"removexattr", func() error { return f.RemoveXattr("user.foo") }},
+		{"open-writeonly", func() error {
+			_, _, err := f.Open(p9.WriteOnly)
+			return err
+		}},
+		{"open-readwrite", func() error {
+			_, _, err := f.Open(p9.ReadWrite)
+			return err
+		}},
+		{"open-truncate", func() error {
+			_, _, err := f.Open(p9.ReadOnly | openTruncate)
+			return err
+		}},
+		{"writeat", func() error {
+			_, err := f.WriteAt([]byte("foo"), 0)
+			return err
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); err != linux.EROFS {
+				t.Errorf("got %v, want EROFS", err)
+			}
+		})
+	}
+
+	// Nothing was modified.
+	test.TestReadOnlyFS(t, a,
+		test.WithDir("", "dir", "file"),
+		test.WithDir("dir"),
+		test.WithFile("file", "content", p9.Attr{Size: 7}, p9.AttrMask{Size: true}),
+	)
+}
+
+type statFile struct {
+	templatefs.NoopFile
+	p9.DefaultWalkGetAttr
+}
+
+func (statFile) StatFS() (p9.FSStat, error) {
+	return p9.FSStat{
+		BlockSize:       4096,
+		Blocks:          100,
+		BlocksFree:      50,
+		BlocksAvailable: 40,
+		Files:           10,
+		FilesFree:       5,
+	}, nil
+}
+
+type statAttacher struct{}
+
+func (statAttacher) Attach() (p9.File, error) {
+	return statFile{}, nil
+}
+
+func TestStatFS(t *testing.T) {
+	root, err := Attacher(statAttacher{}).Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := root.StatFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := p9.FSStat{
+		BlockSize: 4096,
+		Blocks:    100,
+		Files:     10,
+	}
+	if got != want {
+		t.Errorf("StatFS = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package benchmark_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/localfs"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// noWalkGetAttr hides a file's WalkGetAttr, so the server falls back to Walk
+// and GetAttr.
+type noWalkGetAttr struct {
+	p9.File
+}
+
+func (f noWalkGetAttr) Walk(names []string) ([]p9.QID, p9.File, error) {
+	qids, file, err := f.File.Walk(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return qids, noWalkGetAttr{file}, nil
+}
+
+func (noWalkGetAttr) WalkGetAttr([]string) ([]p9.QID, p9.File, p9.AttrMask, p9.Attr, error) {
+	return nil, nil, p9.AttrMask{}, p9.Attr{}, linux.ENOSYS
+}
+
+type attacherFunc func() (p9.File, error)
+
+func (a attacherFunc) Attach() (p9.File, error) {
+	return a()
+}
+
+// attach serves a over an in-process connection and attaches to it.
+func attach(b *testing.B, a p9.Attacher) p9.File {
+	srv, cli := net.Pipe()
+	s := p9.NewServer(a)
+	done := make(chan struct{})
+	go func() {
+		s.Handle(srv, srv)
+		close(done)
+	}()
+	b.Cleanup(func() {
+		cli.Close()
+		<-done
+	})
+
+	c, err := p9.NewClient(cli)
+	if err != nil {
+		b.Fatal(err)
+	}
+	root, err := c.Attach("")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { root.Close() })
+	return root
+}
+
+// BenchmarkLookup looks up a file five directories deep, as a client
+// resolving a path does.
+func BenchmarkLookup(b *testing.B) {
+	dir := b.TempDir()
+	names := []string{"a", "b", "c", "d", "e", "file"}
+	if err := os.MkdirAll(filepath.Join(append([]string{dir}, names[:len(names)-1]...)...), 0777); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(append([]string{dir}, names...)...), []byte("content"), 0666); err != nil {
+		b.Fatal(err)
+	}
+
+	native := localfs.Attacher(dir)
+	fallback := attacherFunc(func() (p9.File, error) {
+		root, err := native.Attach()
+		if err != nil {
+			return nil, err
+		}
+		return noWalkGetAttr{root}, nil
+	})
+
+	for _, bm := range []struct {
+		name     string
+		attacher p9.Attacher
+		lookup   func(root p9.File) (p9.File, error)
+	}{
+		{
+			name:     "Walk+GetAttr",
+			attacher: native,
+			lookup: func(root p9.File) (p9.File, error) {
+				_, f, err := root.Walk(names)
+				if err != nil {
+					return nil, err
+				}
+				_, _, _, err = f.GetAttr(p9.AttrMaskAll)
+				return f, err
+			},
+		},
+		{
+			name:     "WalkGetAttr-fallback",
+			attacher: fallback,
+			lookup: func(root p9.File) (p9.File, error) {
+				_, f, _, _, err := root.WalkGetAttr(names)
+				return f, err
+			},
+		},
+		{
+			name:     "WalkGetAttr",
+			attacher: native,
+			lookup: func(root p9.File) (p9.File, error) {
+				_, f, _, _, err := root.WalkGetAttr(names)
+				return f, err
+			},
+		},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			root := attach(b, bm.attacher)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := bm.lookup(root)
+				if err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
@@ -163,7 +163,8 @@ func testDirContents(t *testing.T, root p9.File, path string, d dir) {
 		if err != nil {
 			t.Fatalf("Could not walk to %s: %v", entry.Name, err)
 		}
-		if qids[0] != entry.QID {
+		// Readdir may not know the version.
+		if qids[0].Type != entry.QID.Type || qids[0].Path != entry.QID.Path {
 			t.Fatalf("For %s: Readdir QID is %v, Walk QID is %v, expected same", entry.Name, entry.QID, qids[0])
 		}
 	}
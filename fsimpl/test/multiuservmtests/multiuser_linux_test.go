@@ -0,0 +1,116 @@
+// Package multiuservmtests_test uses the Linux kernel client to mount a 9P
+// file system served in multi-user mode with access=user, and checks that the
+// host's permissions apply to each user.
+//
+// The host directory contains:
+//
+//	secret  owned by root, mode 0600
+//	public  owned by root, mode 0644
+//	shared  owned by root, mode 0777
+package multiuservmtests_test
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/hugelgupf/vmtest/guest"
+	"github.com/u-root/u-root/pkg/mount"
+	"github.com/u-root/u-root/pkg/sh"
+	"golang.org/x/sys/unix"
+)
+
+// testUID is the user the tests run as. It creates files in the group given
+// by the host in P9_GID, as the host does not let it use other groups.
+const testUID = 1000
+
+func TestMain(m *testing.M) {
+	if os.Getuid() == 0 {
+		if err := sh.RunWithLogs("dhclient", "-ipv6=false"); err != nil {
+			log.Fatalf("could not configure network for tests: %v", err)
+		}
+	}
+
+	os.Exit(m.Run())
+}
+
+// asUser runs fn with the file system credentials of the given user, which
+// v9fs attaches as with access=user.
+func asUser(t *testing.T, uid, gid int, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The thread is never unlocked, so that it exits with the
+		// goroutine instead of running others with these credentials.
+		runtime.LockOSThread()
+		if err := unix.Setgroups(nil); err != nil {
+			t.Errorf("setgroups: %v", err)
+			return
+		}
+		unix.SetfsgidRetGid(gid)
+		unix.SetfsuidRetUid(uid)
+		fn()
+	}()
+	<-done
+}
+
+func TestMultiUser(t *testing.T) {
+	guest.SkipIfNotInVM(t)
+
+	targetDir := "/target"
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetDir)
+
+	mp, err := mount.Mount(os.Getenv("P9_TARGET"), targetDir, "9p", fmt.Sprintf("trans=tcp,msize=4096,port=%s,access=user", os.Getenv("P9_PORT")), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mp.Unmount(0)
+
+	testGID, err := strconv.Atoi(os.Getenv("P9_GID"))
+	if err != nil {
+		t.Fatalf("P9_GID: %v", err)
+	}
+
+	secret := filepath.Join(targetDir, "secret")
+	public := filepath.Join(targetDir, "public")
+
+	// Root may read everything.
+	if _, err := os.ReadFile(secret); err != nil {
+		t.Errorf("reading secret as root: %v", err)
+	}
+
+	asUser(t, testUID, testGID, func() {
+		if _, err := os.ReadFile(secret); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("reading secret as %d = %v, want permission denied", testUID, err)
+		}
+		if _, err := os.ReadFile(public); err != nil {
+			t.Errorf("reading public as %d: %v", testUID, err)
+		}
+		if err := os.WriteFile(public, []byte("overwritten"), 0); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("writing public as %d = %v, want permission denied", testUID, err)
+		}
+
+		mine := filepath.Join(targetDir, "shared", "mine")
+		if err := os.WriteFile(mine, []byte("mine"), 0600); err != nil {
+			t.Errorf("creating %s as %d: %v", mine, testUID, err)
+			return
+		}
+		fi, err := os.Stat(mine)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if st := fi.Sys().(*syscall.Stat_t); st.Uid != testUID || st.Gid != uint32(testGID) {
+			t.Errorf("owner of %s = %d:%d, want %d:%d", mine, st.Uid, st.Gid, testUID, testGID)
+		}
+	})
+}
@@ -14,7 +14,9 @@ import (
 	"reflect"
 	"sort"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/hugelgupf/p9/fsimpl/localfs"
 	"github.com/hugelgupf/p9/fsimpl/xattr"
@@ -191,6 +193,137 @@ func TestMountHostDirectory(t *testing.T) {
 		}
 	})
 
+	t.Run("setattr-chmod", func(t *testing.T) {
+		p := filepath.Join(targetDir, "chmod")
+		if err := ioutil.WriteFile(p, []byte("somecontent"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, 0600|os.ModeSetgid); err != nil {
+			t.Fatalf("Chmod = %v", err)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fi.Mode(), 0600|os.ModeSetgid; got != want {
+			t.Errorf("mode = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("setattr-chown", func(t *testing.T) {
+		p := filepath.Join(targetDir, "chown")
+		if err := ioutil.WriteFile(p, []byte("somecontent"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(p, 1234, 5678); err != nil {
+			t.Fatalf("Chown = %v", err)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != 1234 || st.Gid != 5678 {
+			t.Errorf("owner = %d:%d, want 1234:5678", st.Uid, st.Gid)
+		}
+	})
+
+	t.Run("setattr-utimes", func(t *testing.T) {
+		p := filepath.Join(targetDir, "utimes")
+		if err := ioutil.WriteFile(p, []byte("somecontent"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		atime := time.Unix(1000, 1)
+		mtime := time.Unix(2000, 2)
+		if err := os.Chtimes(p, atime, mtime); err != nil {
+			t.Fatalf("Chtimes = %v", err)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if got := time.Unix(st.Atim.Unix()); !got.Equal(atime) {
+			t.Errorf("atime = %v, want %v", got, atime)
+		}
+		if got := fi.ModTime(); !got.Equal(mtime) {
+			t.Errorf("mtime = %v, want %v", got, mtime)
+		}
+	})
+
+	t.Run("setattr-utimes-now", func(t *testing.T) {
+		p := filepath.Join(targetDir, "utimesnow")
+		if err := ioutil.WriteFile(p, []byte("somecontent"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, time.Unix(1000, 0), time.Unix(1000, 0)); err != nil {
+			t.Fatalf("Chtimes = %v", err)
+		}
+		// Omit the access time and set the modification time to now.
+		ts := []unix.Timespec{
+			{Nsec: unix.UTIME_OMIT},
+			{Nsec: unix.UTIME_NOW},
+		}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, p, ts, 0); err != nil {
+			t.Fatalf("UtimesNanoAt = %v", err)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Atim.Sec != 1000 {
+			t.Errorf("atime = %d, want 1000", st.Atim.Sec)
+		}
+		if st.Mtim.Sec == 1000 {
+			t.Errorf("mtime was not set to the current time")
+		}
+	})
+
+	t.Run("setattr-truncate", func(t *testing.T) {
+		p := filepath.Join(targetDir, "truncate")
+		if err := ioutil.WriteFile(p, []byte("somecontent"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(p, 4); err != nil {
+			t.Fatalf("Truncate = %v", err)
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(content), "some"; got != want {
+			t.Errorf("content = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("setattr-ftruncate", func(t *testing.T) {
+		p := filepath.Join(targetDir, "ftruncate")
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.Write([]byte("somecontent")); err != nil {
+			t.Fatal(err)
+		}
+		// The open file can be truncated even without write
+		// permission.
+		if err := os.Chmod(p, 0444); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Truncate(4); err != nil {
+			t.Fatalf("Truncate = %v", err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != 4 {
+			t.Errorf("size = %d, want 4", fi.Size())
+		}
+	})
+
 	if err := mp.Unmount(0); err != nil {
 		t.Fatal(err)
 	}
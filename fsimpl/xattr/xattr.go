@@ -14,12 +14,18 @@ func List(p string) ([]string, error) {
 	if err != nil {
 		return nil, &fs.PathError{Op: "listxattr-get-size", Path: p, Err: err}
 	}
+	if sz == 0 {
+		return nil, nil
+	}
 
 	b := make([]byte, sz)
 	sz, err = unix.Listxattr(p, b)
 	if err != nil {
 		return nil, &fs.PathError{Op: "listxattr", Path: p, Err: err}
 	}
+	if sz == 0 {
+		return nil, nil
+	}
 
 	return strings.Split(strings.Trim(string(b[:sz]), "\000"), "\000"), nil
 }
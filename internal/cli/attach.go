@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/hugelgupf/p9/p9"
+	"github.com/u-root/uio/ulog"
+)
+
+// Flags are the flags of the clients to connect and attach to a server.
+type Flags struct {
+	unix    *bool
+	aname   *string
+	uname   *string
+	uid     *int
+	msize   *uint
+	verbose *bool
+}
+
+// AddFlags defines the flags to connect and attach in fs.
+func AddFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		unix:    fs.Bool("unix", false, "connect to a unix domain socket instead of TCP"),
+		aname:   fs.String("aname", "", "attach name, selecting the export or directory to attach"),
+		uname:   fs.String("uname", "", "user name to attach as"),
+		uid:     fs.Int("uid", -1, "user ID to attach as"),
+		msize:   fs.Uint("msize", 0, "maximum message size, if not the default"),
+		verbose: fs.Bool("v", false, "log 9P messages"),
+	}
+}
+
+// Attach connects to the server at addr and attaches to it as given by the
+// flags. The client must be closed by the caller.
+func (f *Flags) Attach(addr string) (*p9.Client, p9.File, error) {
+	network := "tcp"
+	if *f.unix {
+		network = "unix"
+	}
+	var opts []p9.ClientOpt
+	if *f.msize != 0 {
+		opts = append(opts, p9.WithMessageSize(uint32(*f.msize)))
+	}
+	if *f.verbose {
+		opts = append(opts, p9.WithClientLogger(ulog.Log))
+	}
+	c, err := p9.Dial(network, addr, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uid := p9.NoUID
+	if *f.uid >= 0 {
+		uid = p9.UID(*f.uid)
+	}
+	root, err := c.AttachUser(*f.aname, *f.uname, uid)
+	if err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("attach %q: %w", *f.aname, err)
+	}
+	return c, root, nil
+}
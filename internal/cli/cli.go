@@ -0,0 +1,142 @@
+// Package cli implements the commands of the p9 command-line clients, p9 and
+// p9sh.
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// Env is the environment commands run in.
+type Env struct {
+	// Root is the attached root.
+	Root p9.File
+
+	// Dir is the current directory, whose path is Cwd. Relative paths
+	// are walked from Dir. If Dir is nil, the current directory is Root.
+	Dir p9.File
+	Cwd string
+
+	// Stdout is where commands write their output.
+	Stdout io.Writer
+}
+
+// Abs returns the absolute path of p.
+func (e *Env) Abs(p string) string {
+	if path.IsAbs(p) || e.Cwd == "" {
+		return path.Clean("/" + p)
+	}
+	return path.Join(e.Cwd, p)
+}
+
+// names returns the file to walk from to reach p, and the names to walk.
+func (e *Env) names(p string) (p9.File, []string) {
+	abs := e.Abs(p)
+	if e.Dir != nil && e.Cwd != "/" {
+		if abs == e.Cwd {
+			return e.Dir, nil
+		}
+		if rel, ok := strings.CutPrefix(abs, e.Cwd+"/"); ok {
+			return e.Dir, strings.Split(rel, "/")
+		}
+	}
+	if abs == "/" {
+		return e.Root, nil
+	}
+	return e.Root, strings.Split(abs[1:], "/")
+}
+
+// Walk returns a new file for p.
+func (e *Env) Walk(p string) (p9.File, error) {
+	from, names := e.names(p)
+	_, f, err := from.Walk(names)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return f, nil
+}
+
+// WalkParent returns a new file for the directory containing p, and the
+// name of p in it.
+func (e *Env) WalkParent(p string) (p9.File, string, error) {
+	abs := e.Abs(p)
+	if abs == "/" {
+		return nil, "", fmt.Errorf("%s: %w", p, linux.EINVAL)
+	}
+	dir, name := path.Split(abs)
+	from, names := e.names(dir)
+	_, f, err := from.Walk(names)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", p, err)
+	}
+	return f, name, nil
+}
+
+// ReadDir returns the entries of the directory p, sorted by name, without
+// "." and "..".
+func (e *Env) ReadDir(p string) (p9.Dirents, error) {
+	f, err := e.Walk(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := openDir(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	defer d.Close()
+	entries, err := readdir(d)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return entries, nil
+}
+
+// Command is a command of the clients.
+type Command struct {
+	// Usage describes the arguments of the command.
+	Usage string
+
+	// Run runs the command with the arguments following its name. It
+	// returns ErrUsage if the arguments are invalid.
+	Run func(e *Env, args []string) error
+}
+
+// ErrUsage is returned by commands given invalid arguments.
+var ErrUsage = errors.New("invalid arguments")
+
+// Commands are the commands of the clients, by name.
+var Commands = map[string]Command{
+	"ls":       {"[-l] [path...]", ls},
+	"stat":     {"path...", stat},
+	"cat":      {"path...", cat},
+	"get":      {"remote [local]", get},
+	"put":      {"local [remote]", put},
+	"mkdir":    {"[-m mode] path...", mkdir},
+	"rm":       {"[-r] path...", rm},
+	"mv":       {"old new", mv},
+	"ln":       {"[-s] target path", ln},
+	"readlink": {"path...", readlink},
+	"chmod":    {"mode path...", chmod},
+	"getfattr": {"[-n name] path...", getfattr},
+	"setfattr": {"-n name -v value path... | -x name path...", setfattr},
+	"df":       {"[path]", df},
+	"lock":     {"[-r] [-w] path", lock},
+}
+
+// ErrString returns err with the name of its error number, if any.
+func ErrString(err error) string {
+	var errno linux.Errno
+	if errors.As(err, &errno) {
+		if name := linux.ErrnoName(errno); name != "" {
+			return fmt.Sprintf("%v (%s)", err, name)
+		}
+	}
+	return err.Error()
+}
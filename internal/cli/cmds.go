@@ -0,0 +1,723 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+const (
+	// bufSize is the size of the buffer used to copy files.
+	bufSize = 1 << 20
+
+	// unlinkRemoveDir is AT_REMOVEDIR, as passed to UnlinkAt.
+	unlinkRemoveDir = 0x200
+)
+
+// parse parses the flags of a command, returning ErrUsage on failure.
+func parse(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return ErrUsage
+	}
+	return nil
+}
+
+// readdir returns the entries of dir, which must be open, except "." and
+// "..", sorted by name.
+func readdir(dir p9.File) (p9.Dirents, error) {
+	var (
+		entries p9.Dirents
+		offset  uint64
+	)
+	for {
+		d, err := dir.Readdir(offset, 64<<10)
+		if err != nil {
+			return nil, err
+		}
+		if len(d) == 0 {
+			break
+		}
+		for _, e := range d {
+			if e.Name != "." && e.Name != ".." {
+				entries = append(entries, e)
+			}
+		}
+		offset = d[len(d)-1].Offset
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// openDir opens a new file for dir to read its entries.
+func openDir(dir p9.File) (p9.File, error) {
+	_, d, err := dir.Walk(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := d.Open(p9.ReadOnly); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// timestamp returns the time of seconds and nanoseconds since the epoch.
+func timestamp(sec, nsec uint64) time.Time {
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+// longEntry prints a line of "ls -l" for f with the given name.
+func longEntry(w io.Writer, f p9.File, attr p9.Attr, name string) error {
+	if attr.Mode.IsSymlink() {
+		target, err := f.Readlink()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		name += " -> " + target
+	}
+	mtime := timestamp(attr.MTimeSeconds, attr.MTimeNanoSeconds).Format("Jan _2 15:04")
+	fmt.Fprintf(w, "%v\t %d\t %d\t %d\t %d\t %s %s\n", attr.Mode.OSMode(), attr.NLink, attr.UID, attr.GID, attr.Size, mtime, name)
+	return nil
+}
+
+func ls(e *Env, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	long := fs.Bool("l", false, "long listing")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	w := tabwriter.NewWriter(e.Stdout, 0, 0, 0, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+	for i, p := range paths {
+		var header string
+		if len(paths) > 1 {
+			header = p + ":\n"
+			if i > 0 {
+				header = "\n" + header
+			}
+		}
+		if err := lsPath(e, w, p, *long, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lsPath lists the file at p, or its entries if it is a directory, preceded
+// by header.
+func lsPath(e *Env, w io.Writer, p string, long bool, header string) error {
+	f, err := e.Walk(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, _, attr, err := f.GetAttr(p9.AttrMaskAll)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	if !attr.Mode.IsDir() {
+		if long {
+			return longEntry(w, f, attr, p)
+		}
+		fmt.Fprintln(w, p)
+		return nil
+	}
+
+	d, err := openDir(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	entries, err := readdir(d)
+	d.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	fmt.Fprint(w, header)
+	for _, e := range entries {
+		if !long {
+			fmt.Fprintln(w, e.Name)
+			continue
+		}
+		_, ef, _, attr, err := f.WalkGetAttr([]string{e.Name})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path.Join(p, e.Name), err)
+		}
+		err = longEntry(w, ef, attr, e.Name)
+		ef.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stat(e *Env, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	for _, p := range args {
+		f, err := e.Walk(p)
+		if err != nil {
+			return err
+		}
+		qid, valid, attr, err := f.GetAttr(p9.AttrMaskAll)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+
+		fmt.Fprintf(e.Stdout, "  File: %s\n", p)
+		fmt.Fprintf(e.Stdout, "  Size: %-10d Blocks: %-10d IO Block: %-6d %s\n", attr.Size, attr.Blocks, attr.BlockSize, qid.Type)
+		fmt.Fprintf(e.Stdout, "  Mode: (%04o/%v)  Uid: %d  Gid: %d\n", uint32(attr.Mode.Permissions()), attr.Mode.OSMode(), attr.UID, attr.GID)
+		fmt.Fprintf(e.Stdout, " Links: %-10d QID: %d version %d\n", attr.NLink, qid.Path, qid.Version)
+		for _, t := range []struct {
+			name      string
+			valid     bool
+			sec, nsec uint64
+		}{
+			{"Access", valid.ATime, attr.ATimeSeconds, attr.ATimeNanoSeconds},
+			{"Modify", valid.MTime, attr.MTimeSeconds, attr.MTimeNanoSeconds},
+			{"Change", valid.CTime, attr.CTimeSeconds, attr.CTimeNanoSeconds},
+			{" Birth", valid.BTime, attr.BTimeSeconds, attr.BTimeNanoSeconds},
+		} {
+			if t.valid && (t.sec != 0 || t.nsec != 0) {
+				fmt.Fprintf(e.Stdout, "%s: %s\n", t.name, timestamp(t.sec, t.nsec).Format("2006-01-02 15:04:05.000000000 -0700"))
+			}
+		}
+	}
+	return nil
+}
+
+// openRead returns a new file for p, open for reading.
+func openRead(e *Env, p string) (p9.File, error) {
+	f, err := e.Walk(p)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := f.Open(p9.ReadOnly); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return f, nil
+}
+
+// copyFile copies the contents of f, the open file at p, to w.
+func copyFile(w io.Writer, f p9.File, p string) error {
+	if _, err := io.CopyBuffer(w, io.NewSectionReader(f, 0, math.MaxInt64), make([]byte, bufSize)); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
+
+// copyFrom copies the contents of the file at p to w.
+func copyFrom(w io.Writer, e *Env, p string) error {
+	f, err := openRead(e, p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return copyFile(w, f, p)
+}
+
+func cat(e *Env, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	for _, p := range args {
+		if err := copyFrom(e.Stdout, e, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func get(e *Env, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return ErrUsage
+	}
+	remote, local := args[0], path.Base(args[0])
+	if len(args) == 2 {
+		local = args[1]
+	}
+
+	f, err := openRead(e, remote)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Write to a temporary file that replaces local once complete, so
+	// that local is left alone if the copy fails. The file keeps the
+	// permissions of the file it replaces.
+	perm := os.FileMode(0o644)
+	if info, err := os.Stat(local); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(local), "."+filepath.Base(local)+".*")
+	if err != nil {
+		return err
+	}
+	err = copyFile(tmp, f, remote)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), local)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func put(e *Env, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return ErrUsage
+	}
+	local, remote := args[0], path.Base(args[0])
+	if len(args) == 2 {
+		remote = args[1]
+	}
+
+	lf, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+	info, err := lf.Stat()
+	if err != nil {
+		return err
+	}
+
+	dir, name, err := e.WalkParent(remote)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	f, err := openWrite(dir, name, p9.ModeFromOS(info.Mode()).Permissions())
+	if err != nil {
+		return fmt.Errorf("%s: %w", remote, err)
+	}
+	defer f.Close()
+	if _, err := io.CopyBuffer(io.NewOffsetWriter(f, 0), lf, make([]byte, bufSize)); err != nil {
+		return fmt.Errorf("%s: %w", remote, err)
+	}
+	return nil
+}
+
+// openWrite returns a new file for name in dir, open for writing. The file
+// is truncated if it exists, and created with perm otherwise.
+func openWrite(dir p9.File, name string, perm p9.FileMode) (p9.File, error) {
+	_, f, err := dir.Walk([]string{name})
+	if err == nil {
+		err = f.SetAttr(p9.SetAttrMask{Size: true}, p9.SetAttr{Size: 0})
+		if err == nil {
+			_, _, err = f.Open(p9.WriteOnly)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+	if linux.ExtractErrno(err) != linux.ENOENT {
+		return nil, err
+	}
+
+	// Create turns the file it is called on into the new file, so call
+	// it on a new file for dir.
+	if _, f, err = dir.Walk(nil); err != nil {
+		return nil, err
+	}
+	nf, _, _, err := f.Create(name, p9.WriteOnly, perm, p9.NoUID, p9.NoGID)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return nf, nil
+}
+
+func mkdir(e *Env, args []string) error {
+	fs := flag.NewFlagSet("mkdir", flag.ContinueOnError)
+	mode := fs.String("m", "755", "permissions, in octal")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	perm, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil || fs.NArg() == 0 {
+		return ErrUsage
+	}
+
+	for _, p := range fs.Args() {
+		dir, name, err := e.WalkParent(p)
+		if err != nil {
+			return err
+		}
+		_, err = dir.Mkdir(name, p9.FileMode(perm), p9.NoUID, p9.NoGID)
+		dir.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// removeAll removes name in dir, and everything in it if it is a directory.
+func removeAll(dir p9.File, name string) error {
+	_, f, _, attr, err := dir.WalkGetAttr([]string{name})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if !attr.Mode.IsDir() {
+		return dir.UnlinkAt(name, 0)
+	}
+
+	d, err := openDir(f)
+	if err != nil {
+		return err
+	}
+	entries, err := readdir(d)
+	d.Close()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := removeAll(f, e.Name); err != nil {
+			return fmt.Errorf("%s: %w", e.Name, err)
+		}
+	}
+	return dir.UnlinkAt(name, unlinkRemoveDir)
+}
+
+func rm(e *Env, args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "remove directories and their contents")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return ErrUsage
+	}
+
+	for _, p := range fs.Args() {
+		dir, name, err := e.WalkParent(p)
+		if err != nil {
+			return err
+		}
+		if *recursive {
+			err = removeAll(dir, name)
+		} else {
+			err = dir.UnlinkAt(name, 0)
+		}
+		dir.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func mv(e *Env, args []string) error {
+	if len(args) != 2 {
+		return ErrUsage
+	}
+	oldDir, oldName, err := e.WalkParent(args[0])
+	if err != nil {
+		return err
+	}
+	defer oldDir.Close()
+	newDir, newName, err := e.WalkParent(args[1])
+	if err != nil {
+		return err
+	}
+	defer newDir.Close()
+
+	if err := oldDir.RenameAt(oldName, newDir, newName); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
+
+func ln(e *Env, args []string) error {
+	fs := flag.NewFlagSet("ln", flag.ContinueOnError)
+	symbolic := fs.Bool("s", false, "make a symbolic link")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return ErrUsage
+	}
+	target, p := fs.Arg(0), fs.Arg(1)
+
+	dir, name, err := e.WalkParent(p)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if *symbolic {
+		_, err = dir.Symlink(target, name, p9.NoUID, p9.NoGID)
+	} else {
+		var f p9.File
+		if f, err = e.Walk(target); err != nil {
+			return err
+		}
+		err = dir.Link(f, name)
+		f.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
+
+func readlink(e *Env, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	for _, p := range args {
+		f, err := e.Walk(p)
+		if err != nil {
+			return err
+		}
+		target, err := f.Readlink()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		fmt.Fprintln(e.Stdout, target)
+	}
+	return nil
+}
+
+func chmod(e *Env, args []string) error {
+	if len(args) < 2 {
+		return ErrUsage
+	}
+	perm, err := strconv.ParseUint(args[0], 8, 32)
+	if err != nil || perm > 07777 {
+		return ErrUsage
+	}
+
+	for _, p := range args[1:] {
+		f, err := e.Walk(p)
+		if err != nil {
+			return err
+		}
+		err = f.SetAttr(p9.SetAttrMask{Permissions: true}, p9.SetAttr{Permissions: p9.FileMode(perm)})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func getfattr(e *Env, args []string) error {
+	fs := flag.NewFlagSet("getfattr", flag.ContinueOnError)
+	attr := fs.String("n", "", "print only this attribute")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return ErrUsage
+	}
+
+	for i, p := range fs.Args() {
+		if i > 0 {
+			fmt.Fprintln(e.Stdout)
+		}
+		if err := getfattrPath(e, p, *attr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getfattrPath prints the extended attributes of the file at p, or only attr
+// if it is not empty.
+func getfattrPath(e *Env, p string, attr string) error {
+	f, err := e.Walk(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	names := []string{attr}
+	if attr == "" {
+		if names, err = f.ListXattrs(); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		sort.Strings(names)
+	}
+	fmt.Fprintf(e.Stdout, "# file: %s\n", p)
+	for _, name := range names {
+		value, err := f.GetXattr(name)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", p, name, err)
+		}
+		fmt.Fprintf(e.Stdout, "%s=%q\n", name, value)
+	}
+	return nil
+}
+
+func setfattr(e *Env, args []string) error {
+	fs := flag.NewFlagSet("setfattr", flag.ContinueOnError)
+	name := fs.String("n", "", "attribute to set")
+	value := fs.String("v", "", "value to set")
+	remove := fs.String("x", "", "attribute to remove")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || (*name == "") == (*remove == "") {
+		return ErrUsage
+	}
+
+	for _, p := range fs.Args() {
+		f, err := e.Walk(p)
+		if err != nil {
+			return err
+		}
+		if *remove != "" {
+			err = f.RemoveXattr(*remove)
+		} else {
+			err = f.SetXattr(*name, []byte(*value), 0)
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func df(e *Env, args []string) error {
+	if len(args) > 1 {
+		return ErrUsage
+	}
+	p := "."
+	if len(args) == 1 {
+		p = args[0]
+	}
+	f, err := e.Walk(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.StatFS()
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	kb := func(blocks uint64) uint64 {
+		return blocks * uint64(st.BlockSize) / 1024
+	}
+	used := st.Blocks - st.BlocksFree
+	var usePct uint64
+	if n := used + st.BlocksAvailable; n > 0 {
+		usePct = (used*100 + n - 1) / n
+	}
+	w := tabwriter.NewWriter(e.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "1K-blocks\tUsed\tAvailable\tUse%%\tInodes\tIFree\t\n")
+	fmt.Fprintf(w, "%d\t%d\t%d\t%d%%\t%d\t%d\t\n", kb(st.Blocks), kb(used), kb(st.BlocksAvailable), usePct, st.Files, st.FilesFree)
+	return w.Flush()
+}
+
+func lock(e *Env, args []string) error {
+	fs := flag.NewFlagSet("lock", flag.ContinueOnError)
+	read := fs.Bool("r", false, "take a read lock instead of a write lock")
+	wait := fs.Bool("w", false, "wait for the lock instead of failing if it is held")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return ErrUsage
+	}
+	p := fs.Arg(0)
+
+	locktype, mode := p9.WriteLock, p9.ReadWrite
+	if *read {
+		locktype, mode = p9.ReadLock, p9.ReadOnly
+	}
+	f, err := e.Walk(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, _, err := f.Open(mode); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	pid := os.Getpid()
+	client, _ := os.Hostname()
+	for {
+		status, err := f.Lock(pid, locktype, p9.LockFlagsBlock, 0, 0, client)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if status == p9.LockStatusOK {
+			break
+		}
+		if status != p9.LockStatusBlocked {
+			return fmt.Errorf("%s: %v", p, status)
+		}
+		if !*wait {
+			info, err := p9.GetLock(f, pid, locktype, 0, 0, client)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			holder := fmt.Sprintf("%v held", info.Type)
+			if info.PID > 0 {
+				holder += fmt.Sprintf(" by pid %d", info.PID)
+			}
+			if info.Client != "" {
+				holder += " on " + info.Client
+			}
+			return fmt.Errorf("%s: %s: %w", p, holder, linux.EAGAIN)
+		}
+
+		// Servers do not wait for locks, so retry until the lock is
+		// released.
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", p, linux.EINTR)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	fmt.Fprintf(e.Stdout, "%s: locked, interrupt to unlock\n", p)
+	<-ctx.Done()
+	if _, err := f.Lock(pid, p9.Unlock, 0, 0, 0, client); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+// Package rawmsg lets the p9 command-line clients send raw 9P messages for
+// debugging servers, without making it part of the API of package p9.
+package rawmsg
+
+// Token is required by the raw message functions of package p9,
+// Client.SendRaw and RawMessages. As this package is internal, they cannot
+// be called outside this module.
+type Token struct {
+	_ struct{}
+}
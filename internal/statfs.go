@@ -0,0 +1,34 @@
+package internal
+
+// StatFS is a 9P2000.L compatible statfs result.
+type StatFS struct {
+	Type            uint32
+	BlockSize       uint32
+	Blocks          uint64
+	BlocksFree      uint64
+	BlocksAvailable uint64
+	Files           uint64
+	FilesFree       uint64
+	FSID            uint64
+	NameLength      uint32
+}
+
+// v9fsMagic is the file system type reported where the host's file system
+// types are not Linux magic numbers.
+const v9fsMagic = 0x01021997
+
+// defaultNameLength is reported where the host does not report the maximum
+// name length.
+const defaultNameLength = 255
+
+func fsid(val [2]int32) uint64 {
+	return uint64(uint32(val[0])) | uint64(uint32(val[1]))<<32
+}
+
+// nonNegative converts counts that some platforms report as signed.
+func nonNegative(n int64) uint64 {
+	if n < 0 {
+		return 0
+	}
+	return uint64(n)
+}
@@ -0,0 +1,26 @@
+//go:build darwin || dragonfly
+
+package internal
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Statfs returns statistics of the file system containing path.
+func Statfs(path string) (*StatFS, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	return &StatFS{
+		Type:            v9fsMagic,
+		BlockSize:       uint32(st.Bsize),
+		Blocks:          uint64(st.Blocks),
+		BlocksFree:      uint64(st.Bfree),
+		BlocksAvailable: nonNegative(int64(st.Bavail)),
+		Files:           uint64(st.Files),
+		FilesFree:       nonNegative(int64(st.Ffree)),
+		FSID:            fsid(st.Fsid.Val),
+		NameLength:      defaultNameLength,
+	}, nil
+}
@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Statfs returns statistics of the file system containing path.
+func Statfs(path string) (*StatFS, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	return &StatFS{
+		Type:            uint32(st.Type),
+		BlockSize:       uint32(st.Bsize),
+		Blocks:          st.Blocks,
+		BlocksFree:      st.Bfree,
+		BlocksAvailable: st.Bavail,
+		Files:           st.Files,
+		FilesFree:       st.Ffree,
+		FSID:            fsid(st.Fsid.Val),
+		NameLength:      uint32(st.Namelen),
+	}, nil
+}
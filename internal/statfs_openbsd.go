@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Statfs returns statistics of the file system containing path.
+func Statfs(path string) (*StatFS, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	return &StatFS{
+		Type:            v9fsMagic,
+		BlockSize:       st.F_bsize,
+		Blocks:          st.F_blocks,
+		BlocksFree:      st.F_bfree,
+		BlocksAvailable: nonNegative(st.F_bavail),
+		Files:           st.F_files,
+		FilesFree:       st.F_ffree,
+		FSID:            fsid(st.F_fsid.Val),
+		NameLength:      st.F_namemax,
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !openbsd && !netbsd && !solaris
+
+package internal
+
+import (
+	"github.com/hugelgupf/p9/linux"
+)
+
+// Statfs returns statistics of the file system containing path.
+//
+// It is not supported on this platform.
+func Statfs(path string) (*StatFS, error) {
+	return nil, linux.ENOSYS
+}
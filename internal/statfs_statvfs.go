@@ -0,0 +1,27 @@
+//go:build netbsd || solaris
+
+package internal
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Statfs returns statistics of the file system containing path.
+func Statfs(path string) (*StatFS, error) {
+	var st unix.Statvfs_t
+	if err := unix.Statvfs(path, &st); err != nil {
+		return nil, err
+	}
+	// Block counts are in units of the fragment size.
+	return &StatFS{
+		Type:            v9fsMagic,
+		BlockSize:       uint32(st.Frsize),
+		Blocks:          st.Blocks,
+		BlocksFree:      st.Bfree,
+		BlocksAvailable: st.Bavail,
+		Files:           st.Files,
+		FilesFree:       st.Ffree,
+		FSID:            uint64(st.Fsid),
+		NameLength:      uint32(st.Namemax),
+	}, nil
+}
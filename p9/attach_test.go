@@ -0,0 +1,79 @@
+package p9_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/templatefs"
+	"github.com/hugelgupf/p9/p9"
+)
+
+type dirFile struct {
+	templatefs.NoopFile
+}
+
+func (dirFile) GetAttr(p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	return p9.QID{Type: p9.TypeDir, Path: 1}, p9.AttrMask{Mode: true}, p9.Attr{Mode: p9.ModeDirectory | 0o755}, nil
+}
+
+type user struct {
+	name string
+	uid  p9.UID
+}
+
+// userAttacher records the users attaching.
+type userAttacher struct {
+	users chan user
+}
+
+func (userAttacher) Attach() (p9.File, error) {
+	return dirFile{}, nil
+}
+
+func (a userAttacher) AttachUser(uname string, uid p9.UID) (p9.File, error) {
+	a.users <- user{uname, uid}
+	return dirFile{}, nil
+}
+
+func TestAttachUser(t *testing.T) {
+	srv, cli := net.Pipe()
+	a := userAttacher{users: make(chan user, 1)}
+	s := p9.NewServer(a)
+	done := make(chan struct{})
+	go func() {
+		_ = s.Handle(srv, srv)
+		close(done)
+	}()
+	defer func() {
+		cli.Close()
+		srv.Close()
+		<-done
+	}()
+
+	c, err := p9.NewClient(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []user{
+		{"", p9.NoUID},
+		{"alice", 1000},
+		{"bob", p9.NoUID},
+	} {
+		var (
+			f   p9.File
+			err error
+		)
+		if want == (user{"", p9.NoUID}) {
+			f, err = c.Attach("")
+		} else {
+			f, err = c.AttachUser("", want.name, want.uid)
+		}
+		if err != nil {
+			t.Fatalf("Attach(%v) = %v", want, err)
+		}
+		f.Close()
+		if got := <-a.users; got != want {
+			t.Errorf("AttachUser got user %v, want %v", got, want)
+		}
+	}
+}
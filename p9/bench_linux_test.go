@@ -3,8 +3,10 @@
 package p9
 
 import (
+	"io"
 	"testing"
 
+	"github.com/hugelgupf/p9/shm"
 	"github.com/hugelgupf/socketpair"
 	"github.com/u-root/uio/ulog/ulogtest"
 )
@@ -16,7 +18,24 @@ func BenchmarkSendRecvTCP(b *testing.B) {
 	}
 	defer server.Close()
 	defer client.Close()
+	benchmarkSendRecv(b, server, client)
+}
+
+func BenchmarkSendRecvUnix(b *testing.B) {
+	server, client := unixPair(b)
+	defer server.Close()
+	defer client.Close()
+	benchmarkSendRecv(b, server, client)
+}
+
+func BenchmarkSendRecvSharedMemory(b *testing.B) {
+	server, client := channelPair(b, shm.DefaultSize)
+	defer server.Close()
+	defer client.Close()
+	benchmarkSendRecv(b, server, client)
+}
 
+func benchmarkSendRecv(b *testing.B, server, client io.ReadWriter) {
 	l := ulogtest.Logger{TB: b}
 	// Exchange Rflush messages since these contain no data and therefore incur
 	// no additional marshaling overhead.
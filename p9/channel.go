@@ -0,0 +1,89 @@
+package p9
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/shm"
+)
+
+// maxChannelSize is the largest ring size given to clients.
+const maxChannelSize = 16 << 20
+
+// handleChannel handles a Tchannel, moving the connection to shared memory
+// once the Rchannel is sent.
+//
+// It must be called with recvMu held, and t must not be counted in inflight.
+func (cs *connState) handleChannel(tag tag, t *tchannel) {
+	r, conn := cs.newChannel(t)
+
+	cs.sendMu.Lock()
+	defer cs.sendMu.Unlock()
+	if err := send(cs.server.log, cs.r, tag, r); err != nil {
+		cs.server.log.Printf("p9.send: %v", err)
+		if conn != nil {
+			conn.Close()
+		}
+		return
+	}
+	if conn != nil {
+		cs.t, cs.r = conn, conn
+		atomic.StoreUint32(&cs.channel, 1)
+	}
+}
+
+// newChannel returns the response to t, and the shared memory connection to
+// move to if it is an Rchannel.
+func (cs *connState) newChannel(t *tchannel) (message, *shm.Conn) {
+	if !cs.server.channels || !versionSupportsChannels(atomic.LoadUint32(&cs.version)) {
+		return newErr(linux.ENOSYS), nil
+	}
+	if cs.sock == nil || atomic.LoadUint32(&cs.channel) != 0 {
+		return newErr(linux.EOPNOTSUPP), nil
+	}
+
+	// Other requests in flight could be answered on the socket after the
+	// move. None start until it is done, as recvMu is held.
+	if atomic.LoadInt32(&cs.inflight) > 0 {
+		return newErr(linux.EBUSY), nil
+	}
+
+	size := min(shm.RoundSize(int(t.Size)), maxChannelSize)
+	conn, f, err := shm.New(cs.sock, size)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return newErr(linux.EOPNOTSUPP), nil
+	} else if err != nil {
+		cs.server.log.Printf("p9: creating shared memory: %v", err)
+		return newErr(linux.ExtractErrno(err)), nil
+	}
+	return &rchannel{Size: uint32(size), fd: f}, conn
+}
+
+// openChannel moves the connection on sock to shared memory, unless the
+// server refuses to.
+func (c *Client) openChannel(sock *net.UnixConn) error {
+	var r rchannel
+	err := c.sendRecv(&tchannel{Size: c.channelSize}, &r)
+	var errno linux.Errno
+	if errors.As(err, &errno) {
+		// Keep using the socket.
+		c.log.Printf("p9: not using shared memory: %v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if r.fd == nil {
+		return errors.New("p9: no shared memory received with Rchannel")
+	}
+	defer r.fd.Close()
+
+	conn, err := shm.Open(sock, r.fd)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
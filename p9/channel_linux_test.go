@@ -0,0 +1,156 @@
+package p9
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/shm"
+	"github.com/u-root/uio/ulog/ulogtest"
+)
+
+// unixPair returns a connected pair of unix sockets.
+func unixPair(tb testing.TB) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	conn := func(fd int) *net.UnixConn {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		defer f.Close()
+		c, err := net.FileConn(f)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return c.(*net.UnixConn)
+	}
+	return conn(fds[0]), conn(fds[1])
+}
+
+// channelPair returns both ends of a shared memory connection.
+func channelPair(tb testing.TB, size int) (*shm.Conn, *shm.Conn) {
+	s, c := unixPair(tb)
+	server, f, err := shm.New(s, size)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	client, err := shm.Open(c, f)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return server, client
+}
+
+func TestChannel(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		serverOpts []ServerOpt
+		clientOpts []ClientOpt
+		want       bool
+	}{
+		{
+			name:       "enabled",
+			serverOpts: []ServerOpt{WithServerSharedMemory()},
+			clientOpts: []ClientOpt{WithClientSharedMemory(shm.MinSize)},
+			want:       true,
+		},
+		{
+			name:       "not enabled by server",
+			clientOpts: []ClientOpt{WithClientSharedMemory(0)},
+		},
+		{
+			name:       "not enabled by client",
+			serverOpts: []ServerOpt{WithServerSharedMemory()},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cli := unixPair(t)
+			s := NewServer(nil, append(tt.serverOpts, WithServerLogger(ulogtest.Logger{TB: t}))...)
+			done := make(chan struct{})
+			go func() {
+				_ = s.Handle(srv, srv)
+				close(done)
+			}()
+
+			c, err := NewClient(cli, append(tt.clientOpts, WithClientLogger(ulogtest.Logger{TB: t}))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := c.conn.(*shm.Conn); ok != tt.want {
+				t.Errorf("shared memory = %t, want %t", ok, tt.want)
+			}
+			// Larger than the rings.
+			r := &rversion{}
+			if err := c.sendRecv(&tversion{Version: string(make([]byte, 2*shm.MinSize)), MSize: 1 << 20}, r); err != nil || r.Version != "unknown" {
+				t.Errorf("Tversion = %v, %v, want unknown", r, err)
+			}
+
+			c.Close()
+			<-done
+		})
+	}
+}
+
+// blockingAttacher fails to attach once it is closed.
+type blockingAttacher chan struct{}
+
+func (a blockingAttacher) Attach() (File, error) {
+	<-a
+	return nil, linux.EIO
+}
+
+// TestChannelBusy sends a Tchannel while a Tattach is handled, which would be
+// answered on the socket after the move.
+func TestChannelBusy(t *testing.T) {
+	srv, cli := unixPair(t)
+	l := ulogtest.Logger{TB: t}
+	attacher := make(blockingAttacher)
+	s := NewServer(attacher, WithServerSharedMemory(), WithServerLogger(l))
+	done := make(chan struct{})
+	go func() {
+		_ = s.Handle(srv, srv)
+		close(done)
+	}()
+	defer func() {
+		cli.Close()
+		<-done
+	}()
+	if err := cli.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := send(l, cli, noTag, &tversion{Version: versionString(version9P2000L, highestSupportedVersion), MSize: maximumLength}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := recv(l, cli, maximumLength, msgDotLRegistry.get); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := send(l, cli, 1, &tattach{fid: 1, Auth: tauth{Authenticationfid: noFID, UID: NoUID}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(l, cli, 2, &tchannel{Size: shm.MinSize}); err != nil {
+		t.Fatal(err)
+	}
+	tg, r, err := recv(l, cli, maximumLength, msgDotLRegistry.get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := r.(*rlerror); !ok || tg != 2 || err.Error != uint32(linux.EBUSY) {
+		t.Errorf("Tchannel during Tattach = %v with tag %d, want EBUSY with tag 2", r, tg)
+	}
+
+	// The Tattach is answered on the socket.
+	close(attacher)
+	tg, r, err = recv(l, cli, maximumLength, msgDotLRegistry.get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := r.(*rlerror); !ok || tg != 1 || err.Error != uint32(linux.EIO) {
+		t.Errorf("Tattach = %v with tag %d, want EIO with tag 1", r, tg)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync"
 
 	"github.com/hugelgupf/p9/linux"
@@ -103,6 +104,11 @@ type Client struct {
 	// version 0 implies 9P2000.L.
 	version uint32
 
+	// channel is true if the connection should move to shared memory,
+	// with rings of channelSize bytes.
+	channel     bool
+	channelSize uint32
+
 	// log is the logger to write to, if specified.
 	log ulog.Logger
 }
@@ -133,6 +139,20 @@ func WithClientLogger(l ulog.Logger) ClientOpt {
 	}
 }
 
+// WithClientSharedMemory moves the connection to shared memory with the
+// server, if it is a unix socket and the server supports it. The socket is
+// then only used to find out when the server goes away.
+//
+// size is the size of the buffer in each direction, or 0 for the server's
+// default.
+func WithClientSharedMemory(size uint32) ClientOpt {
+	return func(c *Client) error {
+		c.channel = true
+		c.channelSize = size
+		return nil
+	}
+}
+
 func roundDown(p uint32, align uint32) uint32 {
 	if p > align && p%align != 0 {
 		return p - p%align
@@ -202,6 +222,18 @@ func NewClient(conn io.ReadWriteCloser, o ...ClientOpt) (*Client, error) {
 		c.version = version
 		break
 	}
+
+	// The server may now send file descriptors with messages.
+	sock, _ := conn.(*net.UnixConn)
+	if versionSupportsFDDonation(c.version) {
+		c.conn = receiveFDs(c.conn)
+	}
+
+	if c.channel && sock != nil && versionSupportsChannels(c.version) {
+		if err := c.openChannel(sock); err != nil {
+			return nil, err
+		}
+	}
 	return c, nil
 }
 
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"runtime"
 	"strings"
 	"sync/atomic"
@@ -29,13 +30,21 @@ import (
 //
 // Note that authentication is not currently supported.
 func (c *Client) Attach(name string) (File, error) {
+	return c.AttachUser(name, "", NoUID)
+}
+
+// AttachUser attaches to a server as the user with the given name and ID.
+//
+// Servers may use them to serve files with that user's permissions; see
+// UserAttacher.
+func (c *Client) AttachUser(name string, uname string, uid UID) (File, error) {
 	id, ok := c.fidPool.Get()
 	if !ok {
 		return nil, ErrOutOfFIDs
 	}
 
 	rattach := rattach{}
-	if err := c.sendRecv(&tattach{fid: fid(id), Auth: tauth{AttachName: name, Authenticationfid: noFID, UID: NoUID}}, &rattach); err != nil {
+	if err := c.sendRecv(&tattach{fid: fid(id), Auth: tauth{AttachName: name, Authenticationfid: noFID, UserName: uname, UID: uid}}, &rattach); err != nil {
 		c.fidPool.Put(id)
 		return nil, err
 	}
@@ -68,16 +77,32 @@ type clientFile struct {
 
 	// closed indicates whether this file has been closed.
 	closed uint32
+
+	// fd is the host file descriptor donated by the server on open, if
+	// any.
+	fd *os.File
+}
+
+// FD returns the host file descriptor donated by the server when the file
+// was opened or created, if any. Servers donate file descriptors on unix
+// sockets if they enable WithFDDonation.
+//
+// The file descriptor belongs to the file, and is closed by Close.
+func (c *clientFile) FD() (*os.File, bool) {
+	return c.fd, c.fd != nil
 }
 
 // SetXattr implements p9.File.SetXattr.
 func (c *clientFile) SetXattr(attr string, data []byte, flags XattrFlags) error {
-	return linux.ENOSYS
+	return c.xattrCreateWrite(attr, data, flags)
 }
 
 // RemoveXattr implements p9.File.RemoveXattr.
+//
+// As in the Linux v9fs client, removing is setting an empty value that must
+// replace an existing one.
 func (c *clientFile) RemoveXattr(attr string) error {
-	return linux.ENOSYS
+	return c.xattrCreateWrite(attr, nil, XattrReplace)
 }
 
 // GetXattr implements p9.File.GetXattr.
@@ -147,6 +172,35 @@ func (c *clientFile) xattrWalkRead(attr string) ([]byte, error) {
 	return buf[:n], nil
 }
 
+// xattrCreateWrite performs the write half of the 9P2000.L xattr protocol
+// shared by SetXattr and RemoveXattr: Txattrcreate turns a clone of the file
+// into the attribute with the value's size, the value is written to it, and
+// clunking it sets the attribute.
+func (c *clientFile) xattrCreateWrite(attr string, data []byte, flags XattrFlags) error {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return linux.EBADF
+	}
+
+	_, f, err := c.Walk(nil)
+	if err != nil {
+		return err
+	}
+	xattrFile := f.(*clientFile)
+
+	if err := c.client.sendRecv(&txattrcreate{fid: xattrFile.fid, Name: attr, AttrSize: uint64(len(data)), Flags: uint32(flags)}, &rxattrcreate{}); err != nil {
+		xattrFile.Close()
+		return err
+	}
+	if len(data) > 0 {
+		if _, err := xattrFile.WriteAt(data, 0); err != nil {
+			xattrFile.Close()
+			return err
+		}
+	}
+	// The server sets the attribute when the fid is clunked.
+	return xattrFile.Close()
+}
+
 // Walk implements File.Walk.
 func (c *clientFile) Walk(names []string) ([]QID, File, error) {
 	if atomic.LoadUint32(&c.closed) != 0 {
@@ -256,6 +310,7 @@ func (c *clientFile) Lock(pid int, locktype LockType, flags LockFlags, start, le
 
 	r := rlock{}
 	err := c.client.sendRecv(&tlock{
+		fid:    c.fid,
 		Type:   locktype,
 		Flags:  flags,
 		Start:  start,
@@ -266,6 +321,26 @@ func (c *clientFile) Lock(pid int, locktype LockType, flags LockFlags, start, le
 	return r.Status, err
 }
 
+// GetLock implements LockGetter.GetLock.
+func (c *clientFile) GetLock(pid int, locktype LockType, start, length uint64, client string) (LockInfo, error) {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return LockInfo{}, linux.EBADF
+	}
+
+	r := rgetlock{}
+	err := c.client.sendRecv(&tgetlock{
+		fid: c.fid,
+		LockInfo: LockInfo{
+			Type:   locktype,
+			Start:  start,
+			Length: length,
+			PID:    int32(pid),
+			Client: client,
+		},
+	}, &r)
+	return r.LockInfo, err
+}
+
 // Remove implements File.Remove.
 //
 // N.B. This method is no longer part of the file interface and should be
@@ -299,6 +374,10 @@ func (c *clientFile) Close() error {
 	}
 	runtime.SetFinalizer(c, nil)
 
+	if c.fd != nil {
+		c.fd.Close()
+	}
+
 	// Send the close message.
 	if err := c.client.sendRecv(&tclunk{fid: c.fid}, &rclunk{}); err != nil {
 		// If an error occurred, we toss away the fid. This isn't ideal,
@@ -322,6 +401,7 @@ func (c *clientFile) Open(flags OpenFlags) (QID, uint32, error) {
 		return QID{}, 0, err
 	}
 
+	c.fd = rlopen.fd
 	return rlopen.QID, rlopen.IoUnit, nil
 }
 
@@ -455,6 +535,7 @@ func (c *clientFile) Create(name string, openFlags OpenFlags, permissions FileMo
 		if err := c.client.sendRecv(&tucreate{tlcreate: msg, UID: uid}, &rucreate); err != nil {
 			return nil, QID{}, 0, err
 		}
+		c.fd = rucreate.fd
 		return c, rucreate.QID, rucreate.IoUnit, nil
 	}
 
@@ -463,6 +544,7 @@ func (c *clientFile) Create(name string, openFlags OpenFlags, permissions FileMo
 		return nil, QID{}, 0, err
 	}
 
+	c.fd = rlcreate.fd
 	return c, rlcreate.QID, rlcreate.IoUnit, nil
 }
 
@@ -0,0 +1,95 @@
+package p9_test
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/templatefs"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// qidAttacher attaches a directory with its value as QID path.
+type qidAttacher uint64
+
+func (a qidAttacher) Attach() (p9.File, error) {
+	return qidDir{path: uint64(a)}, nil
+}
+
+type qidDir struct {
+	templatefs.NoopFile
+	path uint64
+}
+
+func (d qidDir) GetAttr(p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	return p9.QID{Type: p9.TypeDir, Path: d.path}, p9.AttrMask{Mode: true}, p9.Attr{Mode: p9.ModeDirectory | 0o755}, nil
+}
+
+func TestConnAttacher(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		attachers = make(map[net.Conn]p9.Attacher)
+	)
+	s := p9.NewServer(qidAttacher(1), p9.WithConnAttacher(func(conn net.Conn) (p9.Attacher, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		a, ok := attachers[conn]
+		if !ok {
+			return nil, errors.New("unknown connection")
+		}
+		return a, nil
+	}))
+
+	for _, tt := range []struct {
+		name     string
+		known    bool
+		attacher p9.Attacher
+		want     uint64
+	}{
+		{name: "selected", known: true, attacher: qidAttacher(2), want: 2},
+		{name: "default", known: true, want: 1},
+		{name: "refused"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cli := net.Pipe()
+			if tt.known {
+				mu.Lock()
+				attachers[srv] = tt.attacher
+				mu.Unlock()
+			}
+			done := make(chan struct{})
+			go func() {
+				_ = s.Handle(srv, srv)
+				close(done)
+			}()
+			defer func() {
+				cli.Close()
+				<-done
+			}()
+
+			c, err := p9.NewClient(cli)
+			if !tt.known {
+				if err == nil {
+					t.Errorf("NewClient = nil, want error for refused connection")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := c.Attach("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			qid, _, _, err := f.GetAttr(p9.AttrMask{Mode: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if qid.Path != tt.want {
+				t.Errorf("attached QID path %d, want %d", qid.Path, tt.want)
+			}
+		})
+	}
+}
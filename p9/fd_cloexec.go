@@ -0,0 +1,12 @@
+//go:build dragonfly || freebsd || linux || netbsd || openbsd
+
+package p9
+
+import "syscall"
+
+// recvmsg receives a message on the socket fd with recvmsg(2), making the file
+// descriptors received with it close-on-exec.
+func recvmsg(fd int, p, oob []byte) (n, oobn, flags int, err error) {
+	n, oobn, flags, _, err = syscall.Recvmsg(fd, p, oob, syscall.MSG_CMSG_CLOEXEC)
+	return n, oobn, flags, err
+}
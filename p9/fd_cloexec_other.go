@@ -0,0 +1,22 @@
+//go:build unix && !(dragonfly || freebsd || linux || netbsd || openbsd)
+
+package p9
+
+import "syscall"
+
+// recvmsg receives a message on the socket fd with recvmsg(2), making the file
+// descriptors received with it close-on-exec.
+//
+// Without MSG_CMSG_CLOEXEC, that is done after receiving them, holding off
+// forks until then.
+func recvmsg(fd int, p, oob []byte) (n, oobn, flags int, err error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	n, oobn, flags, _, err = syscall.Recvmsg(fd, p, oob, 0)
+	if oobn > 0 {
+		for _, fd := range rights(oob[:oobn]) {
+			syscall.CloseOnExec(fd)
+		}
+	}
+	return n, oobn, flags, err
+}
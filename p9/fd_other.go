@@ -0,0 +1,27 @@
+//go:build !unix
+
+package p9
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"github.com/hugelgupf/p9/vecnet"
+)
+
+// dupFile is not supported, so file descriptors are not donated.
+func dupFile(*os.File) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// writeWithFD is not supported.
+func writeWithFD(*net.UnixConn, vecnet.Buffers, *os.File) error {
+	return errors.ErrUnsupported
+}
+
+// receiveFDs returns conn, as file descriptors cannot be received.
+func receiveFDs(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	return conn
+}
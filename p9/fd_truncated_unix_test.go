@@ -0,0 +1,80 @@
+//go:build unix
+
+package p9
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/u-root/uio/ulog/ulogtest"
+)
+
+// TestFDsTruncated receives a message with more file descriptors than the
+// client receives, which must not be handled without them.
+func TestFDsTruncated(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cli, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := conn.(*net.UnixConn)
+	defer srv.Close()
+
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fds := make([]int, 4*maxFDs)
+	for i := range fds {
+		fds[i] = int(f.Fd())
+	}
+
+	// The server answers Tattach with too many file descriptors.
+	log := ulogtest.Logger{TB: t}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- func() error {
+			tg, m, err := recv(log, srv, maximumLength, msgDotLRegistry.get)
+			if err != nil {
+				return err
+			}
+			if err := send(log, srv, tg, &rversion{MSize: m.(*tversion).MSize, Version: versionString(version9P2000L, highestSupportedVersion)}); err != nil {
+				return err
+			}
+			if tg, _, err = recv(log, srv, maximumLength, msgDotLRegistry.get); err != nil {
+				return err
+			}
+			var b bytes.Buffer
+			if err := send(log, &b, tg, &rattach{}); err != nil {
+				return err
+			}
+			_, _, err = srv.WriteMsgUnix(b.Bytes(), syscall.UnixRights(fds...), nil)
+			return err
+		}()
+	}()
+
+	c, err := NewClient(cli, WithClientLogger(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Attach(""); err == nil {
+		t.Errorf("Attach with dropped file descriptors succeeded, want error")
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
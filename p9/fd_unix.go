@@ -0,0 +1,172 @@
+//go:build unix
+
+package p9
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/hugelgupf/p9/vecnet"
+	"golang.org/x/sys/unix"
+)
+
+// maxFDs is the number of file descriptors received with a message. Messages
+// carry at most one; any others are closed.
+const maxFDs = 4
+
+// dupFile returns a close-on-exec duplicate of f.
+func dupFile(f *os.File) (*os.File, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		nfd  int
+		derr error
+	)
+	if err := rc.Control(func(fd uintptr) {
+		nfd, derr = unix.FcntlInt(fd, unix.F_DUPFD_CLOEXEC, 0)
+	}); err != nil {
+		return nil, err
+	}
+	if derr != nil {
+		return nil, os.NewSyscallError("fcntl", derr)
+	}
+	return os.NewFile(uintptr(nfd), f.Name()), nil
+}
+
+// writeWithFD writes bufs to c, sending fd with them.
+func writeWithFD(c *net.UnixConn, bufs vecnet.Buffers, fd *os.File) error {
+	// WriteMsgUnix takes a single buffer, but messages carrying file
+	// descriptors are small.
+	b := bytes.Join(bufs, nil)
+	n, _, err := c.WriteMsgUnix(b, syscall.UnixRights(int(fd.Fd())), nil)
+	if err != nil {
+		return err
+	}
+	// The rest of a partial write is sent without the file descriptor.
+	if n < len(b) {
+		_, err = c.Write(b[n:])
+	}
+	return err
+}
+
+// errFDsTruncated is returned when file descriptors sent with a message did
+// not fit the buffer for control messages and were dropped.
+var errFDsTruncated = errors.New("file descriptors received with a message were dropped")
+
+// fdConn is a unix socket connection that keeps the file descriptors received
+// with messages.
+type fdConn struct {
+	*net.UnixConn
+
+	// rc receives messages with recvmsg(2).
+	rc syscall.RawConn
+
+	// oob is the buffer for received control messages.
+	oob []byte
+
+	// fds are the file descriptors received since the last attachFDs.
+	fds []*os.File
+}
+
+// receiveFDs returns conn with received file descriptors attached to
+// messages, if conn is a unix socket.
+func receiveFDs(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return conn
+	}
+	return &fdConn{
+		UnixConn: uc,
+		rc:       rc,
+		oob:      make([]byte, syscall.CmsgSpace(maxFDs*4)),
+	}
+}
+
+// Read implements io.Reader.Read.
+//
+// File descriptors are sent with the first byte of a message, so they are
+// received when reading its header.
+func (c *fdConn) Read(p []byte) (int, error) {
+	var (
+		n, oobn, flags int
+		rerr           error
+	)
+	err := c.rc.Read(func(fd uintptr) bool {
+		for {
+			n, oobn, flags, rerr = recvmsg(int(fd), p, c.oob)
+			if rerr != syscall.EINTR {
+				return rerr != syscall.EAGAIN
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rerr != nil {
+		return 0, os.NewSyscallError("recvmsg", rerr)
+	}
+	if oobn > 0 {
+		c.fds = append(c.fds, parseRights(c.oob[:oobn])...)
+	}
+	if flags&syscall.MSG_CTRUNC != 0 {
+		// The message must not be handled without its file
+		// descriptors, and no bytes are returned so that readers
+		// do not drop the error.
+		return 0, errFDsTruncated
+	}
+	if n == 0 && len(p) > 0 {
+		// The other end is closed.
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// attachFDs implements fdReceiver.attachFDs.
+func (c *fdConn) attachFDs(m message) {
+	fds := c.fds
+	c.fds = nil
+	for i, f := range fds {
+		if fc, ok := m.(fdCarrier); ok && i == 0 {
+			fc.setDonatedFD(f)
+		} else {
+			f.Close()
+		}
+	}
+}
+
+// rights returns the file descriptors in the SCM_RIGHTS control messages in
+// oob.
+func rights(oob []byte) []int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	var fds []int
+	for i := range msgs {
+		r, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+		fds = append(fds, r...)
+	}
+	return fds
+}
+
+// parseRights returns the files in the SCM_RIGHTS control messages in oob.
+func parseRights(oob []byte) []*os.File {
+	var files []*os.File
+	for _, fd := range rights(oob) {
+		files = append(files, os.NewFile(uintptr(fd), "9p-donated-fd"))
+	}
+	return files
+}
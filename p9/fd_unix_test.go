@@ -0,0 +1,153 @@
+//go:build unix
+
+package p9_test
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/templatefs"
+	"github.com/hugelgupf/p9/p9"
+	"golang.org/x/sys/unix"
+)
+
+// hostFile is a directory whose children are a host file.
+type hostFile struct {
+	templatefs.NoopFile
+
+	path string
+	file *os.File
+}
+
+func (f *hostFile) Walk(names []string) ([]p9.QID, p9.File, error) {
+	return []p9.QID{{Path: 2}}, &hostFile{path: f.path}, nil
+}
+
+func (f *hostFile) GetAttr(p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	return p9.QID{Type: p9.TypeDir, Path: 1}, p9.AttrMask{Mode: true}, p9.Attr{Mode: p9.ModeDirectory | 0o755}, nil
+}
+
+func (f *hostFile) Open(p9.OpenFlags) (p9.QID, uint32, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return p9.QID{}, 0, err
+	}
+	f.file = file
+	return p9.QID{Path: 2}, 0, nil
+}
+
+func (f *hostFile) ReadAt(p []byte, offset int64) (int, error) {
+	return f.file.ReadAt(p, offset)
+}
+
+func (f *hostFile) FD() (*os.File, bool) {
+	return f.file, f.file != nil
+}
+
+func (f *hostFile) Close() error {
+	if f.file != nil {
+		return f.file.Close()
+	}
+	return nil
+}
+
+type hostFileAttacher struct {
+	path string
+}
+
+func (a hostFileAttacher) Attach() (p9.File, error) {
+	return &hostFile{path: a.path}, nil
+}
+
+func TestFDDonation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	unixConns := func(t *testing.T) (net.Conn, net.Conn) {
+		l, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		cli, err := net.Dial("unix", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return srv, cli
+	}
+
+	for _, tt := range []struct {
+		name    string
+		conns   func(t *testing.T) (net.Conn, net.Conn)
+		opts    []p9.ServerOpt
+		donated bool
+	}{
+		{"donated", unixConns, []p9.ServerOpt{p9.WithFDDonation()}, true},
+		{"not enabled", unixConns, nil, false},
+		{"not unix socket", func(*testing.T) (net.Conn, net.Conn) { return net.Pipe() }, []p9.ServerOpt{p9.WithFDDonation()}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cli := tt.conns(t)
+			s := p9.NewServer(hostFileAttacher{path}, tt.opts...)
+			done := make(chan struct{})
+			go func() {
+				_ = s.Handle(srv, srv)
+				close(done)
+			}()
+			defer func() {
+				cli.Close()
+				<-done
+			}()
+
+			c, err := p9.NewClient(cli)
+			if err != nil {
+				t.Fatal(err)
+			}
+			root, err := c.Attach("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer root.Close()
+			_, f, err := root.Walk([]string{"file"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := f.Open(p9.ReadOnly); err != nil {
+				t.Fatal(err)
+			}
+
+			fd, ok := f.(p9.HostFile).FD()
+			if ok != tt.donated {
+				t.Fatalf("FD() = %v, %t, want donated %t", fd, ok, tt.donated)
+			}
+			if ok {
+				if flags, err := unix.FcntlInt(fd.Fd(), unix.F_GETFD, 0); err != nil || flags&unix.FD_CLOEXEC == 0 {
+					t.Errorf("donated file flags = %#x, %v, want FD_CLOEXEC", flags, err)
+				}
+				got, err := io.ReadAll(fd)
+				if err != nil || string(got) != "content" {
+					t.Errorf("reading donated file = %q, %v, want content", got, err)
+				}
+			}
+
+			// The connection is still in sync.
+			buf := make([]byte, 7)
+			if n, err := f.ReadAt(buf, 0); string(buf[:n]) != "content" {
+				t.Errorf("ReadAt = %q, %v, want content", buf[:n], err)
+			}
+			if err := f.Close(); err != nil {
+				t.Errorf("Close = %v", err)
+			}
+		})
+	}
+}
@@ -15,6 +15,10 @@
 package p9
 
 import (
+	"errors"
+	"io"
+	"os"
+
 	"github.com/hugelgupf/p9/linux"
 )
 
@@ -27,6 +31,50 @@ type Attacher interface {
 	Attach() (File, error)
 }
 
+// UserAttacher is an Attacher that serves files on behalf of the attaching
+// user, e.g. with that user's permissions.
+//
+// The server calls AttachUser instead of Attach if the attacher implements
+// it.
+type UserAttacher interface {
+	Attacher
+
+	// AttachUser returns a new File for the user with the given name and
+	// ID, as given by the client in Tattach. uid is NoUID if the client
+	// only gave a name, or if the user is named by the client's TLS
+	// certificate (see Server.Handle).
+	AttachUser(uname string, uid UID) (File, error)
+}
+
+// AttachUser attaches to a as the given user if a is a UserAttacher, and
+// calls a.Attach otherwise.
+//
+// Attachers that wrap other attachers implement UserAttacher with it.
+func AttachUser(a Attacher, uname string, uid UID) (File, error) {
+	if ua, ok := a.(UserAttacher); ok {
+		return ua.AttachUser(uname, uid)
+	}
+	return a.Attach()
+}
+
+// ExportAttacher is an Attacher that serves several file systems, or exports,
+// selected by the first element of the attach name (aname) given by clients
+// in Tattach. The rest of the attach name is walked from the root of the
+// export.
+//
+// The server calls AttachExport instead of Attach or AttachUser if the
+// attacher implements it.
+type ExportAttacher interface {
+	Attacher
+
+	// AttachExport returns the root of the named export for the given
+	// user, as UserAttacher.AttachUser does. name is empty if the client
+	// gave no attach name.
+	//
+	// Unknown exports return ENOENT.
+	AttachExport(name string, uname string, uid UID) (File, error)
+}
+
 // File is a set of operations corresponding to a single node.
 //
 // Note that on the server side, the server logic places constraints on
@@ -265,6 +313,87 @@ type File interface {
 	Renamed(newDir File, newName string)
 }
 
+// Payload is file data that is written directly to the server's transport,
+// as returned by ReaderTo.
+type Payload interface {
+	// Len returns the number of bytes in the payload.
+	Len() int
+
+	// WriteTo writes the whole payload to the writer given to ReadTo.
+	WriteTo(w io.Writer) (int64, error)
+
+	// Close releases the payload. It is called once the payload has been
+	// written, or when it will not be.
+	Close() error
+}
+
+// ReaderTo is a File that can pass its data to the server's transport
+// without copying it into the server's buffers, e.g. with splice(2).
+//
+// The server calls ReadTo instead of ReadAt if the file implements it.
+type ReaderTo interface {
+	File
+
+	// ReadTo reads up to count bytes at offset, which the server writes
+	// to w after the header of the response. The payload holds fewer than
+	// count bytes only at the end of the file.
+	//
+	// ReadTo must not write to w itself. If the data cannot be written to
+	// w this way, ReadTo returns errors.ErrUnsupported and the server
+	// falls back to ReadAt.
+	//
+	// On the server, ReadTo has a read concurrency guarantee, like ReadAt.
+	ReadTo(w io.Writer, count uint32, offset int64) (Payload, error)
+}
+
+// ReadTo reads from f with ReadTo if f is a ReaderTo, and returns
+// errors.ErrUnsupported otherwise.
+//
+// Files that wrap other files implement ReaderTo with it.
+func ReadTo(f File, w io.Writer, count uint32, offset int64) (Payload, error) {
+	if rt, ok := f.(ReaderTo); ok {
+		return rt.ReadTo(w, count, offset)
+	}
+	return nil, errors.ErrUnsupported
+}
+
+// HostFile is a File backed by a host file descriptor, which the server can
+// donate to clients on unix sockets (see WithFDDonation).
+type HostFile interface {
+	File
+
+	// FD returns the host file descriptor of the opened file, or false if
+	// it has none or it must not be donated. It is called after Open or
+	// Create, and the File keeps ownership of the descriptor.
+	FD() (*os.File, bool)
+}
+
+// LockGetter is a File that can test for POSIX record locks.
+//
+// The server answers Tgetlock with ENOSYS if the file does not implement it.
+type LockGetter interface {
+	File
+
+	// GetLock tests whether the lock described by the arguments, which are
+	// as for Lock, could be placed, like fcntl(F_GETLK).
+	//
+	// If it could, the returned LockInfo describes the requested lock
+	// with Type Unlock. Otherwise, it describes one of the conflicting
+	// locks.
+	GetLock(pid int, locktype LockType, start, length uint64, client string) (LockInfo, error)
+}
+
+// GetLock tests for a lock on f with GetLock if f is a LockGetter, and
+// returns ENOSYS otherwise.
+//
+// Files that wrap other files implement LockGetter with it.
+func GetLock(f File, pid int, locktype LockType, start, length uint64, client string) (LockInfo, error) {
+	if lg, ok := f.(LockGetter); ok {
+		return lg.GetLock(pid, locktype, start, length, client)
+	}
+	return LockInfo{}, linux.ENOSYS
+}
+
 // DefaultWalkGetAttr implements File.WalkGetAttr to return ENOSYS for server-side Files.
 type DefaultWalkGetAttr struct{}
 
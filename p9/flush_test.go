@@ -0,0 +1,52 @@
+package p9
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/u-root/uio/ulog/ulogtest"
+)
+
+// TestFlushOwnTag sends a Tflush of its own tag, which used to wait for
+// itself forever.
+func TestFlushOwnTag(t *testing.T) {
+	srv, cli := net.Pipe()
+	l := ulogtest.Logger{TB: t}
+	s := NewServer(nil, WithServerLogger(l))
+	done := make(chan struct{})
+	go func() {
+		_ = s.Handle(srv, srv)
+		close(done)
+	}()
+	defer func() {
+		cli.Close()
+		// Without a reply, the server is stuck in the handler.
+		if !t.Failed() {
+			<-done
+		}
+	}()
+	if err := cli.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := send(l, cli, noTag, &tversion{Version: versionString(version9P2000L, highestSupportedVersion), MSize: maximumLength}); err != nil {
+		t.Fatal(err)
+	}
+	if _, m, err := recv(l, cli, maximumLength, msgDotLRegistry.get); err != nil {
+		t.Fatal(err)
+	} else if _, ok := m.(*rversion); !ok {
+		t.Fatalf("Tversion = %v, want Rversion", m)
+	}
+
+	if err := send(l, cli, 5, &tflush{OldTag: 5}); err != nil {
+		t.Fatal(err)
+	}
+	tg, m, err := recv(l, cli, maximumLength, msgDotLRegistry.get)
+	if err != nil {
+		t.Fatalf("Tflush of its own tag: %v", err)
+	}
+	if _, ok := m.(*rflush); !ok || tg != 5 {
+		t.Errorf("Tflush of its own tag = %v with tag %d, want Rflush with tag 5", m, tg)
+	}
+}
@@ -246,8 +246,30 @@ func (t *tattach) handle(cs *connState) message {
 		t.Auth.AttachName = t.Auth.AttachName[1:]
 	}
 
-	// Do the attach on the root.
-	sf, err := cs.server.attacher.Attach()
+	// Clients authenticated by a certificate attach as its user. The
+	// attacher limits the owners that later requests may give to that
+	// user's, such as localfs does in multi-user mode.
+	uname, uid := t.Auth.UserName, t.Auth.UID
+	if cs.certUser != "" {
+		uname, uid = cs.certUser, NoUID
+	}
+
+	// Do the attach on the root, or on the root of the export named by
+	// the first element of the attach name.
+	var (
+		sf       File
+		err      error
+		pathTree = cs.trees.root
+	)
+	if ea, ok := cs.attacher.(ExportAttacher); ok {
+		var name string
+		name, t.Auth.AttachName, _ = strings.Cut(t.Auth.AttachName, "/")
+		if sf, err = ea.AttachExport(name, uname, uid); err == nil {
+			pathTree = cs.trees.export(name)
+		}
+	} else {
+		sf, err = AttachUser(cs.attacher, uname, uid)
+	}
 	if err != nil {
 		return newErr(err)
 	}
@@ -268,7 +290,7 @@ func (t *tattach) handle(cs *connState) message {
 		file:     sf,
 		refs:     1,
 		mode:     attr.Mode.FileType(),
-		pathNode: cs.server.pathTree,
+		pathNode: pathTree,
 	}
 	defer root.DecRef()
 
@@ -339,7 +361,7 @@ func (t *tlopen) handle(cs *connState) message {
 	ref.opened = true
 	ref.openFlags = t.Flags
 
-	return &rlopen{QID: qid, IoUnit: ioUnit}
+	return &rlopen{QID: qid, IoUnit: ioUnit, fd: cs.donateFD(ref.file)}
 }
 
 func (t *tlcreate) do(cs *connState, uid UID) (*rlcreate, error) {
@@ -397,7 +419,7 @@ func (t *tlcreate) do(cs *connState, uid UID) (*rlcreate, error) {
 	// Replace the fid reference.
 	cs.InsertFID(t.fid, newRef)
 
-	return &rlcreate{rlopen: rlopen{QID: qid, IoUnit: ioUnit}}, nil
+	return &rlcreate{rlopen: rlopen{QID: qid, IoUnit: ioUnit, fd: cs.donateFD(nsf)}}, nil
 }
 
 // handle implements handler.handle.
@@ -710,7 +732,10 @@ func (t *tread) handle(cs *connState) message {
 		return newErr(linux.ENOBUFS)
 	}
 
-	var n int
+	var (
+		n       int
+		payload Payload
+	)
 	data := cs.readBufPool.Get().(*[]byte)
 	// Retain a reference to the full length of the buffer.
 	dataBuf := (*data)
@@ -727,6 +752,14 @@ func (t *tread) handle(cs *connState) message {
 				return linux.EPERM
 			}
 
+			// Let the file pass its data to the transport directly
+			// if it can.
+			payload, err = ReadTo(ref.file, cs.r, t.Count, int64(t.Offset))
+			if !errors.Is(err, errors.ErrUnsupported) {
+				return err
+			}
+			payload = nil
+
 			n, err = ref.file.ReadAt(dataBuf[:t.Count], int64(t.Offset))
 			return err
 
@@ -759,6 +792,12 @@ func (t *tread) handle(cs *connState) message {
 		return newErr(err)
 	}
 
+	if payload != nil {
+		// The buffer was not used.
+		cs.readBufPool.Put(data)
+		return &rreadFilePayload{payload}
+	}
+
 	return &rreadServerPayloader{
 		rread: rread{
 			Data: dataBuf[:n],
@@ -994,9 +1033,16 @@ func (t *txattrwalk) handle(cs *connState) message {
 			return linux.EINVAL
 		}
 		size = len(buf)
+
+		// The new fid is clunked independently of ref, so it needs a
+		// file of its own.
+		_, file, err := ref.file.Walk(nil)
+		if err != nil {
+			return err
+		}
 		newRef := &fidRef{
 			server: cs.server,
-			file:   ref.file,
+			file:   file,
 			pendingXattr: pendingXattr{
 				op:   xattrWalk,
 				name: t.Name,
@@ -1129,6 +1175,22 @@ func (t *tlock) handle(cs *connState) message {
 	return &rlock{Status: status}
 }
 
+// handle implements handler.handle.
+func (t *tgetlock) handle(cs *connState) message {
+	// Lookup the fid.
+	ref, ok := cs.LookupFID(t.fid)
+	if !ok {
+		return newErr(linux.EBADF)
+	}
+	defer ref.DecRef()
+
+	l, err := GetLock(ref.file, int(t.PID), t.Type, t.Start, t.Length, t.Client)
+	if err != nil {
+		return newErr(err)
+	}
+	return &rgetlock{LockInfo: l}
+}
+
 // walkOne walks zero or one path elements.
 //
 // The slice passed as qids is append and returned.
@@ -0,0 +1,136 @@
+package p9_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/templatefs"
+	"github.com/hugelgupf/p9/linux"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// lockFile records the locks taken on it. Walking from it returns child.
+type lockFile struct {
+	templatefs.NoopFile
+
+	child *lockFile
+	locks []p9.LockType
+}
+
+func (f *lockFile) Walk(names []string) ([]p9.QID, p9.File, error) {
+	return []p9.QID{{Path: 2}}, f.child, nil
+}
+
+func (f *lockFile) GetAttr(p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	return p9.QID{Type: p9.TypeDir, Path: 1}, p9.AttrMask{Mode: true}, p9.Attr{Mode: p9.ModeDirectory | 0o755}, nil
+}
+
+func (f *lockFile) Lock(pid int, locktype p9.LockType, flags p9.LockFlags, start, length uint64, client string) (p9.LockStatus, error) {
+	f.locks = append(f.locks, locktype)
+	return p9.LockStatusOK, nil
+}
+
+type lockAttacher struct {
+	root p9.File
+}
+
+func (a lockAttacher) Attach() (p9.File, error) {
+	return a.root, nil
+}
+
+func TestClientLock(t *testing.T) {
+	child := &lockFile{}
+	root := &lockFile{child: child}
+	srv, cli := net.Pipe()
+	s := p9.NewServer(lockAttacher{root})
+	done := make(chan struct{})
+	go func() {
+		_ = s.Handle(srv, srv)
+		close(done)
+	}()
+	defer func() {
+		cli.Close()
+		<-done
+	}()
+
+	c, err := p9.NewClient(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Attach("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	_, f, err := r.Walk([]string{"file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if status, err := f.Lock(1, p9.WriteLock, 0, 0, 0, "client"); err != nil || status != p9.LockStatusOK {
+		t.Fatalf("Lock = %v, %v, want LockStatusOK", status, err)
+	}
+	if len(child.locks) != 1 || len(root.locks) != 0 {
+		t.Errorf("locked file %v and root %v, want only the file locked", child.locks, root.locks)
+	}
+}
+
+// heldLockFile is a lockFile on which a write lock is held.
+type heldLockFile struct {
+	lockFile
+}
+
+func (f *heldLockFile) GetLock(pid int, locktype p9.LockType, start, length uint64, client string) (p9.LockInfo, error) {
+	return p9.LockInfo{Type: p9.WriteLock, PID: 42, Client: "holder"}, nil
+}
+
+func TestClientGetLock(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		file    p9.File
+		want    p9.LockInfo
+		wantErr error
+	}{
+		{
+			name: "held",
+			file: &heldLockFile{},
+			want: p9.LockInfo{Type: p9.WriteLock, PID: 42, Client: "holder"},
+		},
+		{
+			name:    "not a LockGetter",
+			file:    &lockFile{},
+			wantErr: linux.ENOSYS,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cli := net.Pipe()
+			s := p9.NewServer(lockAttacher{tt.file})
+			done := make(chan struct{})
+			go func() {
+				_ = s.Handle(srv, srv)
+				close(done)
+			}()
+			defer func() {
+				cli.Close()
+				<-done
+			}()
+
+			c, err := p9.NewClient(cli)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := c.Attach("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			got, err := p9.GetLock(f, 1, p9.WriteLock, 0, 0, "client")
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("GetLock = %v, %v, want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ package p9
 import (
 	"fmt"
 	"math"
+	"os"
 )
 
 // ErrInvalidMsgType is returned when an unsupported message type is found.
@@ -57,6 +58,24 @@ type payloader interface {
 	PayloadCleanup()
 }
 
+// fdCarrier is a special message which may carry a host file descriptor.
+type fdCarrier interface {
+	// donatedFD returns the file descriptor to send, or nil. It is
+	// closed after the message is sent.
+	donatedFD() *os.File
+
+	// setDonatedFD sets the received file descriptor.
+	setDonatedFD(f *os.File)
+}
+
+// filePayloader is a special message whose payload is written to the
+// transport by a File's Payload, after the rest of the message.
+type filePayloader interface {
+	// FilePayload returns the payload for sending. It is closed after the
+	// message is sent.
+	FilePayload() Payload
+}
+
 // tversion is a version request.
 type tversion struct {
 	// MSize is the message size to use.
@@ -513,6 +532,10 @@ type rlopen struct {
 
 	// IoUnit is the recommended I/O unit.
 	IoUnit uint32
+
+	// fd is the file's host file descriptor, if donated. It is sent
+	// alongside the message on unix sockets.
+	fd *os.File
 }
 
 // decode implements encoder.decode.
@@ -537,6 +560,16 @@ func (r *rlopen) String() string {
 	return fmt.Sprintf("Rlopen{QID: %s, IoUnit: %d}", r.QID, r.IoUnit)
 }
 
+// donatedFD implements fdCarrier.donatedFD.
+func (r *rlopen) donatedFD() *os.File {
+	return r.fd
+}
+
+// setDonatedFD implements fdCarrier.setDonatedFD.
+func (r *rlopen) setDonatedFD(f *os.File) {
+	r.fd = f
+}
+
 // tlcreate is a create request.
 type tlcreate struct {
 	// fid is the parent fid.
@@ -1081,6 +1114,41 @@ func (r *rread) String() string {
 	return fmt.Sprintf("Rread{len(Data): %d}", len(r.Data))
 }
 
+// rreadFilePayload is an Rread whose data is held by a File's Payload. It is
+// only sent by the server.
+type rreadFilePayload struct {
+	payload Payload
+}
+
+// decode implements encoder.decode.
+//
+// rreadFilePayload is never received; Rread is decoded as rread.
+func (r *rreadFilePayload) decode(b *buffer) {
+	b.markOverrun()
+}
+
+// encode implements encoder.encode.
+//
+// Data is written via FilePayload.
+func (r *rreadFilePayload) encode(b *buffer) {
+	b.Write32(uint32(r.payload.Len()))
+}
+
+// typ implements message.typ.
+func (*rreadFilePayload) typ() msgType {
+	return msgRread
+}
+
+// FilePayload implements filePayloader.FilePayload.
+func (r *rreadFilePayload) FilePayload() Payload {
+	return r.payload
+}
+
+// String implements fmt.Stringer.
+func (r *rreadFilePayload) String() string {
+	return fmt.Sprintf("Rread{len(Data): %d}", r.payload.Len())
+}
+
 // twrite is a write request.
 type twrite struct {
 	// fid is the fid to read.
@@ -2043,6 +2111,73 @@ func (r *rusymlink) String() string {
 	return fmt.Sprintf("Rusymlink{%v}", &r.rsymlink)
 }
 
+// tchannel is a request to move the connection to shared memory.
+type tchannel struct {
+	// Size is the requested size of each of the connection's rings, or
+	// 0 for the server's default.
+	Size uint32
+}
+
+// decode implements encoder.decode.
+func (t *tchannel) decode(b *buffer) {
+	t.Size = b.Read32()
+}
+
+// encode implements encoder.encode.
+func (t *tchannel) encode(b *buffer) {
+	b.Write32(t.Size)
+}
+
+// typ implements message.typ.
+func (*tchannel) typ() msgType {
+	return msgTchannel
+}
+
+// String implements fmt.Stringer.
+func (t *tchannel) String() string {
+	return fmt.Sprintf("Tchannel{Size: %d}", t.Size)
+}
+
+// rchannel is a channel response. The connection's memory file is sent
+// alongside the message, after which both ends use shared memory.
+type rchannel struct {
+	// Size is the size of each of the connection's rings.
+	Size uint32
+
+	// fd is the connection's memory file.
+	fd *os.File
+}
+
+// decode implements encoder.decode.
+func (r *rchannel) decode(b *buffer) {
+	r.Size = b.Read32()
+}
+
+// encode implements encoder.encode.
+func (r *rchannel) encode(b *buffer) {
+	b.Write32(r.Size)
+}
+
+// typ implements message.typ.
+func (*rchannel) typ() msgType {
+	return msgRchannel
+}
+
+// String implements fmt.Stringer.
+func (r *rchannel) String() string {
+	return fmt.Sprintf("Rchannel{Size: %d}", r.Size)
+}
+
+// donatedFD implements fdCarrier.donatedFD.
+func (r *rchannel) donatedFD() *os.File {
+	return r.fd
+}
+
+// setDonatedFD implements fdCarrier.setDonatedFD.
+func (r *rchannel) setDonatedFD(f *os.File) {
+	r.fd = f
+}
+
 // LockType is lock type for Tlock
 type LockType uint8
 
@@ -2182,18 +2317,110 @@ func (r *rlock) String() string {
 	return fmt.Sprintf("Rlock{Status: %s}", r.Status)
 }
 
-// Let's wait until we need this? POSIX locks over a network make 0 sense.
-// getlock - test for the existence of a POSIX record lock
+// LockInfo describes a POSIX record lock, as returned by LockGetter.GetLock.
+type LockInfo struct {
+	// Type is the type of the lock, or Unlock if there is none.
+	Type LockType
+
+	// Start and Length are the locked region. A Length of 0 means the
+	// region extends to the end of the file.
+	Start  uint64
+	Length uint64
+
+	// PID is the process holding the lock, or -1 if it is not known.
+	PID int32
+
+	// Client identifies the lock holder, if known.
+	Client string
+}
+
+// String implements fmt.Stringer.
+func (l LockInfo) String() string {
+	return fmt.Sprintf("LockInfo{Type: %s, Start: %d, Length: %d, PID: %d, Client: %s}", l.Type, l.Start, l.Length, l.PID, l.Client)
+}
+
+// decode implements encoder.decode.
+func (l *LockInfo) decode(b *buffer) {
+	l.Type = LockType(b.Read8())
+	l.Start = b.Read64()
+	l.Length = b.Read64()
+	l.PID = int32(b.Read32())
+	l.Client = b.ReadString()
+}
+
+// encode implements encoder.encode.
+func (l *LockInfo) encode(b *buffer) {
+	b.Write8(uint8(l.Type))
+	b.Write64(l.Start)
+	b.Write64(l.Length)
+	b.Write32(uint32(l.PID))
+	b.WriteString(l.Client)
+}
+
+// tgetlock is a Tgetlock message.
+//
+// getlock tests for the existence of a POSIX record lock and has semantics
+// similar to Linux fcntl(F_GETLK).
+//
 // size[4] Tgetlock tag[2] fid[4] type[1] start[8] length[8] proc_id[4] client_id[s]
+type tgetlock struct {
+	// fid is the fid to query.
+	fid fid
+
+	// LockInfo is the lock that would be placed.
+	LockInfo
+}
+
+// decode implements encoder.decode.
+func (t *tgetlock) decode(b *buffer) {
+	t.fid = b.ReadFID()
+	t.LockInfo.decode(b)
+}
+
+// encode implements encoder.encode.
+func (t *tgetlock) encode(b *buffer) {
+	b.WriteFID(t.fid)
+	t.LockInfo.encode(b)
+}
+
+// typ implements message.typ.
+func (*tgetlock) typ() msgType {
+	return msgTgetlock
+}
+
+// String implements fmt.Stringer.
+func (t *tgetlock) String() string {
+	return fmt.Sprintf("Tgetlock{FID: %d, %v}", t.fid, t.LockInfo)
+}
+
+// rgetlock is a getlock response.
+//
 // size[4] Rgetlock tag[2] type[1] start[8] length[8] proc_id[4] client_id[s]
-// getlock tests for the existence of a POSIX record lock and has semantics similar to Linux fcntl(F_GETLK).
+type rgetlock struct {
+	// LockInfo is a conflicting lock, or the requested lock with Type
+	// Unlock if there is none.
+	LockInfo
+}
 
-// As with lock, type has one of the values defined above, and start,
-// length, and proc_id correspond to the analogous fields in struct
-// flock passed to Linux fcntl(F_GETLK), and client_Id is an
-// additional mechanism for uniquely identifying the lock requester
-// and is set to the nodename by the Linux v9fs client.  tusymlink is
-// a Tsymlink message that includes a UID.
+// decode implements encoder.decode.
+func (r *rgetlock) decode(b *buffer) {
+	r.LockInfo.decode(b)
+}
+
+// encode implements encoder.encode.
+func (r *rgetlock) encode(b *buffer) {
+	r.LockInfo.encode(b)
+}
+
+// typ implements message.typ.
+func (*rgetlock) typ() msgType {
+	return msgRgetlock
+}
+
+// String implements fmt.Stringer.
+func (r *rgetlock) String() string {
+	return fmt.Sprintf("Rgetlock{%v}", r.LockInfo)
+}
 
 /// END LOCK
 
@@ -2311,6 +2538,8 @@ func init() {
 	msgDotLRegistry.register(msgRlink, func() message { return &rlink{} })
 	msgDotLRegistry.register(msgTlock, func() message { return &tlock{} })
 	msgDotLRegistry.register(msgRlock, func() message { return &rlock{} })
+	msgDotLRegistry.register(msgTgetlock, func() message { return &tgetlock{} })
+	msgDotLRegistry.register(msgRgetlock, func() message { return &rgetlock{} })
 	msgDotLRegistry.register(msgTmkdir, func() message { return &tmkdir{} })
 	msgDotLRegistry.register(msgRmkdir, func() message { return &rmkdir{} })
 	msgDotLRegistry.register(msgTrenameat, func() message { return &trenameat{} })
@@ -2345,4 +2574,6 @@ func init() {
 	msgDotLRegistry.register(msgRumknod, func() message { return &rumknod{} })
 	msgDotLRegistry.register(msgTusymlink, func() message { return &tusymlink{} })
 	msgDotLRegistry.register(msgRusymlink, func() message { return &rusymlink{} })
+	msgDotLRegistry.register(msgTchannel, func() message { return &tchannel{} })
+	msgDotLRegistry.register(msgRchannel, func() message { return &rchannel{} })
 }
@@ -354,6 +354,8 @@ func TestEncodeDecode(t *testing.T) {
 		&rumknod{
 			rmknod{QID: QID{Type: 1}},
 		},
+		&tchannel{Size: 1 << 20},
+		&rchannel{Size: 1 << 20},
 		&tlock{
 			Type:   0x5,
 			Flags:  0xaabbccdd,
@@ -365,6 +367,25 @@ func TestEncodeDecode(t *testing.T) {
 		&rlock{
 			Status: 0x54,
 		},
+		&tgetlock{
+			fid: 1,
+			LockInfo: LockInfo{
+				Type:   WriteLock,
+				Start:  0x67893456,
+				Length: 0x33333333,
+				PID:    0x9876,
+				Client: "client",
+			},
+		},
+		&rgetlock{
+			LockInfo: LockInfo{
+				Type:   ReadLock,
+				Start:  0x1,
+				Length: 0x2,
+				PID:    -1,
+				Client: "holder",
+			},
+		},
 	}
 
 	for _, enc := range objs {
@@ -408,6 +408,8 @@ const (
 	msgRumknod      msgType = 133
 	msgTusymlink    msgType = 134
 	msgRusymlink    msgType = 135
+	msgTchannel     msgType = 136
+	msgRchannel     msgType = 137
 )
 
 // QIDType represents the file type for QIDs.
@@ -471,7 +473,13 @@ type QID struct {
 	// Type is the highest order byte of the file mode.
 	Type QIDType
 
-	// Version is an arbitrary server version number.
+	// Version is a server version number for the file's current state.
+	//
+	// A file server that tracks changes returns a different Version
+	// whenever the file's data or attributes change, so clients may cache
+	// both for as long as the Version they see stays the same. File
+	// servers that do not track changes (e.g. for immutable files) return
+	// the same Version for the life of the file, usually 0.
 	Version uint32
 
 	// Path is a unique server identifier for this path (e.g. inode).
@@ -507,9 +515,8 @@ type QIDGenerator struct {
 
 // Get returns a new 9P unique ID with a unique Path given a QID type.
 //
-// While the 9P spec allows Version to be incremented every time the file is
-// modified, we currently do not use the Version member for anything.  Hence,
-// it is set to 0.
+// Version starts at 0. File servers that allow the file to be modified
+// should increment it on every write or SetAttr; see QID.Version.
 func (q *QIDGenerator) Get(t QIDType) QID {
 	return QID{
 		Type:    t,
@@ -1159,6 +1166,10 @@ func (d Dirents) Find(name string) *Dirent {
 // Dirent represents a directory entry in File.Readdir.
 type Dirent struct {
 	// QID is the entry QID.
+	//
+	// Its Version may be 0, as file servers may not know the entry's
+	// version without looking it up. Clients should get it by walking to
+	// the entry.
 	QID QID
 
 	// Offset is the offset in the directory.
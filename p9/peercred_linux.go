@@ -0,0 +1,40 @@
+package p9
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// PeerCredentials returns the credentials of the process at the other end of
+// conn, which must be a unix socket, as of when the connection was made. It
+// is meant to be used to select attachers WithConnAttacher.
+//
+// PeerCredentials is only supported on Linux.
+func PeerCredentials(conn net.Conn) (PeerCred, error) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCred{}, fmt.Errorf("p9: peer credentials of %T: not a unix socket", conn)
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return PeerCred{}, err
+	}
+	var (
+		cred *syscall.Ucred
+		cerr error
+	)
+	if err := rc.Control(func(fd uintptr) {
+		cred, cerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return PeerCred{}, err
+	}
+	if cerr != nil {
+		return PeerCred{}, fmt.Errorf("p9: getting SO_PEERCRED: %w", cerr)
+	}
+	return PeerCred{PID: cred.Pid, UID: UID(cred.Uid), GID: GID(cred.Gid)}, nil
+}
@@ -0,0 +1,31 @@
+package p9_test
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/hugelgupf/p9/p9"
+)
+
+func TestPeerCredentials(t *testing.T) {
+	srv, cli := socketPair(t)
+	defer srv.Close()
+	defer cli.Close()
+
+	got, err := p9.PeerCredentials(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := p9.PeerCred{PID: int32(os.Getpid()), UID: p9.UID(os.Getuid()), GID: p9.GID(os.Getgid())}
+	if got != want {
+		t.Errorf("PeerCredentials = %+v, want %+v", got, want)
+	}
+
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	if _, err := p9.PeerCredentials(p1); err == nil {
+		t.Errorf("PeerCredentials(pipe) = nil, want error")
+	}
+}
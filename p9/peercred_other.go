@@ -0,0 +1,13 @@
+//go:build !linux
+
+package p9
+
+import (
+	"errors"
+	"net"
+)
+
+// PeerCredentials is not supported.
+func PeerCredentials(net.Conn) (PeerCred, error) {
+	return PeerCred{}, errors.ErrUnsupported
+}
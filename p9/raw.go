@@ -0,0 +1,186 @@
+package p9
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hugelgupf/p9/internal/rawmsg"
+	"github.com/hugelgupf/p9/linux"
+)
+
+// rawMessage is a T-message sent by Client.SendRaw.
+type rawMessage struct {
+	// args describes the arguments.
+	args string
+
+	// minArgs and maxArgs are the number of arguments, with maxArgs -1
+	// if there is no limit.
+	minArgs, maxArgs int
+
+	// build returns the T-message and the R-message to receive for it.
+	build func(a *rawArgs) (message, message)
+}
+
+var rawMessages = map[string]rawMessage{
+	"attach": {"fid [aname [uname [uid]]]", 1, 4, func(a *rawArgs) (message, message) {
+		return &tattach{fid: a.fid(0), Auth: tauth{
+			Authenticationfid: noFID,
+			AttachName:        a.str(1),
+			UserName:          a.str(2),
+			UID:               UID(a.uint(3, 32, uint64(NoUID))),
+		}}, &rattach{}
+	}},
+	"walk": {"fid newfid [name...]", 2, -1, func(a *rawArgs) (message, message) {
+		return &twalk{fid: a.fid(0), newFID: a.fid(1), Names: a.rest(2)}, &rwalk{}
+	}},
+	"getattr": {"fid", 1, 1, func(a *rawArgs) (message, message) {
+		return &tgetattr{fid: a.fid(0), AttrMask: AttrMaskAll}, &rgetattr{}
+	}},
+	"open": {"fid flags", 2, 2, func(a *rawArgs) (message, message) {
+		return &tlopen{fid: a.fid(0), Flags: OpenFlags(a.uint(1, 32, 0))}, &rlopen{}
+	}},
+	"create": {"fid name flags mode [gid]", 4, 5, func(a *rawArgs) (message, message) {
+		return &tlcreate{
+			fid:         a.fid(0),
+			Name:        a.str(1),
+			OpenFlags:   OpenFlags(a.uint(2, 32, 0)),
+			Permissions: FileMode(a.uint(3, 32, 0)),
+			GID:         GID(a.uint(4, 32, uint64(NoGID))),
+		}, &rlcreate{}
+	}},
+	"read": {"fid offset count", 3, 3, func(a *rawArgs) (message, message) {
+		return &tread{fid: a.fid(0), Offset: a.uint(1, 64, 0), Count: uint32(a.uint(2, 32, 0))}, &rread{}
+	}},
+	"write": {"fid offset [data...]", 2, -1, func(a *rawArgs) (message, message) {
+		return &twrite{fid: a.fid(0), Offset: a.uint(1, 64, 0), Data: []byte(strings.Join(a.rest(2), " "))}, &rwrite{}
+	}},
+	"readdir": {"fid offset count", 3, 3, func(a *rawArgs) (message, message) {
+		return &treaddir{Directory: a.fid(0), Offset: a.uint(1, 64, 0), Count: uint32(a.uint(2, 32, 0))}, &rreaddir{}
+	}},
+	"readlink": {"fid", 1, 1, func(a *rawArgs) (message, message) {
+		return &treadlink{fid: a.fid(0)}, &rreadlink{}
+	}},
+	"mkdir": {"fid name mode [gid]", 3, 4, func(a *rawArgs) (message, message) {
+		return &tmkdir{
+			Directory:   a.fid(0),
+			Name:        a.str(1),
+			Permissions: FileMode(a.uint(2, 32, 0)),
+			GID:         GID(a.uint(3, 32, uint64(NoGID))),
+		}, &rmkdir{}
+	}},
+	"unlinkat": {"fid name [flags]", 2, 3, func(a *rawArgs) (message, message) {
+		return &tunlinkat{Directory: a.fid(0), Name: a.str(1), Flags: uint32(a.uint(2, 32, 0))}, &runlinkat{}
+	}},
+	"statfs": {"fid", 1, 1, func(a *rawArgs) (message, message) {
+		return &tstatfs{fid: a.fid(0)}, &rstatfs{}
+	}},
+	"clunk": {"fid", 1, 1, func(a *rawArgs) (message, message) {
+		return &tclunk{fid: a.fid(0)}, &rclunk{}
+	}},
+	"remove": {"fid", 1, 1, func(a *rawArgs) (message, message) {
+		return &tremove{fid: a.fid(0)}, &rremove{}
+	}},
+	"flush": {"oldtag", 1, 1, func(a *rawArgs) (message, message) {
+		return &tflush{OldTag: tag(a.uint(0, 16, 0))}, &rflush{}
+	}},
+}
+
+// rawArgs are the arguments of a raw message. The first argument that is not
+// a valid number is kept in err.
+type rawArgs struct {
+	args []string
+	err  error
+}
+
+// str returns argument i, or "" if it is not given.
+func (a *rawArgs) str(i int) string {
+	if i >= len(a.args) {
+		return ""
+	}
+	return a.args[i]
+}
+
+// rest returns the arguments from i on.
+func (a *rawArgs) rest(i int) []string {
+	if i >= len(a.args) {
+		return nil
+	}
+	return a.args[i:]
+}
+
+// uint returns argument i as a number of the given size in bits, or def if
+// it is not given.
+func (a *rawArgs) uint(i int, bits int, def uint64) uint64 {
+	if i >= len(a.args) {
+		return def
+	}
+	v, err := strconv.ParseUint(a.args[i], 0, bits)
+	if err != nil && a.err == nil {
+		a.err = fmt.Errorf("argument %d: %w", i+1, err)
+	}
+	return v
+}
+
+// fid returns argument i as a fid.
+func (a *rawArgs) fid(i int) fid {
+	return fid(a.uint(i, 32, uint64(noFID)))
+}
+
+// RawMessages returns the T-messages that Client.SendRaw sends, each with a
+// description of its arguments, sorted by name.
+//
+// It is internal to this module, see package rawmsg.
+func RawMessages(rawmsg.Token) []string {
+	var msgs []string
+	for name, m := range rawMessages {
+		msgs = append(msgs, name+" "+m.args)
+	}
+	sort.Strings(msgs)
+	return msgs
+}
+
+// SendRaw sends a single T-message on c and returns the R-message received
+// for it, as printed in the client's log. The message is given as its name
+// and arguments separated by spaces, e.g. "walk 1 2 usr bin". Numbers are
+// decimal, or octal or hex with a 0 or 0x prefix.
+//
+// Errors of the server are returned as Rlerror messages; the error is only
+// set if the message is invalid or the connection fails.
+//
+// Fids are used as given, without being allocated from the client. Files of
+// the client use fids counting up from 1, so raw messages should use fids
+// that are out of their way.
+//
+// It is internal to this module, see package rawmsg.
+func (c *Client) SendRaw(_ rawmsg.Token, line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", errors.New("p9: no message given")
+	}
+	m, ok := rawMessages[fields[0]]
+	if !ok {
+		return "", fmt.Errorf("p9: unknown message %q", fields[0])
+	}
+	a := &rawArgs{args: fields[1:]}
+	if len(a.args) < m.minArgs || (m.maxArgs >= 0 && len(a.args) > m.maxArgs) {
+		return "", fmt.Errorf("p9: usage: %s %s", fields[0], m.args)
+	}
+	tm, rm := m.build(a)
+	if a.err != nil {
+		return "", fmt.Errorf("p9: %s: %w", fields[0], a.err)
+	}
+
+	err := c.sendRecv(tm, rm)
+	if errno, ok := err.(linux.Errno); ok {
+		return (&rlerror{Error: uint32(errno)}).String(), nil
+	} else if err != nil {
+		return "", err
+	}
+	if r, ok := rm.(*rread); ok {
+		return fmt.Sprintf("%v %q", r, r.Data), nil
+	}
+	return fmt.Sprint(rm), nil
+}
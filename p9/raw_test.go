@@ -0,0 +1,61 @@
+package p9_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hugelgupf/p9/internal/rawmsg"
+	"github.com/hugelgupf/p9/p9"
+)
+
+func TestRaw(t *testing.T) {
+	srv, cli := net.Pipe()
+	s := p9.NewServer(readToAttacher{&readToFile{content: []byte("hello")}})
+	done := make(chan struct{})
+	go func() {
+		_ = s.Handle(srv, srv)
+		close(done)
+	}()
+	defer func() {
+		cli.Close()
+		<-done
+	}()
+
+	c, err := p9.NewClient(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		msg  string
+		want string
+	}{
+		{"attach 100", "Rattach{"},
+		{"walk 100 101 file", "Rwalk{QIDs: [QID{Type: 0, Version: 0, Path: 2}]}"},
+		{"open 101 0", "Rlopen{"},
+		{"read 101 1 0x10", `Rread{len(Data): 4} "ello"`},
+		{"clunk 101", "Rclunk{}"},
+		{"clunk 101", "Rlerror{Error: 9}"},
+		{"flush 7", "Rflush{}"},
+		// The client is idle, so the flush itself has tag 1.
+		{"flush 1", "Rflush{}"},
+	} {
+		got, err := c.SendRaw(rawmsg.Token{}, tt.msg)
+		if err != nil || !strings.HasPrefix(got, tt.want) {
+			t.Errorf("SendRaw(%q) = %q, %v, want %s...", tt.msg, got, err, tt.want)
+		}
+	}
+
+	for _, msg := range []string{
+		"",
+		"version 8192 9P2000.L",
+		"walk 100",
+		"clunk 100 101",
+		"clunk foo",
+		"flush 65536",
+	} {
+		if got, err := c.SendRaw(rawmsg.Token{}, msg); err == nil {
+			t.Errorf("SendRaw(%q) = %q, want error", msg, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package p9_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/hugelgupf/p9/fsimpl/templatefs"
+	"github.com/hugelgupf/p9/p9"
+)
+
+// bytesPayload is a p9.Payload of in-memory data.
+type bytesPayload struct {
+	data   []byte
+	closed chan struct{}
+}
+
+func (p *bytesPayload) Len() int { return len(p.data) }
+
+func (p *bytesPayload) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(p.data)
+	return int64(n), err
+}
+
+func (p *bytesPayload) Close() error {
+	close(p.closed)
+	return nil
+}
+
+// readToFile is a file with the given content that implements p9.ReaderTo
+// if readTo is set.
+type readToFile struct {
+	templatefs.NoopFile
+
+	content []byte
+	readTo  bool
+	closed  chan struct{}
+}
+
+func (f *readToFile) Walk(names []string) ([]p9.QID, p9.File, error) {
+	return []p9.QID{{Path: 2}}, f, nil
+}
+
+func (f *readToFile) GetAttr(p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	return p9.QID{Type: p9.TypeDir, Path: 1}, p9.AttrMask{Mode: true}, p9.Attr{Mode: p9.ModeDirectory | 0o755}, nil
+}
+
+func (f *readToFile) Open(p9.OpenFlags) (p9.QID, uint32, error) {
+	return p9.QID{Path: 2}, 0, nil
+}
+
+func (f *readToFile) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= int64(len(f.content)) {
+		return 0, io.EOF
+	}
+	return copy(p, f.content[offset:]), nil
+}
+
+func (f *readToFile) ReadTo(w io.Writer, count uint32, offset int64) (p9.Payload, error) {
+	if !f.readTo {
+		return nil, errors.ErrUnsupported
+	}
+	end := min(offset+int64(count), int64(len(f.content)))
+	return &bytesPayload{data: f.content[offset:end], closed: f.closed}, nil
+}
+
+type readToAttacher struct {
+	f *readToFile
+}
+
+func (a readToAttacher) Attach() (p9.File, error) {
+	return a.f, nil
+}
+
+func TestReadTo(t *testing.T) {
+	for _, readTo := range []bool{true, false} {
+		srv, cli := net.Pipe()
+		f := &readToFile{
+			content: []byte("hello, world"),
+			readTo:  readTo,
+			closed:  make(chan struct{}),
+		}
+		s := p9.NewServer(readToAttacher{f})
+		done := make(chan struct{})
+		go func() {
+			_ = s.Handle(srv, srv)
+			close(done)
+		}()
+
+		c, err := p9.NewClient(cli)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := c.Attach("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, file, err := root.Walk([]string{"file"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := file.Open(p9.ReadOnly); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if n, err := file.ReadAt(buf, 7); err != nil || !bytes.Equal(buf[:n], []byte("world")) {
+			t.Errorf("ReadTo %t: ReadAt = %q, %v, want world", readTo, buf[:n], err)
+		}
+		// The connection is still in sync after the payload.
+		if _, _, _, err := file.GetAttr(p9.AttrMaskAll); err != nil {
+			t.Errorf("ReadTo %t: GetAttr = %v", readTo, err)
+		}
+		if readTo {
+			<-f.closed
+		}
+
+		cli.Close()
+		srv.Close()
+		<-done
+	}
+}
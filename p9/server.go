@@ -16,10 +16,13 @@ package p9
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"reflect"
 	"runtime/debug"
 	"strings"
 	"sync"
@@ -34,12 +37,20 @@ type Server struct {
 	// attacher provides the attach function.
 	attacher Attacher
 
-	// pathTree is the full set of paths opened on this server.
+	// connAttacher returns the attacher of each connection, if set.
+	connAttacher func(net.Conn) (Attacher, error)
+
+	// trees are the paths opened through attacher.
 	//
 	// These may be across different connections, but rename operations
-	// must be serialized globally for safely. There is a single pathTree
-	// for the entire server, and not per connection.
-	pathTree *pathNode
+	// must be serialized globally for safely. There is a single set of
+	// trees for each attacher, and not per connection.
+	trees *pathTrees
+
+	// connTrees are the paths opened through the attachers returned by
+	// connAttacher, while connections use them.
+	connTreesMu sync.Mutex
+	connTrees   map[Attacher]*pathTrees
 
 	// renameMu is a global lock protecting rename operations. With this
 	// lock, we can be certain that any given rename operation can safely
@@ -49,6 +60,12 @@ type Server struct {
 
 	// log is a logger to log to, if specified.
 	log ulog.Logger
+
+	// donateFDs is true if host file descriptors are donated to clients.
+	donateFDs bool
+
+	// channels is true if clients may move connections to shared memory.
+	channels bool
 }
 
 // ServerOpt is an optional config for a new server.
@@ -61,11 +78,60 @@ func WithServerLogger(l ulog.Logger) ServerOpt {
 	}
 }
 
+// WithFDDonation donates the host file descriptors of opened and created
+// files that implement HostFile to clients on unix sockets, so that clients
+// can read and write them directly. Clients must negotiate a protocol
+// version that supports receiving them.
+//
+// Clients can use the file descriptors to bypass the checks of the server
+// and of wrapping attachers, so they should only be donated to trusted
+// clients.
+func WithFDDonation() ServerOpt {
+	return func(s *Server) {
+		s.donateFDs = true
+	}
+}
+
+// WithServerSharedMemory allows clients on unix sockets to move their
+// connection to shared memory with the other end, if they negotiate a
+// protocol version that supports it. The socket is then only used to find
+// out when the client goes away.
+//
+// Shared memory is only supported on Linux.
+func WithServerSharedMemory() ServerOpt {
+	return func(s *Server) {
+		s.channels = true
+	}
+}
+
+// WithConnAttacher selects the attacher of each connection with f, which is
+// called with the connection before any request is handled, e.g. to give
+// clients different trees by the credentials of the peer of a unix socket
+// (see PeerCredentials), the remote address of a TCP connection, or the
+// certificate of a TLS connection. The TLS handshake is completed before f is
+// called.
+//
+// If f returns an error, the connection is closed. If it returns a nil
+// Attacher, the attacher given to NewServer is used. Connections not served
+// on a net.Conn always use the attacher given to NewServer.
+func WithConnAttacher(f func(net.Conn) (Attacher, error)) ServerOpt {
+	return func(s *Server) {
+		s.connAttacher = f
+	}
+}
+
+// PeerCred is the identity of the process at the other end of a unix socket.
+type PeerCred struct {
+	PID int32
+	UID UID
+	GID GID
+}
+
 // NewServer returns a new server.
 func NewServer(attacher Attacher, o ...ServerOpt) *Server {
 	s := &Server{
 		attacher: attacher,
-		pathTree: newPathNode(),
+		trees:    newPathTrees(),
 		log:      ulog.Null,
 	}
 	for _, opt := range o {
@@ -74,6 +140,80 @@ func NewServer(attacher Attacher, o ...ServerOpt) *Server {
 	return s
 }
 
+// pathTrees are the paths opened through a single attacher.
+type pathTrees struct {
+	// root is the tree of the attacher's root.
+	root *pathNode
+
+	// exports are the trees of each export of an ExportAttacher, by export
+	// name. Different exports are different file systems, so they do not
+	// share paths.
+	exportsMu sync.Mutex
+	exports   map[string]*pathNode
+
+	// conns is the number of connections using the trees, protected by
+	// Server.connTreesMu.
+	conns int
+}
+
+func newPathTrees() *pathTrees {
+	return &pathTrees{root: newPathNode()}
+}
+
+// export returns the tree of the named export.
+func (t *pathTrees) export(name string) *pathNode {
+	t.exportsMu.Lock()
+	defer t.exportsMu.Unlock()
+	pn, ok := t.exports[name]
+	if !ok {
+		if t.exports == nil {
+			t.exports = make(map[string]*pathNode)
+		}
+		pn = newPathNode()
+		t.exports[name] = pn
+	}
+	return pn
+}
+
+// connAttach returns the attacher for conn and the trees of its paths, and a
+// function that releases the trees once the connection is done.
+func (s *Server) connAttach(conn net.Conn) (Attacher, *pathTrees, func(), error) {
+	a, err := s.connAttacher(conn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if a == nil {
+		return s.attacher, s.trees, func() {}, nil
+	}
+	// Connections share the trees of the same attacher. Attachers that
+	// cannot be compared get trees of their own.
+	if !reflect.TypeOf(a).Comparable() {
+		return a, newPathTrees(), func() {}, nil
+	}
+	if reflect.TypeOf(a) == reflect.TypeOf(s.attacher) && a == s.attacher {
+		return a, s.trees, func() {}, nil
+	}
+
+	s.connTreesMu.Lock()
+	defer s.connTreesMu.Unlock()
+	t, ok := s.connTrees[a]
+	if !ok {
+		if s.connTrees == nil {
+			s.connTrees = make(map[Attacher]*pathTrees)
+		}
+		t = newPathTrees()
+		s.connTrees[a] = t
+	}
+	t.conns++
+	return a, t, func() {
+		s.connTreesMu.Lock()
+		defer s.connTreesMu.Unlock()
+		if t.conns--; t.conns == 0 {
+			delete(s.connTrees, a)
+		}
+	}, nil
+}
+
 // connState is the state for a single connection.
 type connState struct {
 	// server is the backing server.
@@ -105,6 +245,25 @@ type connState struct {
 	// version 0 implies 9P2000.L.
 	version uint32
 
+	// attacher attaches the connection, and trees are the paths opened
+	// through it. releaseTrees is called once the connection is done, if
+	// set.
+	attacher     Attacher
+	trees        *pathTrees
+	releaseTrees func()
+
+	// certUser is the user named by the client's verified TLS certificate,
+	// if any.
+	certUser string
+
+	// sock is the unix socket that the connection is served on, if any.
+	sock *net.UnixConn
+
+	// channel is non-zero once the connection moved to shared memory,
+	// after which t and r are no longer sock. channel is accessed using
+	// atomic memory operations.
+	channel uint32
+
 	// pendingWg counts requests that are still being handled.
 	pendingWg sync.WaitGroup
 
@@ -122,6 +281,11 @@ type connState struct {
 	// by recvMu.
 	recvShutdown bool
 
+	// inflight is the number of requests received and not yet answered.
+	// It is only incremented with recvMu held and decremented with sendMu
+	// held, and accessed using atomic memory operations.
+	inflight int32
+
 	// sendMu serializes sending to r.
 	sendMu sync.Mutex
 
@@ -380,6 +544,33 @@ func (f *fidRef) safelyGlobal(fn func() error) (err error) {
 	return fn()
 }
 
+// donateFD returns a duplicate of the host file descriptor of f to send to
+// the client, or nil if none is donated.
+func (cs *connState) donateFD(f File) *os.File {
+	if !cs.server.donateFDs || !versionSupportsFDDonation(atomic.LoadUint32(&cs.version)) {
+		return nil
+	}
+	// File descriptors cannot be sent over shared memory.
+	if cs.sock == nil || atomic.LoadUint32(&cs.channel) != 0 {
+		return nil
+	}
+	hf, ok := f.(HostFile)
+	if !ok {
+		return nil
+	}
+	fd, ok := hf.FD()
+	if !ok {
+		return nil
+	}
+	// The file may be closed before the message is sent.
+	dup, err := dupFile(fd)
+	if err != nil {
+		cs.server.log.Printf("p9: not donating file descriptor: %v", err)
+		return nil
+	}
+	return dup
+}
+
 // Lookupfid finds the given fid.
 //
 // You should call fid.DecRef when you are finished using the fid.
@@ -503,6 +694,16 @@ func (cs *connState) handleRequest() bool {
 		return false
 	}
 
+	// Moving the connection to shared memory replaces cs.t, so no other
+	// goroutine may receive until it is done.
+	if t, ok := m.(*tchannel); ok {
+		cs.handleChannel(tag, t)
+		cs.recvMu.Unlock()
+		msgDotLRegistry.put(m)
+		return true
+	}
+	atomic.AddInt32(&cs.inflight, 1)
+
 	// Ensure that another goroutine is available to receive from cs.t.
 	if atomic.LoadInt32(&cs.recvIdle) == 0 {
 		cs.pendingWg.Add(1)
@@ -518,6 +719,7 @@ func (cs *connState) handleRequest() bool {
 		// If it's not a connection error, but some other protocol error,
 		// we can send a response immediately.
 		cs.sendMu.Lock()
+		atomic.AddInt32(&cs.inflight, -1)
 		err := send(cs.server.log, cs.r, tag, newErr(err))
 		cs.sendMu.Unlock()
 		if err != nil {
@@ -529,20 +731,29 @@ func (cs *connState) handleRequest() bool {
 	// Try to start the tag.
 	if !cs.StartTag(tag) {
 		cs.server.log.Printf("no valid tag [%05d]", tag)
+		atomic.AddInt32(&cs.inflight, -1)
 		// Nothing we can do at this point; client is bogus.
 		return true
 	}
 
-	// Handle the message.
-	r := cs.handle(m)
+	// Handle the message. A flush of its own tag would wait for itself.
+	var r message
+	if f, ok := m.(*tflush); ok && f.OldTag == tag {
+		r = &rflush{}
+	} else {
+		r = cs.handle(m)
+	}
 
 	// Clear the tag before sending. That's because as soon as this
 	// hits the wire, the client can legally send another message
 	// with the same tag.
 	cs.ClearTag(tag)
 
-	// Send back the result.
+	// Send back the result. The request stops being in flight before the
+	// client can see the reply, which is sent before any move to shared
+	// memory as that needs sendMu.
 	cs.sendMu.Lock()
+	atomic.AddInt32(&cs.inflight, -1)
 	err = send(cs.server.log, cs.r, tag, r)
 	cs.sendMu.Unlock()
 	if err != nil {
@@ -604,19 +815,54 @@ func (cs *connState) stop() {
 		// handlers running via the wait for Pending => 0 below.
 		fidRef.DecRef()
 	}
+	if cs.releaseTrees != nil {
+		cs.releaseTrees()
+	}
 }
 
 // Handle handles a single connection.
+//
+// If t is a *tls.Conn, the TLS handshake is completed first. Clients that
+// presented a verified certificate attach as the user named by its subject's
+// common name, instead of the user they give in Tattach.
+//
+// If t is a net.Conn and the server was created WithConnAttacher, the
+// connection is attached by the attacher selected for it.
 func (s *Server) Handle(t io.ReadCloser, r io.WriteCloser) error {
 	cs := &connState{
-		server: s,
-		t:      t,
-		r:      r,
-		fids:   make(map[fid]*fidRef),
-		tags:   make(map[tag]chan struct{}),
+		server:   s,
+		attacher: s.attacher,
+		trees:    s.trees,
+		t:        t,
+		r:        r,
+		fids:     make(map[fid]*fidRef),
+		tags:     make(map[tag]chan struct{}),
 	}
 	defer cs.stop()
 
+	if tu, ok := t.(*net.UnixConn); ok {
+		if ru, ok := r.(*net.UnixConn); ok && tu == ru {
+			cs.sock = tu
+		}
+	}
+
+	if tc, ok := t.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			s.log.Printf("p9: TLS handshake: %v", err)
+			return err
+		}
+		cs.certUser = certUser(tc.ConnectionState())
+	}
+
+	if conn, ok := t.(net.Conn); ok && s.connAttacher != nil {
+		a, trees, release, err := s.connAttach(conn)
+		if err != nil {
+			s.log.Printf("p9: selecting attacher: %v", err)
+			return err
+		}
+		cs.attacher, cs.trees, cs.releaseTrees = a, trees, release
+	}
+
 	// Serve requests from t in the current goroutine; handleRequests()
 	// will create more goroutines as needed.
 	cs.handleRequests()
@@ -0,0 +1,113 @@
+package p9_test
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/hugelgupf/p9/p9"
+	"github.com/hugelgupf/p9/shm"
+)
+
+// socketPair returns a connected pair of unix sockets.
+func socketPair(tb testing.TB) (net.Conn, net.Conn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	conn := func(fd int) net.Conn {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		defer f.Close()
+		c, err := net.FileConn(f)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return c
+	}
+	return conn(fds[0]), conn(fds[1])
+}
+
+// openFile serves content over a unix socket, and returns the opened file
+// and a function that closes the connection.
+func openFile(tb testing.TB, content []byte, sharedMemory bool) (p9.File, func()) {
+	srv, cli := socketPair(tb)
+	s := p9.NewServer(readToAttacher{&readToFile{content: content}}, p9.WithServerSharedMemory())
+	done := make(chan struct{})
+	go func() {
+		_ = s.Handle(srv, srv)
+		close(done)
+	}()
+
+	opts := []p9.ClientOpt{p9.WithMessageSize(1 << 20)}
+	if sharedMemory {
+		opts = append(opts, p9.WithClientSharedMemory(0))
+	}
+	c, err := p9.NewClient(cli, opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	root, err := c.Attach("")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	_, f, err := root.Walk([]string{"file"})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, _, err := f.Open(p9.ReadOnly); err != nil {
+		tb.Fatal(err)
+	}
+	return f, func() {
+		c.Close()
+		<-done
+	}
+}
+
+func TestSharedMemoryReadAt(t *testing.T) {
+	// Larger than the default rings.
+	content := make([]byte, 3*shm.DefaultSize)
+	rand.New(rand.NewSource(1)).Read(content)
+	f, done := openFile(t, content, true)
+	defer done()
+
+	got := make([]byte, 0, len(content))
+	buf := make([]byte, 1<<20)
+	for len(got) < len(content) {
+		n, err := f.ReadAt(buf, int64(len(got)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("read data differs from file")
+	}
+}
+
+func BenchmarkReadAt(b *testing.B) {
+	for _, size := range []int{64, 4 << 10, 512 << 10} {
+		for _, sharedMemory := range []bool{false, true} {
+			name := strconv.Itoa(size) + "/unix"
+			if sharedMemory {
+				name = strconv.Itoa(size) + "/shm"
+			}
+			b.Run(name, func(b *testing.B) {
+				f, done := openFile(b, make([]byte, size), sharedMemory)
+				defer done()
+
+				buf := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := f.ReadAt(buf, 0); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package p9
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// Dial connects to the server at addr on the named network, such as "tcp" or
+// "unix", and returns a client for it.
+func Dial(network, addr string, o ...ClientOpt) (*Client, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return newConnClient(conn, o...)
+}
+
+// DialTLS connects to the server at addr on the named network using TLS with
+// the given configuration, and returns a client for it.
+//
+// To authenticate to servers that require a client certificate, set
+// config.Certificates. The server identifies the client by the certificate.
+func DialTLS(network, addr string, config *tls.Config, o ...ClientOpt) (*Client, error) {
+	conn, err := tls.Dial(network, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return newConnClient(conn, o...)
+}
+
+// newConnClient returns a client for conn, which is closed if the client
+// cannot be created.
+func newConnClient(conn net.Conn, o ...ClientOpt) (*Client, error) {
+	c, err := NewClient(conn, o...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// certUser returns the user named by the verified client certificate of a
+// TLS connection, or "" if there is none.
+func certUser(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
@@ -0,0 +1,146 @@
+package p9_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hugelgupf/p9/p9"
+)
+
+// testCA is a certificate authority for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "p9 test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue issues a certificate for the given common name, valid for the
+// loopback address.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestDialTLS(t *testing.T) {
+	ca := newTestCA(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    ca.pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := userAttacher{users: make(chan user, 1)}
+	s := p9.NewServer(a)
+	done := make(chan struct{})
+	go func() {
+		_ = s.Serve(l)
+		close(done)
+	}()
+	defer func() {
+		l.Close()
+		<-done
+	}()
+
+	for _, tt := range []struct {
+		name  string
+		certs []tls.Certificate
+		want  user
+	}{
+		{
+			name: "no client certificate",
+			want: user{"bob", 1000},
+		},
+		{
+			name:  "client certificate",
+			certs: []tls.Certificate{ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)},
+			want:  user{"alice", p9.NoUID},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := p9.DialTLS("tcp", l.Addr().String(), &tls.Config{
+				RootCAs:      ca.pool,
+				Certificates: tt.certs,
+			})
+			if err != nil {
+				t.Fatalf("DialTLS = %v", err)
+			}
+			defer c.Close()
+
+			// The user given by the client is ignored if it has a
+			// certificate.
+			f, err := c.AttachUser("", "bob", 1000)
+			if err != nil {
+				t.Fatalf("Attach = %v", err)
+			}
+			f.Close()
+			if got := <-a.users; got != tt.want {
+				t.Errorf("AttachUser got user %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Certificates from other CAs are rejected.
+	other := newTestCA(t)
+	if c, err := p9.DialTLS("tcp", l.Addr().String(), &tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: []tls.Certificate{other.issue(t, "mallory", x509.ExtKeyUsageClientAuth)},
+	}); err == nil {
+		c.Close()
+		t.Errorf("DialTLS with untrusted client certificate succeeded")
+	}
+}
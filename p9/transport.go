@@ -20,6 +20,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"os"
 	"sync"
 
 	"github.com/hugelgupf/p9/vecnet"
@@ -86,9 +87,10 @@ func send(l ulog.Logger, w io.Writer, tag tag, m message) error {
 
 	l.Printf("send [w %p] [Tag %06d] %s", w, tag, m)
 
-	// Get our vectors to send.
+	// Get our vectors to send, which are written with a single syscall
+	// where possible.
 	var hdr [headerLength]byte
-	vecs := make(net.Buffers, 0, 3)
+	vecs := make(vecnet.Buffers, 0, 3)
 	vecs = append(vecs, hdr[:])
 	if len(dataBuf.data) > 0 {
 		vecs = append(vecs, dataBuf.data)
@@ -105,15 +107,47 @@ func send(l ulog.Logger, w io.Writer, tag tag, m message) error {
 		defer payloader.PayloadCleanup()
 	}
 
+	// Or a payload written separately, after the header?
+	var filePayload Payload
+	if fp, ok := m.(filePayloader); ok {
+		filePayload = fp.FilePayload()
+		totalLength += uint32(filePayload.Len())
+		defer filePayload.Close()
+	}
+
 	// Construct the header.
 	headerBuf := buffer{data: hdr[:0]}
 	headerBuf.Write32(totalLength)
 	headerBuf.WriteMsgType(m.typ())
 	headerBuf.WriteTag(tag)
 
-	if _, err := vecs.WriteTo(w); err != nil {
+	// Is there a file descriptor to send with the message?
+	var fd *os.File
+	if fc, ok := m.(fdCarrier); ok {
+		fd = fc.donatedFD()
+	}
+
+	if fd != nil {
+		defer fd.Close()
+		uc, ok := w.(*net.UnixConn)
+		if !ok {
+			return ConnError{errors.New("file descriptors can only be sent on unix sockets")}
+		}
+		if err := writeWithFD(uc, vecs, fd); err != nil {
+			return ConnError{err}
+		}
+	} else if _, err := vecs.WriteTo(w); err != nil {
 		return ConnError{err}
 	}
+	if filePayload != nil && filePayload.Len() > 0 {
+		n, err := filePayload.WriteTo(w)
+		if err == nil && n != int64(filePayload.Len()) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return ConnError{err}
+		}
+	}
 
 	// All set.
 	dataPool.Put(&dataBuf.data)
@@ -127,6 +161,13 @@ func send(l ulog.Logger, w io.Writer, tag tag, m message) error {
 // lookupTagAndType function (by design).
 type lookupTagAndType func(tag tag, t msgType) (message, error)
 
+// fdReceiver is a connection that receives file descriptors with messages.
+type fdReceiver interface {
+	// attachFDs attaches the file descriptors received since the last
+	// call to m, or closes them if m does not carry one. m may be nil.
+	attachFDs(m message)
+}
+
 // recv decodes a message from the socket.
 //
 // This is done in two parts, and is thus not safe for multiple callers.
@@ -135,6 +176,15 @@ type lookupTagAndType func(tag tag, t msgType) (message, error)
 //
 // The tag value NoTag will always be returned if err is non-nil.
 func recv(l ulog.Logger, r io.Reader, msize uint32, lookup lookupTagAndType) (tag, message, error) {
+	// File descriptors received with the message are attached to it, or
+	// closed if it is not received successfully.
+	var received message
+	if fr, ok := r.(fdReceiver); ok {
+		defer func() {
+			fr.attachFDs(received)
+		}()
+	}
+
 	// Read a header.
 	var hdr [headerLength]byte
 
@@ -241,5 +291,6 @@ func recv(l ulog.Logger, r io.Reader, msize uint32, lookup lookupTagAndType) (ta
 	l.Printf("recv [r %p] [Tag %06d] %s", r, tag, m)
 
 	// All set.
+	received = m
 	return tag, m, nil
 }
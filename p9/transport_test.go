@@ -15,7 +15,9 @@
 package p9
 
 import (
+	"bytes"
 	"net"
+	"os"
 	"reflect"
 	"testing"
 
@@ -146,3 +148,34 @@ func TestSendAndRecv(t *testing.T) {
 		})
 	}
 }
+
+// TestSendAndRecvPipe sends messages over pipes, which are read with readv
+// rather than recvmsg.
+func TestSendAndRecvPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	l := ulogtest.Logger{TB: t}
+	want := &twrite{fid: 1, Offset: 2, Data: bytes.Repeat([]byte("data"), 100000)}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- send(l, w, tag(1), want)
+	}()
+	tg, m, err := recv(l, r, maximumLength, msgDotLRegistry.get)
+	if err != nil {
+		t.Fatalf("recv() = %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("send() = %v", err)
+	}
+	if tg != tag(1) {
+		t.Errorf("got tag %v, want 1", tg)
+	}
+	if got, ok := m.(*twrite); !ok || got.fid != want.fid || got.Offset != want.Offset || !bytes.Equal(got.Data, want.Data) {
+		t.Errorf("got message %v, want %v", m, want)
+	}
+}
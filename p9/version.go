@@ -26,7 +26,13 @@ const (
 	//
 	// Clients are expected to start requesting this version number and
 	// to continuously decrement it until a Tversion request succeeds.
-	highestSupportedVersion uint32 = 7
+	//
+	// Version numbers up to 7 are shared with gVisor's p9 package, from
+	// which this one is derived. Versions 8 (file descriptor donation) and
+	// 9 (Tchannel) are this package's own, and gVisor gives them other
+	// meanings, so peers using gVisor's package only interoperate with
+	// this one at version 7 or below.
+	highestSupportedVersion uint32 = 9
 
 	// lowestSupportedVersion is the lowest supported version X in a
 	// version string of the format 9P2000.L.Google.X.
@@ -132,3 +138,17 @@ func versionSupportsTucreation(v uint32) bool {
 func VersionSupportsMultiUser(v uint32) bool {
 	return v >= 6
 }
+
+// versionSupportsFDDonation returns true if version v supports receiving host
+// file descriptors with Rlopen and Rlcreate. Servers must check this
+// predicate before sending file descriptors.
+func versionSupportsFDDonation(v uint32) bool {
+	return v >= 8
+}
+
+// versionSupportsChannels returns true if version v supports moving the
+// connection to shared memory with Tchannel. This predicate must be checked
+// by clients before attempting to make a Tchannel request.
+func versionSupportsChannels(v uint32) bool {
+	return v >= 9
+}
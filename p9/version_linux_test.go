@@ -0,0 +1,126 @@
+package p9
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hugelgupf/p9/linux"
+	"github.com/u-root/uio/ulog/ulogtest"
+)
+
+// fdFile is a HostFile that only implements FD.
+type fdFile struct {
+	File
+	fd *os.File
+}
+
+func (f fdFile) FD() (*os.File, bool) {
+	return f.fd, true
+}
+
+// TestVersionOlderClient checks that a server offering file descriptor
+// donation and shared memory uses neither with a client at version 7, such
+// as one using gVisor's p9 package.
+func TestVersionOlderClient(t *testing.T) {
+	srv, cli := unixPair(t)
+	l := ulogtest.Logger{TB: t}
+	s := NewServer(blockingAttacher(nil), WithFDDonation(), WithServerSharedMemory(), WithServerLogger(l))
+	done := make(chan struct{})
+	go func() {
+		_ = s.Handle(srv, srv)
+		close(done)
+	}()
+	defer func() {
+		cli.Close()
+		<-done
+	}()
+	if err := cli.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := send(l, cli, noTag, &tversion{Version: "9P2000.L.Google.7", MSize: maximumLength}); err != nil {
+		t.Fatal(err)
+	}
+	if _, r, err := recv(l, cli, maximumLength, msgDotLRegistry.get); err != nil {
+		t.Fatal(err)
+	} else if rv, ok := r.(*rversion); !ok || rv.Version != "9P2000.L.Google.7" {
+		t.Fatalf("Tversion(9P2000.L.Google.7) = %v, want 9P2000.L.Google.7", r)
+	}
+
+	if err := send(l, cli, 1, &tchannel{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, r, err := recv(l, cli, maximumLength, msgDotLRegistry.get); err != nil {
+		t.Fatal(err)
+	} else if err, ok := r.(*rlerror); !ok || err.Error != uint32(linux.ENOSYS) {
+		t.Errorf("Tchannel at version 7 = %v, want ENOSYS", r)
+	}
+
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, tt := range []struct {
+		version uint32
+		donated bool
+	}{
+		{7, false},
+		{8, true},
+	} {
+		cs := &connState{server: s, sock: srv, version: tt.version}
+		fd := cs.donateFD(fdFile{fd: f})
+		if (fd != nil) != tt.donated {
+			t.Errorf("donateFD at version %d = %v, want donated %t", tt.version, fd, tt.donated)
+		}
+		if fd != nil {
+			fd.Close()
+		}
+	}
+}
+
+// TestVersionOlderServer checks that a client asking for shared memory
+// neither sends Tchannel nor expects file descriptors from a server at
+// version 7, such as one using gVisor's p9 package.
+func TestVersionOlderServer(t *testing.T) {
+	srv, cli := unixPair(t)
+	l := ulogtest.Logger{TB: t}
+	if err := srv.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		tg, m, err := recv(l, srv, maximumLength, msgDotLRegistry.get)
+		if err != nil {
+			errc <- err
+			return
+		}
+		tv := m.(*tversion)
+		if tv.Version != HighestVersionString() {
+			t.Errorf("Tversion = %q, want %q", tv.Version, HighestVersionString())
+		}
+		errc <- send(l, srv, tg, &rversion{MSize: tv.MSize, Version: "9P2000.L.Google.7"})
+	}()
+
+	c, err := NewClient(cli, WithClientSharedMemory(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if v := c.Version(); v != 7 {
+		t.Errorf("Version = %d, want 7", v)
+	}
+	if c.conn != cli {
+		t.Errorf("client reads file descriptors at version 7")
+	}
+
+	// Nothing but Tversion was sent.
+	c.Close()
+	if _, m, err := recv(l, srv, maximumLength, msgDotLRegistry.get); err == nil {
+		t.Errorf("client sent %v after Tversion", m)
+	}
+}
@@ -32,15 +32,21 @@ import (
 type xattrFile struct {
 	templatefs.NoopFile
 	xattrs map[string][]byte
+	closed bool
 }
 
 func (f *xattrFile) Walk(names []string) ([]p9.QID, p9.File, error) {
 	if len(names) == 0 {
-		return []p9.QID{f.qid()}, f, nil
+		return []p9.QID{f.qid()}, &xattrFile{xattrs: f.xattrs}, nil
 	}
 	return nil, nil, linux.ENOENT
 }
 
+func (f *xattrFile) Close() error {
+	f.closed = true
+	return nil
+}
+
 func (f *xattrFile) GetAttr(p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
 	return f.qid(), p9.AttrMask{Mode: true}, p9.Attr{Mode: p9.ModeRegular | 0o644}, nil
 }
@@ -62,6 +68,22 @@ func (f *xattrFile) ListXattrs() ([]string, error) {
 	return names, nil
 }
 
+func (f *xattrFile) SetXattr(attr string, data []byte, flags p9.XattrFlags) error {
+	if _, ok := f.xattrs[attr]; ok && flags == p9.XattrCreate {
+		return linux.EEXIST
+	}
+	f.xattrs[attr] = append([]byte(nil), data...)
+	return nil
+}
+
+func (f *xattrFile) RemoveXattr(attr string) error {
+	if _, ok := f.xattrs[attr]; !ok {
+		return linux.ENODATA
+	}
+	delete(f.xattrs, attr)
+	return nil
+}
+
 func (f *xattrFile) qid() p9.QID { return p9.QID{Type: p9.TypeRegular, Path: 1} }
 
 type xattrAttacher struct{ root *xattrFile }
@@ -139,6 +161,11 @@ func TestClientGetXattr(t *testing.T) {
 			t.Errorf("GetXattr missing: got = %v, want = ENODATA", err)
 		}
 	})
+
+	// Clunking the attribute's fid used to close the file it was read from.
+	if root.closed {
+		t.Errorf("GetXattr closed the file")
+	}
 }
 
 // TestClientListXattrs round-trips the attribute name list through the client.
@@ -159,3 +186,44 @@ func TestClientListXattrs(t *testing.T) {
 		t.Errorf("ListXattrs: got = %v, want = [user.a user.b]", got)
 	}
 }
+
+// TestClientSetXattr sets and removes attributes through the client, which
+// used to return ENOSYS for both.
+func TestClientSetXattr(t *testing.T) {
+	root := &xattrFile{xattrs: map[string][]byte{
+		"user.old": []byte("old"),
+	}}
+	f, cleanup := serveXattr(t, root)
+	defer cleanup()
+
+	if err := f.SetXattr("user.new", []byte("new value"), 0); err != nil {
+		t.Fatalf("SetXattr: got = %v, want = nil", err)
+	}
+	if got, err := f.GetXattr("user.new"); err != nil || string(got) != "new value" {
+		t.Errorf("GetXattr after SetXattr: got = %q, %v, want = %q", got, err, "new value")
+	}
+	if err := f.SetXattr("user.new", nil, p9.XattrCreate); !errors.Is(err, linux.EEXIST) {
+		t.Errorf("SetXattr existing with XattrCreate: got = %v, want = EEXIST", err)
+	}
+	if err := f.SetXattr("user.empty", nil, 0); err != nil {
+		t.Errorf("SetXattr empty: got = %v, want = nil", err)
+	}
+
+	if err := f.RemoveXattr("user.old"); err != nil {
+		t.Fatalf("RemoveXattr: got = %v, want = nil", err)
+	}
+	if _, err := f.GetXattr("user.old"); !errors.Is(err, linux.ENODATA) {
+		t.Errorf("GetXattr after RemoveXattr: got = %v, want = ENODATA", err)
+	}
+	if err := f.RemoveXattr("user.old"); !errors.Is(err, linux.ENODATA) {
+		t.Errorf("RemoveXattr missing: got = %v, want = ENODATA", err)
+	}
+
+	got, err := f.ListXattrs()
+	if err != nil {
+		t.Fatalf("ListXattrs: got = %v, want = nil", err)
+	}
+	if len(got) != 2 || got[0] != "user.empty" || got[1] != "user.new" {
+		t.Errorf("ListXattrs: got = %v, want = [user.empty user.new]", got)
+	}
+}
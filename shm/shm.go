@@ -0,0 +1,47 @@
+// Package shm provides connections over shared memory between two processes
+// on the same host.
+//
+// A connection is a memfd holding a ring buffer for each direction. Readers
+// and writers wait for each other on futexes in the shared memory, so no
+// system calls are made while both sides keep up with each other.
+//
+// The memfd is created by one end with New and passed to the other end,
+// which maps it with Open. Each end is given a unix socket connected to the
+// other end, which is used to find out when the other end goes away.
+//
+// Connections are only supported on Linux.
+package shm
+
+import "errors"
+
+const (
+	// MinSize is the smallest ring size.
+	MinSize = 4 << 10
+
+	// MaxSize is the largest ring size.
+	MaxSize = 1 << 28
+
+	// DefaultSize is the ring size used when none is given.
+	DefaultSize = 1 << 20
+)
+
+// ErrCorrupt is returned if the other end left the rings in an invalid
+// state.
+var ErrCorrupt = errors.New("shm: corrupt ring")
+
+// RoundSize returns size rounded up to a valid ring size, or DefaultSize if
+// size is 0.
+func RoundSize(size int) int {
+	if size == 0 {
+		return DefaultSize
+	}
+	r := MinSize
+	for r < size && r < MaxSize {
+		r <<= 1
+	}
+	return r
+}
+
+func validSize(size int) bool {
+	return size >= MinSize && size <= MaxSize && size&(size-1) == 0
+}
@@ -0,0 +1,370 @@
+package shm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The memory starts with a header, followed by the data of both rings:
+//
+//	[0, headerSize)                       header
+//	[headerSize, headerSize+size)         ring 0, written by the end that called New
+//	[headerSize+size, headerSize+2*size)  ring 1, written by the end that called Open
+//
+// Fields written by different ends are kept on different cache lines.
+const (
+	headerSize = 4 << 10
+
+	// magic identifies the memory as a connection.
+	magic = 0x39505348 // "9PSH"
+
+	offMagic  = 0
+	offSize   = 4
+	offClosed = 64
+
+	// offRing is the offset of the first ring's header, and ringHeaderSize
+	// the size of a ring's header.
+	offRing        = 256
+	ringHeaderSize = 256
+
+	// Offsets in a ring's header.
+	offHead    = 0
+	offTail    = 64
+	offEvent   = 128
+	offWaiters = 132
+)
+
+// spinCount is the number of times wait checks for the other end before
+// going to sleep.
+const spinCount = 100
+
+// futex operations on memory shared between processes, which must not use
+// FUTEX_PRIVATE_FLAG.
+const (
+	futexWait = 0
+	futexWake = 1
+)
+
+// ring is one direction of a connection.
+//
+// head is the position up to which data was written, and tail the position
+// up to which it was read. Positions wrap around, and are taken modulo the
+// size of data.
+//
+// event is incremented whenever head, tail or the closed word change, and
+// waiters counts the ends waiting for it to change.
+type ring struct {
+	head    *uint32
+	tail    *uint32
+	event   *uint32
+	waiters *uint32
+	data    []byte
+}
+
+// Conn is one end of a connection over shared memory.
+//
+// Reads and writes may be called concurrently with each other, and with
+// Close.
+type Conn struct {
+	mem []byte
+
+	// closed is the shared word set when either end is closed.
+	closed *uint32
+
+	// rx is read from, and tx written to.
+	rx ring
+	tx ring
+
+	// ctrl is connected to the other end, if not nil.
+	ctrl        *net.UnixConn
+	monitorDone chan struct{}
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+
+	// done is set when this end is closed, after which mem must not be
+	// touched without readMu and writeMu.
+	done      atomic.Bool
+	closeOnce sync.Once
+}
+
+// New creates a connection with rings of size bytes in each direction, which
+// must be a power of two between MinSize and MaxSize.
+//
+// It returns this end of the connection and the memory file to pass to the
+// other end's Open. The file is closed by the caller once it is passed on.
+//
+// ctrl, if not nil, is connected to the other end and is closed with the
+// connection. The connection is shut down when the other end closes it.
+func New(ctrl *net.UnixConn, size int) (*Conn, *os.File, error) {
+	if !validSize(size) {
+		return nil, nil, fmt.Errorf("shm: invalid ring size %d", size)
+	}
+	fd, err := unix.MemfdCreate("p9-shm", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("memfd_create", err)
+	}
+	f := os.NewFile(uintptr(fd), "p9-shm")
+	mem, err := mapFile(f, headerSize+2*size)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	*word(mem, offMagic) = magic
+	*word(mem, offSize) = uint32(size)
+	return newConn(ctrl, mem, size, 0), f, nil
+}
+
+// mapFile sizes the new memory file f, seals its size so that the other end
+// cannot shrink it from under us, and maps it.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	if err := f.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+	if _, err := unix.FcntlInt(f.Fd(), unix.F_ADD_SEALS, unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_SEAL); err != nil {
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	mem, err := unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	return mem, nil
+}
+
+// Open opens the other end of a connection from the memory file returned by
+// New. The file may be closed once Open returns.
+//
+// ctrl, if not nil, is connected to the end that called New and is closed
+// with the connection. The connection is shut down when the other end closes
+// it.
+func Open(ctrl *net.UnixConn, f *os.File) (*Conn, error) {
+	seals, err := unix.FcntlInt(f.Fd(), unix.F_GET_SEALS, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	if seals&unix.F_SEAL_SHRINK == 0 {
+		return nil, fmt.Errorf("shm: memory file can be shrunk")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < headerSize || fi.Size() > headerSize+2*MaxSize {
+		return nil, fmt.Errorf("shm: invalid memory file size %d", fi.Size())
+	}
+	mem, err := unix.Mmap(int(f.Fd()), 0, int(fi.Size()), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	size := int(*word(mem, offSize))
+	if *word(mem, offMagic) != magic || !validSize(size) || int64(headerSize+2*size) != fi.Size() {
+		unix.Munmap(mem)
+		return nil, fmt.Errorf("shm: not a connection")
+	}
+	return newConn(ctrl, mem, size, 1), nil
+}
+
+// newConn returns the end of a connection that writes to ring tx.
+func newConn(ctrl *net.UnixConn, mem []byte, size int, tx int) *Conn {
+	c := &Conn{
+		mem:    mem,
+		closed: word(mem, offClosed),
+		rx:     newRing(mem, size, 1-tx),
+		tx:     newRing(mem, size, tx),
+		ctrl:   ctrl,
+	}
+	if ctrl != nil {
+		c.monitorDone = make(chan struct{})
+		go c.monitor()
+	}
+	return c
+}
+
+func newRing(mem []byte, size int, i int) ring {
+	hdr := offRing + i*ringHeaderSize
+	data := headerSize + i*size
+	return ring{
+		head:    word(mem, hdr+offHead),
+		tail:    word(mem, hdr+offTail),
+		event:   word(mem, hdr+offEvent),
+		waiters: word(mem, hdr+offWaiters),
+		data:    mem[data : data+size : data+size],
+	}
+}
+
+// word returns the 32-bit word at offset off of mem.
+func word(mem []byte, off int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&mem[off]))
+}
+
+// monitor shuts the connection down once the other end closes ctrl, which
+// happens when it exits without closing the connection.
+func (c *Conn) monitor() {
+	defer close(c.monitorDone)
+	var b [16]byte
+	for {
+		if _, err := c.ctrl.Read(b[:]); err != nil {
+			break
+		}
+	}
+	c.shutdown()
+}
+
+// shutdown marks the connection as closed, and wakes up both ends.
+func (c *Conn) shutdown() {
+	atomic.StoreUint32(c.closed, 1)
+	notify(&c.rx)
+	notify(&c.tx)
+}
+
+// notify wakes up the ends waiting on r.
+func notify(r *ring) {
+	atomic.AddUint32(r.event, 1)
+	if atomic.LoadUint32(r.waiters) > 0 {
+		futex(r.event, futexWake, math.MaxInt32)
+	}
+}
+
+// wait waits until ready returns true, or something changes on r.
+//
+// The other end usually responds quickly, so it spins for a while before
+// going to sleep.
+func wait(r *ring, ready func() bool) {
+	for i := 0; i < spinCount; i++ {
+		if ready() {
+			return
+		}
+		runtime.Gosched()
+	}
+
+	atomic.AddUint32(r.waiters, 1)
+	// Anything that makes ready true after this load also changes event,
+	// so futexWait returns immediately.
+	ev := atomic.LoadUint32(r.event)
+	if !ready() {
+		futex(r.event, futexWait, ev)
+	}
+	atomic.AddUint32(r.waiters, ^uint32(0))
+}
+
+func futex(addr *uint32, op int, val uint32) {
+	unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), uintptr(op), uintptr(val), 0, 0, 0)
+}
+
+// Read implements io.Reader.Read.
+//
+// It returns io.EOF once the other end is closed and everything it wrote has
+// been read.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	r := &c.rx
+	for {
+		if c.done.Load() {
+			return 0, net.ErrClosed
+		}
+		if len(p) == 0 {
+			return 0, nil
+		}
+		head := atomic.LoadUint32(r.head)
+		tail := atomic.LoadUint32(r.tail)
+		if used := head - tail; used > 0 {
+			if used > uint32(len(r.data)) {
+				return 0, ErrCorrupt
+			}
+			n := r.copyOut(p[:min(len(p), int(used))], tail)
+			atomic.StoreUint32(r.tail, tail+uint32(n))
+			notify(r)
+			return n, nil
+		}
+		if atomic.LoadUint32(c.closed) != 0 {
+			return 0, io.EOF
+		}
+		wait(r, func() bool {
+			return atomic.LoadUint32(r.head) != tail || atomic.LoadUint32(c.closed) != 0
+		})
+	}
+}
+
+// Write implements io.Writer.Write.
+//
+// It returns io.ErrClosedPipe if the other end is closed.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	r := &c.tx
+	var n int
+	for n < len(p) {
+		if c.done.Load() {
+			return n, net.ErrClosed
+		}
+		if atomic.LoadUint32(c.closed) != 0 {
+			return n, io.ErrClosedPipe
+		}
+		head := atomic.LoadUint32(r.head)
+		tail := atomic.LoadUint32(r.tail)
+		used := head - tail
+		if used > uint32(len(r.data)) {
+			return n, ErrCorrupt
+		}
+		if free := len(r.data) - int(used); free > 0 {
+			m := r.copyIn(p[n:n+min(len(p)-n, free)], head)
+			atomic.StoreUint32(r.head, head+uint32(m))
+			notify(r)
+			n += m
+			continue
+		}
+		wait(r, func() bool {
+			return atomic.LoadUint32(r.tail) != tail || atomic.LoadUint32(c.closed) != 0
+		})
+	}
+	return n, nil
+}
+
+// copyOut copies data at position pos into p.
+func (r *ring) copyOut(p []byte, pos uint32) int {
+	off := int(pos) & (len(r.data) - 1)
+	n := copy(p, r.data[off:])
+	return n + copy(p[n:], r.data)
+}
+
+// copyIn copies p into data at position pos.
+func (r *ring) copyIn(p []byte, pos uint32) int {
+	off := int(pos) & (len(r.data) - 1)
+	n := copy(r.data[off:], p)
+	return n + copy(r.data, p[n:])
+}
+
+// Close implements io.Closer.Close.
+//
+// The other end reads io.EOF once it has read everything written.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.done.Store(true)
+		c.shutdown()
+		if c.ctrl != nil {
+			c.ctrl.Close()
+			<-c.monitorDone
+		}
+
+		// Wait for reads and writes to notice before unmapping.
+		c.readMu.Lock()
+		c.writeMu.Lock()
+		unix.Munmap(c.mem)
+		c.mem = nil
+		c.writeMu.Unlock()
+		c.readMu.Unlock()
+	})
+	return nil
+}
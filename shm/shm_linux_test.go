@@ -0,0 +1,216 @@
+package shm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// unixPair returns a connected pair of unix sockets.
+func unixPair(t testing.TB) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := func(fd int) *net.UnixConn {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		defer f.Close()
+		c, err := net.FileConn(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.(*net.UnixConn)
+	}
+	return conn(fds[0]), conn(fds[1])
+}
+
+// pair returns both ends of a connection with rings of the given size.
+func pair(t testing.TB, size int) (*Conn, *Conn) {
+	ctrl1, ctrl2 := unixPair(t)
+	c1, f, err := New(ctrl1, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	c2, err := Open(ctrl2, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c1, c2
+}
+
+func TestRoundSize(t *testing.T) {
+	for _, tt := range []struct {
+		size, want int
+	}{
+		{0, DefaultSize},
+		{1, MinSize},
+		{MinSize + 1, 2 * MinSize},
+		{1 << 20, 1 << 20},
+		{MaxSize + 1, MaxSize},
+	} {
+		if got := RoundSize(tt.size); got != tt.want {
+			t.Errorf("RoundSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	c1, c2 := pair(t, MinSize)
+	defer c1.Close()
+	defer c2.Close()
+
+	// Larger than the ring, so both ends wait for each other.
+	data := make([]byte, 1<<20+3)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, tt := range []struct {
+		name string
+		w, r *Conn
+	}{
+		{"new to open", c1, c2},
+		{"open to new", c2, c1},
+	} {
+		errc := make(chan error, 1)
+		go func() {
+			_, err := tt.w.Write(data)
+			errc <- err
+		}()
+		got := make([]byte, len(data))
+		if _, err := io.ReadFull(tt.r, got); err != nil {
+			t.Fatalf("%s: Read = %v", tt.name, err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("%s: Write = %v", tt.name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: read data differs from written data", tt.name)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	c1, c2 := pair(t, MinSize)
+	defer c2.Close()
+
+	if _, err := c1.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	c1.Close()
+
+	// What was written before closing is still read.
+	got, err := io.ReadAll(c2)
+	if err != nil || string(got) != "bye" {
+		t.Errorf("ReadAll = %q, %v, want bye", got, err)
+	}
+	if _, err := c2.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Write after other end closed = %v, want %v", err, io.ErrClosedPipe)
+	}
+	if _, err := c1.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Read after Close = %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestCloseWhileReading(t *testing.T) {
+	c1, c2 := pair(t, MinSize)
+	defer c2.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		errc <- err
+	}()
+	c1.Close()
+	if err := <-errc; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Read = %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestPeerGone(t *testing.T) {
+	ctrl1, ctrl2 := unixPair(t)
+	c1, f, err := New(ctrl1, MinSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer c1.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		errc <- err
+	}()
+	// The other end never opened the memory, and went away.
+	ctrl2.Close()
+	if err := <-errc; err != io.EOF {
+		t.Errorf("Read = %v, want EOF", err)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := Open(nil, f); err == nil {
+		t.Errorf("Open(regular file) = nil, want error")
+	}
+	if _, _, err := New(nil, MinSize+1); err == nil {
+		t.Errorf("New(MinSize+1) = nil, want error")
+	}
+}
+
+func benchmarkThroughput(b *testing.B, w io.Writer, r io.Reader, size int) {
+	buf := make([]byte, size)
+	b.SetBytes(int64(size))
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if _, err := w.Write(buf); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+	rbuf := make([]byte, size)
+	for i := 0; i < b.N; i++ {
+		if _, err := io.ReadFull(r, rbuf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkThroughput(b *testing.B) {
+	for _, size := range []int{64, 4 << 10, 1 << 20} {
+		b.Run(byteSize(size), func(b *testing.B) {
+			b.Run("unix", func(b *testing.B) {
+				c1, c2 := unixPair(b)
+				defer c1.Close()
+				defer c2.Close()
+				benchmarkThroughput(b, c1, c2, size)
+			})
+			b.Run("shm", func(b *testing.B) {
+				c1, c2 := pair(b, DefaultSize)
+				defer c1.Close()
+				defer c2.Close()
+				benchmarkThroughput(b, c1, c2, size)
+			})
+		})
+	}
+}
+
+func byteSize(n int) string {
+	switch {
+	case n >= 1<<20:
+		return strconv.Itoa(n>>20) + "M"
+	case n >= 1<<10:
+		return strconv.Itoa(n>>10) + "K"
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+
+package shm
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// Conn is one end of a connection over shared memory.
+type Conn struct{}
+
+// New is not supported, and returns errors.ErrUnsupported.
+func New(ctrl *net.UnixConn, size int) (*Conn, *os.File, error) {
+	return nil, nil, errors.ErrUnsupported
+}
+
+// Open is not supported, and returns errors.ErrUnsupported.
+func Open(ctrl *net.UnixConn, f *os.File) (*Conn, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Read implements io.Reader.Read.
+func (c *Conn) Read(p []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// Write implements io.Writer.Write.
+func (c *Conn) Write(p []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// Close implements io.Closer.Close.
+func (c *Conn) Close() error {
+	return nil
+}
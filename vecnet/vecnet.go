@@ -12,7 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package vecnet provides access to recvmsg syscalls on net.Conns.
+// Package vecnet provides access to vectored I/O syscalls on net.Conns and
+// files.
 package vecnet
 
 import (
@@ -24,7 +25,8 @@ import (
 // Buffers points to zero or more buffers to read into.
 //
 // On connections that support it, ReadFrom is optimized into the batch read
-// operation recvmsg.
+// operation recvmsg (or readv for files such as pipes and character devices),
+// and WriteTo into the batch write operation writev.
 type Buffers net.Buffers
 
 // ReadFrom reads into the pre-allocated bufs. Returns bytes read.
@@ -52,3 +54,35 @@ func (bufs Buffers) ReadFrom(r io.Reader) (int64, error) {
 	}
 	return total, nil
 }
+
+// coalesceMax is the largest total size of buffers that WriteTo copies into a
+// single buffer on connections without batch writes.
+const coalesceMax = 64 * 1024
+
+// WriteTo writes all of bufs to w, as a single write where possible. Returns
+// bytes written.
+//
+// Unlike net.Buffers.WriteTo, WriteTo does not consume bufs.
+func (bufs Buffers) WriteTo(w io.Writer) (int64, error) {
+	if conn, ok := w.(syscall.Conn); ok && writeToBuffers != nil {
+		return writeToBuffers(bufs, conn)
+	}
+
+	var length int
+	for _, buf := range bufs {
+		length += len(buf)
+	}
+	if len(bufs) > 1 && length <= coalesceMax {
+		// Writing each buffer separately may produce a packet, or a TLS
+		// record, per buffer.
+		b := make([]byte, 0, length)
+		for _, buf := range bufs {
+			b = append(b, buf...)
+		}
+		n, err := w.Write(b)
+		return int64(n), err
+	}
+	// net.Buffers.WriteTo consumes the buffers it writes.
+	nb := append(net.Buffers(nil), bufs...)
+	return nb.WriteTo(w)
+}
@@ -18,12 +18,16 @@ package vecnet
 
 import (
 	"io"
+	"os"
 	"runtime"
 	"syscall"
 	"unsafe"
 )
 
-var readFromBuffers = readFromBuffersLinux
+var (
+	readFromBuffers = readFromBuffersLinux
+	writeToBuffers  = writeToBuffersLinux
+)
 
 func readFromBuffersLinux(bufs Buffers, conn syscall.Conn) (int64, error) {
 	rc, err := conn.SyscallConn()
@@ -36,8 +40,21 @@ func readFromBuffersLinux(bufs Buffers, conn syscall.Conn) (int64, error) {
 		length += int64(len(buf))
 	}
 
+	// Files, such as pipes and character devices, are not sockets, so
+	// recvmsg fails with ENOTSOCK on them. They are read with readv
+	// instead.
+	read := recvmsg
+	if _, ok := conn.(*os.File); ok {
+		read = readv
+	}
+
 	for n := int64(0); n < length; {
-		cur, err := recvmsg(bufs, rc)
+		cur, err := read(bufs, rc)
+		if err == syscall.ENOTSOCK {
+			// Other kinds of syscall.Conn that are not sockets.
+			read = readv
+			continue
+		}
 		if err != nil && (cur == 0 || err != io.EOF) {
 			return n, err
 		}
@@ -109,3 +126,100 @@ func recvmsg(bufs Buffers, rc syscall.RawConn) (int, error) {
 	}
 	return int(n), nil
 }
+
+func readv(bufs Buffers, rc syscall.RawConn) (int, error) {
+	iovecs, length := buildIovec(bufs, make([]syscall.Iovec, 0, 2))
+	if len(iovecs) == 0 {
+		return 0, nil
+	}
+
+	// n is the bytes received.
+	var n uintptr
+	var e syscall.Errno
+	err := rc.Read(func(fd uintptr) bool {
+		for {
+			n, _, e = syscall.Syscall(syscall.SYS_READV, fd, uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+			if e != syscall.EINTR {
+				break
+			}
+		}
+		// Return false if EAGAIN or EWOULDBLOCK to wait until
+		// readable.
+		return !(e == syscall.EAGAIN || e == syscall.EWOULDBLOCK)
+	})
+	runtime.KeepAlive(iovecs)
+	if err != nil {
+		return 0, err
+	}
+	if e != 0 {
+		return 0, e
+	}
+
+	// The other end is closed by returning a 0 length read with no error.
+	if n == 0 {
+		return 0, io.EOF
+	}
+
+	if int(n) > length {
+		return length, io.ErrShortBuffer
+	}
+	return int(n), nil
+}
+
+func writeToBuffersLinux(bufs Buffers, conn syscall.Conn) (int64, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	iovecs, length := buildIovec(bufs, make([]syscall.Iovec, 0, 3))
+	var n int64
+	for n < int64(length) {
+		cur, err := writev(iovecs, rc)
+		n += int64(cur)
+		if err != nil {
+			return n, err
+		}
+
+		// Consume iovecs to retry.
+		for cur > 0 {
+			if l := int(iovecs[0].Len); l <= cur {
+				cur -= l
+				iovecs = iovecs[1:]
+			} else {
+				iovecs[0].Base = (*byte)(unsafe.Add(unsafe.Pointer(iovecs[0].Base), cur))
+				iovecs[0].Len = iovlen(l - cur)
+				break
+			}
+		}
+	}
+	runtime.KeepAlive(bufs)
+	return n, nil
+}
+
+func writev(iovecs []syscall.Iovec, rc syscall.RawConn) (int, error) {
+	// n is the bytes written.
+	var n uintptr
+	var e syscall.Errno
+	err := rc.Write(func(fd uintptr) bool {
+		for {
+			n, _, e = syscall.Syscall(syscall.SYS_WRITEV, fd, uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+			if e != syscall.EINTR {
+				break
+			}
+		}
+		// Return false if EAGAIN or EWOULDBLOCK to wait until
+		// writable.
+		return !(e == syscall.EAGAIN || e == syscall.EWOULDBLOCK)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if e != 0 {
+		return 0, e
+	}
+	if n == 0 {
+		return 0, io.ErrShortWrite
+	}
+	return int(n), nil
+}
@@ -20,4 +20,7 @@ import (
 	"syscall"
 )
 
-var readFromBuffers func(bufs Buffers, conn syscall.Conn) (int64, error)
+var (
+	readFromBuffers func(bufs Buffers, conn syscall.Conn) (int64, error)
+	writeToBuffers  func(bufs Buffers, conn syscall.Conn) (int64, error)
+)
@@ -15,7 +15,9 @@
 package vecnet
 
 import (
+	"bytes"
 	"io"
+	"os"
 	"strings"
 	"testing"
 )
@@ -46,3 +48,115 @@ func TestReadFromSanity(t *testing.T) {
 		t.Errorf("ReadFrom() = (%v, %#v), want (%v, %#v)", s1, s2, s[:10], s[10:])
 	}
 }
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestWriteToSanity(t *testing.T) {
+	for _, tt := range []struct {
+		bufs   Buffers
+		writes int
+	}{
+		{Buffers{[]byte("0123"), nil, []byte("456789")}, 1},
+		{Buffers{[]byte("0123")}, 1},
+		{Buffers{make([]byte, coalesceMax), []byte("0")}, 2},
+	} {
+		orig := append(Buffers(nil), tt.bufs...)
+		want := bytes.Join(tt.bufs, nil)
+
+		var w countingWriter
+		n, err := tt.bufs.WriteTo(&w)
+		if err != nil || int(n) != len(want) {
+			t.Errorf("WriteTo() = %d, %v, want %d", n, err, len(want))
+		}
+		if !bytes.Equal(w.Bytes(), want) {
+			t.Errorf("WriteTo() wrote %q, want %q", w.Bytes(), want)
+		}
+		if w.writes != tt.writes {
+			t.Errorf("WriteTo() used %d writes, want %d", w.writes, tt.writes)
+		}
+		for i := range orig {
+			if !bytes.Equal(tt.bufs[i], orig[i]) {
+				t.Errorf("WriteTo() consumed bufs[%d]", i)
+			}
+		}
+	}
+}
+
+func TestWriteToPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Larger than the pipe buffer, so that writes are partial.
+	bufs := Buffers{[]byte("header"), make([]byte, 1<<20), []byte("trailer")}
+	for i := range bufs[1] {
+		bufs[1][i] = byte(i)
+	}
+	want := bytes.Join(bufs, nil)
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer w.Close()
+		n, err := bufs.WriteTo(w)
+		if err == nil && int(n) != len(want) {
+			err = io.ErrShortWrite
+		}
+		errCh <- err
+	}()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("WriteTo() = %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("WriteTo() wrote %d bytes that differ from the %d bytes written", len(got), len(want))
+	}
+}
+
+func TestReadFromPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	want := make([]byte, 1<<20)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	go func() {
+		defer w.Close()
+		// Write in chunks so that reads are partial.
+		for b := want; len(b) > 0; b = b[min(len(b), 1000):] {
+			if _, err := w.Write(b[:min(len(b), 1000)]); err != nil {
+				return
+			}
+		}
+	}()
+
+	hdr, data := make([]byte, 7), make([]byte, len(want)-7)
+	n, err := Buffers{hdr, data}.ReadFrom(r)
+	if err != nil || int(n) != len(want) {
+		t.Fatalf("ReadFrom() = %d, %v, want %d", n, err, len(want))
+	}
+	if got := append(hdr, data...); !bytes.Equal(got, want) {
+		t.Errorf("ReadFrom() read different bytes than written")
+	}
+
+	// The writer is closed.
+	if n, err := (Buffers{make([]byte, 1)}).ReadFrom(r); err != io.EOF {
+		t.Errorf("ReadFrom() = %d, %v, want EOF", n, err)
+	}
+}